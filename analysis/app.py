@@ -1,32 +1,115 @@
-from fastapi import FastAPI, HTTPException, File, Form, UploadFile
+from fastapi import FastAPI, HTTPException, File, Form, UploadFile, Response
 from pydantic import BaseModel
+from typing import List, Optional
 import pandas as pd
 import numpy as np
 import plotly.express as px
 import plotly.graph_objects as go
 import plotly.io as pio
+from plotly.offline import get_plotlyjs
 import traceback
 import logging
 import io
+import base64
+import pdfplumber
 
 app = FastAPI()
 
 class CodeRequest(BaseModel):
     code: str
 
-@app.post("/generate-chart/")
-async def generate_chart(code: str = Form(...), file: UploadFile = File(...)):
-    # Read file into a Pandas DataFrame
+class Dialect(BaseModel):
+    delimiter: Optional[str] = None
+    quote: Optional[str] = None
+    encoding: Optional[str] = None
+    bom: bool = False
+    skip_lines: int = 0
+    sheets: Optional[List[str]] = None
+
+
+def python_encoding(dialect):
+    # The sniffed encodings are python codec names, files starting with a byte order mark need the codec skipping it
+    if dialect.bom and dialect.encoding == "utf-8":
+        return "utf-8-sig"
+    if dialect.bom and dialect.encoding in ("utf-16le", "utf-16be"):
+        return "utf-16"
+    return dialect.encoding or None
+
+
+def read_delimited(content, dialect, default_delimiter):
+    # Rows with more fields than the header row are skipped, as the web service skips them when profiling
+    return pd.read_csv(content, sep=dialect.delimiter or default_delimiter, quotechar=dialect.quote or '"',
+                       encoding=python_encoding(dialect), skiprows=dialect.skip_lines, on_bad_lines="skip")
+
+
+# Readers for the format hints sent by the web service
+READERS = {
+    "csv": lambda content, dialect: read_delimited(content, dialect, ","),
+    "tsv": lambda content, dialect: read_delimited(content, dialect, "\t"),
+    "excel": lambda content, dialect: pd.read_excel(content, sheet_name=dialect.sheets or 0),
+    "ods": lambda content, dialect: pd.read_excel(content, engine="odf"),
+    "json": lambda content, dialect: pd.read_json(content, orient="records"),
+    "ndjson": lambda content, dialect: pd.read_json(content, lines=True),
+    "parquet": lambda content, dialect: pd.read_parquet(content),
+}
+
+# Formats of files sent without a format hint
+EXTENSION_FORMATS = {"csv": "csv", "xls": "excel", "xlsx": "excel"}
+
+
+def read_dataframe(file_content, file_format, dialect, filename):
+    if not file_format:
+        file_format = EXTENSION_FORMATS.get(filename.split(".")[-1].lower())
+    if file_format not in READERS:
+        raise ValueError(f"Unsupported file format: {file_format}")
+    return READERS[file_format](io.BytesIO(file_content), dialect)
+
+
+@app.get("/plotly.min.js")
+def plotly_js():
+    # The plotly.js bundled with the pinned plotly package renders the figures generated here
+    return Response(content=get_plotlyjs(), media_type="application/javascript")
+
+
+@app.post("/to-csv/")
+async def to_csv(format: str = Form(...), file: UploadFile = File(...)):
+    # Convert formats the web service can't parse itself
     file_content = await file.read()
-    file_extension = file.filename.split(".")[-1]
-    if file_extension == "csv":
-        df = pd.read_csv(io.BytesIO(file_content))
-    elif file_extension in ["xls", "xlsx"]:
-        df = pd.read_excel(io.BytesIO(file_content))
-    else:
-        raise ValueError("Unsupported file format. Please upload a CSV or Excel file.")
+    try:
+        df = read_dataframe(file_content, format, Dialect(), file.filename)
+    except Exception as e:
+        raise HTTPException(status_code=400, detail=f"Failed to read {format} file: {str(e)}")
+    return Response(content=df.to_csv(index=False), media_type="text/csv")
+
+
+def clean_cell(cell):
+    # pdfplumber returns None for empty cells and keeps the line breaks of wrapped cells
+    return " ".join((cell or "").split())
+
+
+@app.post("/pdf-tables/")
+async def pdf_tables(file: UploadFile = File(...), max_images: int = Form(10), resolution: int = Form(150)):
+    # Extract the ruled tables of each page, pages without one are rendered to PNG for the vision model,
+    # e.g. scanned pages or tables aligned by whitespace
+    file_content = await file.read()
+    tables, images = [], []
+    try:
+        with pdfplumber.open(io.BytesIO(file_content)) as pdf:
+            pages = len(pdf.pages)
+            for number, page in enumerate(pdf.pages, start=1):
+                found = [[[clean_cell(cell) for cell in row] for row in table] for table in page.extract_tables()]
+                found = [table for table in found if len(table) > 1]
+                tables.extend({"page": number, "rows": table} for table in found)
+                if not found and len(images) < max_images:
+                    png = io.BytesIO()
+                    page.to_image(resolution=resolution).original.save(png, format="PNG")
+                    images.append({"page": number, "image": base64.b64encode(png.getvalue()).decode()})
+    except Exception as e:
+        raise HTTPException(status_code=400, detail=f"Failed to read pdf file: {str(e)}")
+    return {"pages": pages, "tables": tables, "images": images}
 
-    # Clean data
+
+def clean_dataframe(df):
     df.dropna(how="all", inplace=True)
     df.dropna(axis=1, how="all", inplace=True)
 
@@ -48,9 +131,30 @@ async def generate_chart(code: str = Form(...), file: UploadFile = File(...)):
 
     df.drop_duplicates(inplace=True)
     df.reset_index(drop=True, inplace=True)
+    return df
+
+
+@app.post("/generate-chart/")
+async def generate_chart(code: str = Form(...), file: UploadFile = File(...),
+                         format: str = Form(None), delimiter: str = Form(None), quote: str = Form(None),
+                         encoding: str = Form(None), bom: bool = Form(False), skip_lines: int = Form(0),
+                         sheets: List[str] = Form(None)):
+    # Read file into a Pandas DataFrame
+    file_content = await file.read()
+    dialect = Dialect(delimiter=delimiter, quote=quote, encoding=encoding, bom=bom, skip_lines=skip_lines,
+                      sheets=sheets)
+    data = read_dataframe(file_content, format, dialect, file.filename)
+
+    # Workbooks read with selected sheets are a dict of frames, df is the first selected sheet
+    if isinstance(data, dict):
+        dfs = {name: clean_dataframe(frame) for name, frame in data.items()}
+        df = dfs[sheets[0]]
+    else:
+        df = clean_dataframe(data)
+        dfs = {"data": df}
 
     # Define the execution environment
-    exec_globals = {"pd": pd, "np": np, "px": px, "go": go, "df": df, "output": None}
+    exec_globals = {"pd": pd, "np": np, "px": px, "go": go, "df": df, "dfs": dfs, "output": None}
 
     try:
         # Execute the code with the file data in a controlled namespace
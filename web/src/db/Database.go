@@ -3,6 +3,7 @@ package db
 import (
 	"fmt"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"log"
 	"os"
 	"time"
@@ -10,9 +11,17 @@ import (
 
 var dbMap = make(map[string]*sqlx.DB)
 
+// configs are the configurations of the initialized databases, listeners open their own connection
+var configs = make(map[string]DatabaseConfig)
+
 func DB() *sqlx.DB {
 	return dbMap["analyst"]
 }
+
+// SetDB makes DB return the given database instead of the initialized one, e.g. a fake database in tests
+func SetDB(database *sqlx.DB) {
+	dbMap["analyst"] = database
+}
 func InitializeDB(config DatabaseConfig) {
 	if _, exists := dbMap[config.Name]; exists == true {
 		log.Printf("database '%s' is already initialized \n", config.Name)
@@ -20,6 +29,7 @@ func InitializeDB(config DatabaseConfig) {
 	}
 
 	dbMap[config.Name] = connectDB(config, 10)
+	configs[config.Name] = config
 	log.Println("Database connection established")
 }
 
@@ -27,21 +37,7 @@ func connectDB(config DatabaseConfig, max int) *sqlx.DB {
 	if max >= 0 {
 		log.Println("Connect Database", config.Name)
 
-		ssl := "sslmode=require"
-		if config.DisableSSL {
-			ssl = "sslmode=disable"
-		}
-
-		configStr := fmt.Sprintf(
-			"host=%s port=%s user=%s password=%s dbname=%s %s",
-			config.Host,
-			config.Port,
-			config.User,
-			config.Password,
-			config.Name,
-			ssl)
-
-		db, err := sqlx.Connect("postgres", configStr)
+		db, err := sqlx.Connect("postgres", config.connectionString())
 
 		if err != nil {
 			log.Printf("Error connecting the database %s , error: %s\n", config.Name, err)
@@ -60,3 +56,23 @@ func connectDB(config DatabaseConfig, max int) *sqlx.DB {
 	log.Printf("try %d to connect to %s\n", max-1, config.Name)
 	return connectDB(config, max-1)
 }
+
+// Listen returns a listener receiving the notifications sent on the channel of the analyst database.
+// The listener reconnects by itself, notifications sent while it is disconnected are lost.
+func Listen(channel string) (*pq.Listener, error) {
+	config, exists := configs["analyst"]
+	if !exists {
+		return nil, fmt.Errorf("database analyst isn't initialized")
+	}
+
+	listener := pq.NewListener(config.connectionString(), time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Println("Database listener:", err)
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to listen on %s: %w", channel, err)
+	}
+	return listener, nil
+}
@@ -1,5 +1,7 @@
 package db
 
+import "fmt"
+
 type DatabaseConfig struct {
 	Host       string
 	Name       string
@@ -8,3 +10,20 @@ type DatabaseConfig struct {
 	Password   string
 	DisableSSL bool
 }
+
+// connectionString returns the libpq connection string of the database
+func (config DatabaseConfig) connectionString() string {
+	ssl := "sslmode=require"
+	if config.DisableSSL {
+		ssl = "sslmode=disable"
+	}
+
+	return fmt.Sprintf(
+		"host=%s port=%s user=%s password=%s dbname=%s %s",
+		config.Host,
+		config.Port,
+		config.User,
+		config.Password,
+		config.Name,
+		ssl)
+}
@@ -22,5 +22,17 @@ func Init() {
 	DB().MustExec(dbmodel.CreateAnalysisOptionTable)
 	DB().MustExec(dbmodel.CreateAnalysisTable)
 	DB().MustExec(dbmodel.CreateCodeTable)
+	DB().MustExec(dbmodel.CreateCodeAttemptTable)
 	DB().MustExec(dbmodel.CreateChartTable)
+	DB().MustExec(dbmodel.CreateJobTable)
+	// PDF documents come after the jobs extracting them and before the image extractions of their tables
+	DB().MustExec(dbmodel.CreatePDFDocumentTable)
+	DB().MustExec(dbmodel.CreateLLMCacheTable)
+	DB().MustExec(dbmodel.CreateLLMUsageTable)
+	DB().MustExec(dbmodel.CreatePromptTemplateTable)
+	DB().MustExec(dbmodel.CreateUploadSessionTable)
+	DB().MustExec(dbmodel.CreateImageExtractionTable)
+	DB().MustExec(dbmodel.CreateSessionTable)
+	DB().MustExec(dbmodel.CreateWorkspaceTable)
+	DB().MustExec(dbmodel.CreateShareLinkTable)
 }
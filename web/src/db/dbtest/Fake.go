@@ -0,0 +1,173 @@
+// Package dbtest provides a fake database for tests of code using db.DB
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"github.com/jmoiron/sqlx"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"web/src/db"
+)
+
+// Result is the rows returned for the queries containing its key in Conn.Results
+type Result struct {
+	Columns []string
+	Rows    [][]driver.Value
+}
+
+// Statement is a query or command run on the fake database
+type Statement struct {
+	Query string
+	Args  []driver.Value
+}
+
+// Conn is a fake database connection. Queries return the Result of the first key they contain,
+// or no rows. It records the statements and whether the transaction was committed or rolled back.
+type Conn struct {
+	Results map[string]Result
+
+	mutex      sync.Mutex
+	statements []Statement
+	committed  bool
+	rolledBack bool
+}
+
+// Use makes db.DB return a database on the connection for the duration of the test
+func Use(t *testing.T, conn *Conn) {
+	previous := db.DB()
+	db.SetDB(sqlx.NewDb(sql.OpenDB(connector{conn}), "postgres"))
+	t.Cleanup(func() { db.SetDB(previous) })
+}
+
+// Statements returns the statements run on the connection
+func (c *Conn) Statements() []Statement {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]Statement(nil), c.statements...)
+}
+
+// Ran reports whether a statement containing the text was run
+func (c *Conn) Ran(text string) bool {
+	for _, statement := range c.Statements() {
+		if strings.Contains(statement.Query, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// Committed reports whether a transaction was committed
+func (c *Conn) Committed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.committed
+}
+
+// RolledBack reports whether a transaction was rolled back
+func (c *Conn) RolledBack() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.rolledBack
+}
+
+func (c *Conn) record(query string, args []driver.Value) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.statements = append(c.statements, Statement{Query: query, Args: args})
+}
+
+func (c *Conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *Conn) Close() error {
+	return nil
+}
+
+func (c *Conn) Begin() (driver.Tx, error) {
+	return tx{c}, nil
+}
+
+type tx struct {
+	conn *Conn
+}
+
+func (t tx) Commit() error {
+	t.conn.mutex.Lock()
+	defer t.conn.mutex.Unlock()
+	t.conn.committed = true
+	return nil
+}
+
+func (t tx) Rollback() error {
+	t.conn.mutex.Lock()
+	defer t.conn.mutex.Unlock()
+	t.conn.rolledBack = true
+	return nil
+}
+
+type connector struct {
+	conn *Conn
+}
+
+func (c connector) Connect(context.Context) (driver.Conn, error) {
+	return c.conn, nil
+}
+
+func (c connector) Driver() driver.Driver {
+	return nil
+}
+
+type stmt struct {
+	conn  *Conn
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.conn.record(s.query, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.conn.record(s.query, args)
+	for key, result := range s.conn.Results {
+		if strings.Contains(s.query, key) {
+			return &rows{result: result}, nil
+		}
+	}
+	return &rows{}, nil
+}
+
+type rows struct {
+	result Result
+	next   int
+}
+
+func (r *rows) Columns() []string {
+	return r.result.Columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.Rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.Rows[r.next])
+	r.next++
+	return nil
+}
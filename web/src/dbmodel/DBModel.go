@@ -1,39 +1,83 @@
 package dbmodel
 
-import "time"
+import (
+	"encoding/json"
+	"github.com/lib/pq"
+	"time"
+)
 
 type AppUser struct {
+	UserID             int64      `json:"user_id" db:"user_id"`
+	Email              string     `json:"email" db:"email"`
+	Name               string     `json:"name" db:"name"`
+	PasswordHash       *string    `json:"-" db:"password_hash"`
+	EmailVerifiedAt    *time.Time `json:"email_verified_at" db:"email_verified_at"`
+	MonthlyTokenBudget *int64     `json:"monthly_token_budget" db:"monthly_token_budget"`
+	CreatedAt          time.Time  `json:"created_at" db:"created_at"`
+}
+
+// Insight is owned by the user who created it, WorkspaceID is set for insights shared with a workspace.
+// Role is the role of the requesting user on the insight.
+type Insight struct {
+	InsightID   int64     `json:"insight_id" db:"insight_id"`
+	UserID      int64     `json:"user_id" db:"user_id"`
+	WorkspaceID *int64    `json:"workspace_id,omitempty" db:"workspace_id"`
+	Role        string    `json:"role,omitempty" db:"role"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	IsDeleted   bool      `json:"is_deleted" db:"is_deleted"`
+}
+
+type Workspace struct {
+	WorkspaceID int64     `json:"workspace_id" db:"workspace_id"`
+	Name        string    `json:"name" db:"name"`
+	CreatedBy   int64     `json:"created_by" db:"created_by"`
+	Role        string    `json:"role,omitempty" db:"role"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// Member is a user with a role in a workspace or on a shared insight
+type Member struct {
 	UserID    int64     `json:"user_id" db:"user_id"`
 	Email     string    `json:"email" db:"email"`
 	Name      string    `json:"name" db:"name"`
+	Role      string    `json:"role" db:"role"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
 
-type Insight struct {
-	InsightID int64     `json:"insight_id" db:"insight_id"`
-	UserID    int64     `json:"user_id" db:"user_id"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
-	IsDeleted bool      `json:"is_deleted" db:"is_deleted"`
+// ShareLink is a public, read-only link to the chart of an insight
+type ShareLink struct {
+	LinkID       int64      `json:"link_id" db:"link_id"`
+	InsightID    int64      `json:"insight_id" db:"insight_id"`
+	CreatedBy    int64      `json:"created_by" db:"created_by"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt    *time.Time `json:"expires_at" db:"expires_at"`
+	RevokedAt    *time.Time `json:"revoked_at" db:"revoked_at"`
+	ViewCount    int64      `json:"view_count" db:"view_count"`
+	LastViewedAt *time.Time `json:"last_viewed_at" db:"last_viewed_at"`
 }
 
 type InsightData struct {
-	InsightID     int64     `json:"insight_id" db:"insight_id"`
-	S3key         string    `json:"s3key" db:"s3key"`
-	FileSize      int       `json:"file_size" db:"file_size"`
-	FileExtension string    `json:"file_extension" db:"file_extension"`
-	UploadedAt    time.Time `json:"uploaded_at" db:"uploaded_at"`
-	Headers       string    `json:"headers" db:"headers"`
-	FirstRows     []string  `json:"first_rows" db:"first_rows"`
+	InsightID     int64           `json:"insight_id" db:"insight_id"`
+	S3key         string          `json:"s3key" db:"s3key"`
+	FileSize      int64           `json:"file_size" db:"file_size"`
+	FileExtension string          `json:"file_extension" db:"file_extension"`
+	UploadedAt    time.Time       `json:"uploaded_at" db:"uploaded_at"`
+	Headers       []string        `json:"headers" db:"headers"`
+	FirstRows     [][]string      `json:"first_rows" db:"first_rows"`
+	Profile       json.RawMessage `json:"profile,omitempty" db:"profile"`
+	Dialect       json.RawMessage `json:"dialect,omitempty" db:"dialect"`
+	Sheets        json.RawMessage `json:"sheets,omitempty" db:"sheets"`
 }
 
 type AnalysisOption struct {
-	OptionID    int64     `json:"option_id" db:"option_id"`
-	InsightID   int64     `json:"insight_id" db:"insight_id"`
-	Name        string    `json:"name" db:"name"`
-	ChartType   string    `json:"chart_type" db:"chart_type"`
-	Description string    `json:"description" db:"description"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	OptionID    int64          `json:"option_id" db:"option_id"`
+	InsightID   int64          `json:"insight_id" db:"insight_id"`
+	Name        string         `json:"name" db:"name"`
+	ChartType   string         `json:"chart_type" db:"chart_type"`
+	Description string         `json:"description" db:"description"`
+	Columns     pq.StringArray `json:"columns" db:"columns"`
+	CreatedAt   time.Time      `json:"created_at" db:"created_at"`
 }
 
 type InsightAnalysis struct {
@@ -43,9 +87,20 @@ type InsightAnalysis struct {
 }
 
 type InsightCode struct {
+	InsightID     int64     `json:"insight_id" db:"insight_id"`
+	Code          string    `json:"code" db:"code"`
+	PromptVersion string    `json:"prompt_version" db:"prompt_version"`
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type InsightCodeAttempt struct {
+	AttemptID int64     `json:"attempt_id" db:"attempt_id"`
 	InsightID int64     `json:"insight_id" db:"insight_id"`
+	Round     int       `json:"round" db:"round"`
 	Code      string    `json:"code" db:"code"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	Error     string    `json:"error" db:"error"`
+	Succeeded bool      `json:"succeeded" db:"succeeded"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
 
 type InsightChart struct {
@@ -54,13 +109,108 @@ type InsightChart struct {
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
 }
 
+type Job struct {
+	JobID      int64      `json:"job_id" db:"job_id"`
+	InsightID  *int64     `json:"insight_id,omitempty" db:"insight_id"`
+	DocumentID *int64     `json:"document_id,omitempty" db:"document_id"`
+	UserID     *int64     `json:"user_id,omitempty" db:"user_id"`
+	Kind       string     `json:"kind" db:"kind"`
+	State      string     `json:"state" db:"state"`
+	Error      string     `json:"error,omitempty" db:"error"`
+	Attempts   int        `json:"attempts" db:"attempts"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	StartedAt  *time.Time `json:"started_at" db:"started_at"`
+	FinishedAt *time.Time `json:"finished_at" db:"finished_at"`
+}
+
+type LLMUsage struct {
+	UsageID          int64     `json:"usage_id" db:"usage_id"`
+	UserID           *int64    `json:"user_id" db:"user_id"`
+	InsightID        *int64    `json:"insight_id" db:"insight_id"`
+	Operation        string    `json:"operation" db:"operation"`
+	Provider         string    `json:"provider" db:"provider"`
+	Model            string    `json:"model" db:"model"`
+	PromptTokens     int       `json:"prompt_tokens" db:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens" db:"completion_tokens"`
+	CostUSD          float64   `json:"cost_usd" db:"cost_usd"`
+	LatencyMs        int64     `json:"latency_ms" db:"latency_ms"`
+	Retries          int       `json:"retries" db:"retries"`
+	Cached           bool      `json:"cached" db:"cached"`
+	Succeeded        bool      `json:"succeeded" db:"succeeded"`
+	CreatedAt        time.Time `json:"created_at" db:"created_at"`
+}
+
+type UploadSession struct {
+	UploadID   int64     `json:"upload_id" db:"upload_id"`
+	UserID     int64     `json:"user_id" db:"user_id"`
+	FileName   string    `json:"file_name" db:"file_name"`
+	S3key      string    `json:"-" db:"s3key"`
+	S3UploadID string    `json:"-" db:"s3_upload_id"`
+	State      string    `json:"state" db:"state"`
+	InsightID  *int64    `json:"insight_id,omitempty" db:"insight_id"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type UploadPart struct {
+	UploadID   int64     `json:"-" db:"upload_id"`
+	PartNumber int64     `json:"part_number" db:"part_number"`
+	ETag       string    `json:"etag" db:"etag"`
+	Size       int64     `json:"size" db:"size"`
+	UploadedAt time.Time `json:"uploaded_at" db:"uploaded_at"`
+}
+
+// ImageExtraction is a table extracted from an image or a PDF file, reviewed by the user before it's stored
+// as the data of an insight. Tables of PDF files belong to their document and page.
+type ImageExtraction struct {
+	ExtractionID int64           `json:"extraction_id" db:"extraction_id"`
+	UserID       int64           `json:"user_id" db:"user_id"`
+	S3key        string          `json:"-" db:"s3key"`
+	State        string          `json:"state" db:"state"`
+	Table        json.RawMessage `json:"table" db:"extracted_table"`
+	DocumentID   *int64          `json:"document_id,omitempty" db:"document_id"`
+	Page         *int            `json:"page,omitempty" db:"page"`
+	InsightID    *int64          `json:"insight_id,omitempty" db:"insight_id"`
+	CreatedAt    time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+type PDFDocument struct {
+	DocumentID     int64     `json:"document_id" db:"document_id"`
+	UserID         int64     `json:"user_id" db:"user_id"`
+	FileName       string    `json:"file_name" db:"file_name"`
+	S3key          string    `json:"-" db:"s3key"`
+	Pages          int       `json:"pages" db:"pages"`
+	State          string    `json:"state" db:"state"`
+	Error          string    `json:"error,omitempty" db:"error"`
+	ProcessedPages int       `json:"processed_pages" db:"processed_pages"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+type PromptTemplate struct {
+	Name      string    `json:"name" db:"name"`
+	Version   string    `json:"version" db:"version"`
+	Body      *string   `json:"body,omitempty" db:"body"`
+	Active    bool      `json:"active" db:"active"`
+	Weight    int       `json:"weight" db:"weight"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
 var CreateAppUserTable = `
 CREATE TABLE IF NOT EXISTS app_user (
     user_id BIGSERIAL PRIMARY KEY,
     email TEXT NOT NULL UNIQUE,
     name TEXT,
+    password_hash TEXT,
+    email_verified_at TIMESTAMP,
+    monthly_token_budget BIGINT,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
+ALTER TABLE app_user ADD COLUMN IF NOT EXISTS monthly_token_budget BIGINT;
+ALTER TABLE app_user ADD COLUMN IF NOT EXISTS password_hash TEXT;
+ALTER TABLE app_user ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP;
+-- Users without a password logged in with a magic link, which verified their email
+UPDATE app_user SET email_verified_at = created_at WHERE email_verified_at IS NULL AND password_hash IS NULL;
 CREATE INDEX IF NOT EXISTS idx_app_user_email ON app_user (email);`
 
 var CreateInsightsTable = `
@@ -77,21 +227,47 @@ var CreateDataTable = `
 CREATE TABLE IF NOT EXISTS insight_data (
     insight_id BIGINT PRIMARY KEY REFERENCES insights(insight_id),
     s3key TEXT NOT NULL,
-    file_size INT,
+    file_size BIGINT,
     file_extension TEXT,
     uploaded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    headers TEXT,
-    first_rows TEXT[]
+    headers JSONB,
+    first_rows JSONB,
+    profile JSONB,
+    dialect JSONB,
+    sheets JSONB
 );
+ALTER TABLE insight_data ADD COLUMN IF NOT EXISTS profile JSONB;
+ALTER TABLE insight_data ADD COLUMN IF NOT EXISTS dialect JSONB;
+ALTER TABLE insight_data ADD COLUMN IF NOT EXISTS sheets JSONB;
+ALTER TABLE insight_data ALTER COLUMN file_size TYPE BIGINT;
+
+-- Headers and first rows were stored comma-joined, convert them to JSON arrays
+CREATE OR REPLACE FUNCTION split_rows(joined TEXT[]) RETURNS JSONB AS $$
+    SELECT COALESCE(jsonb_agg(string_to_array(row_text, ',') ORDER BY position), '[]'::jsonb)
+    FROM unnest(joined) WITH ORDINALITY AS r(row_text, position);
+$$ LANGUAGE SQL IMMUTABLE;
+
+DO $$
+BEGIN
+    IF (SELECT data_type FROM information_schema.columns
+        WHERE table_name = 'insight_data' AND column_name = 'headers') = 'text' THEN
+        ALTER TABLE insight_data ALTER COLUMN headers TYPE JSONB USING to_jsonb(string_to_array(headers, ','));
+        ALTER TABLE insight_data ALTER COLUMN first_rows TYPE JSONB USING split_rows(first_rows);
+    END IF;
+END;
+$$;
+DROP FUNCTION IF EXISTS split_rows;
 
 DROP TRIGGER IF EXISTS trg_data_update ON insight_data;
 DROP FUNCTION IF EXISTS on_data_update;
 
 CREATE OR REPLACE FUNCTION on_data_update() RETURNS TRIGGER AS $$
 BEGIN
-    -- Only delete dependent records if headers or first_rows are updated
-    IF NEW.headers IS DISTINCT FROM OLD.headers OR NEW.first_rows IS DISTINCT FROM OLD.first_rows THEN
+    -- Only delete dependent records if headers, first_rows or the dialect with the selected sheets are updated
+    IF NEW.headers IS DISTINCT FROM OLD.headers OR NEW.first_rows IS DISTINCT FROM OLD.first_rows
+        OR NEW.dialect IS DISTINCT FROM OLD.dialect THEN
         DELETE FROM insight_analysis WHERE insight_id = NEW.insight_id;
+        DELETE FROM analysis_options WHERE insight_id = NEW.insight_id;
         DELETE FROM insight_code WHERE insight_id = NEW.insight_id;
         DELETE FROM insight_chart WHERE insight_id = NEW.insight_id;
     END IF;
@@ -110,8 +286,10 @@ CREATE TABLE IF NOT EXISTS analysis_options (
     name TEXT NOT NULL,
     chart_type TEXT,
     description TEXT,
+    columns TEXT[],
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
+ALTER TABLE analysis_options ADD COLUMN IF NOT EXISTS columns TEXT[];
 CREATE INDEX IF NOT EXISTS idx_analysis_options_insight_id ON analysis_options (insight_id);`
 
 var CreateAnalysisTable = `
@@ -143,8 +321,10 @@ var CreateCodeTable = `
 CREATE TABLE IF NOT EXISTS insight_code (
     insight_id BIGINT PRIMARY KEY REFERENCES insights(insight_id),
     code TEXT NOT NULL,
+    prompt_version TEXT NOT NULL DEFAULT '',
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
+ALTER TABLE insight_code ADD COLUMN IF NOT EXISTS prompt_version TEXT NOT NULL DEFAULT '';
 
 DROP TRIGGER IF EXISTS trg_code_update ON insight_code;
 DROP FUNCTION IF EXISTS on_code_update;
@@ -163,9 +343,205 @@ CREATE TRIGGER trg_code_update
 BEFORE UPDATE ON insight_code
 FOR EACH ROW EXECUTE FUNCTION on_code_update();`
 
+var CreateCodeAttemptTable = `
+CREATE TABLE IF NOT EXISTS insight_code_attempt (
+    attempt_id BIGSERIAL PRIMARY KEY,
+    insight_id BIGINT REFERENCES insights(insight_id),
+    round INT NOT NULL,
+    code TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    succeeded BOOLEAN NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_insight_code_attempt_insight_id ON insight_code_attempt (insight_id);`
+
 var CreateChartTable = `
 CREATE TABLE IF NOT EXISTS insight_chart (
     insight_id BIGINT PRIMARY KEY REFERENCES insights(insight_id),
     chart_data TEXT NOT NULL,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );`
+
+var CreateJobTable = `
+CREATE TABLE IF NOT EXISTS job (
+    job_id BIGSERIAL PRIMARY KEY,
+    insight_id BIGINT REFERENCES insights(insight_id),
+    user_id BIGINT REFERENCES app_user(user_id),
+    kind TEXT NOT NULL,
+    state TEXT NOT NULL DEFAULT 'queued',
+    error TEXT NOT NULL DEFAULT '',
+    attempts INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_job_insight_id ON job (insight_id);
+
+-- Jobs bill the LLM calls to the user who queued them, older jobs to the owner of the insight
+ALTER TABLE job ADD COLUMN IF NOT EXISTS user_id BIGINT REFERENCES app_user(user_id);
+UPDATE job SET user_id = i.user_id FROM insights i WHERE job.user_id IS NULL AND i.insight_id = job.insight_id;
+CREATE INDEX IF NOT EXISTS idx_job_queued ON job (job_id) WHERE state = 'queued';`
+
+var CreateLLMCacheTable = `
+CREATE TABLE IF NOT EXISTS llm_cache (
+    cache_key TEXT PRIMARY KEY,
+    operation TEXT,
+    content TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_llm_cache_expires_at ON llm_cache (expires_at);`
+
+var CreateLLMUsageTable = `
+CREATE TABLE IF NOT EXISTS llm_usage (
+    usage_id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT REFERENCES app_user(user_id),
+    insight_id BIGINT REFERENCES insights(insight_id),
+    operation TEXT NOT NULL,
+    provider TEXT NOT NULL,
+    model TEXT NOT NULL,
+    prompt_tokens INT NOT NULL DEFAULT 0,
+    completion_tokens INT NOT NULL DEFAULT 0,
+    cost_usd DOUBLE PRECISION NOT NULL DEFAULT 0,
+    latency_ms BIGINT NOT NULL DEFAULT 0,
+    retries INT NOT NULL DEFAULT 0,
+    cached BOOLEAN NOT NULL DEFAULT FALSE,
+    succeeded BOOLEAN NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_llm_usage_user_id_created_at ON llm_usage (user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_llm_usage_insight_id ON llm_usage (insight_id);`
+
+var CreateUploadSessionTable = `
+CREATE TABLE IF NOT EXISTS upload_session (
+    upload_id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT REFERENCES app_user(user_id),
+    file_name TEXT NOT NULL,
+    s3key TEXT NOT NULL,
+    s3_upload_id TEXT NOT NULL,
+    state TEXT NOT NULL,
+    insight_id BIGINT REFERENCES insights(insight_id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS upload_part (
+    upload_id BIGINT REFERENCES upload_session(upload_id),
+    part_number INT NOT NULL,
+    etag TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    uploaded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (upload_id, part_number)
+);`
+
+var CreatePromptTemplateTable = `
+CREATE TABLE IF NOT EXISTS prompt_template (
+    name TEXT NOT NULL,
+    version TEXT NOT NULL,
+    body TEXT,
+    active BOOLEAN NOT NULL DEFAULT FALSE,
+    weight INT NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (name, version)
+);`
+
+var CreatePDFDocumentTable = `
+CREATE TABLE IF NOT EXISTS pdf_document (
+    document_id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT REFERENCES app_user(user_id),
+    file_name TEXT NOT NULL,
+    s3key TEXT NOT NULL,
+    pages INT NOT NULL,
+    state TEXT NOT NULL DEFAULT 'review',
+    error TEXT NOT NULL DEFAULT '',
+    processed_pages INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+ALTER TABLE pdf_document ADD COLUMN IF NOT EXISTS state TEXT NOT NULL DEFAULT 'review';
+ALTER TABLE pdf_document ADD COLUMN IF NOT EXISTS error TEXT NOT NULL DEFAULT '';
+ALTER TABLE pdf_document ADD COLUMN IF NOT EXISTS processed_pages INT NOT NULL DEFAULT 0;
+
+-- The tables of PDF documents are extracted by jobs, which belong to a document instead of an insight
+ALTER TABLE job ADD COLUMN IF NOT EXISTS document_id BIGINT REFERENCES pdf_document(document_id);`
+
+var CreateImageExtractionTable = `
+CREATE TABLE IF NOT EXISTS image_extraction (
+    extraction_id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT REFERENCES app_user(user_id),
+    s3key TEXT NOT NULL,
+    state TEXT NOT NULL,
+    extracted_table JSONB NOT NULL,
+    document_id BIGINT REFERENCES pdf_document(document_id),
+    page INT,
+    insight_id BIGINT REFERENCES insights(insight_id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+ALTER TABLE image_extraction ADD COLUMN IF NOT EXISTS document_id BIGINT REFERENCES pdf_document(document_id);
+ALTER TABLE image_extraction ADD COLUMN IF NOT EXISTS page INT;
+CREATE INDEX IF NOT EXISTS idx_image_extraction_document_id ON image_extraction (document_id);`
+
+var CreateSessionTable = `
+CREATE TABLE IF NOT EXISTS user_session (
+    token_hash TEXT PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES app_user(user_id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_user_session_user_id ON user_session (user_id);
+
+CREATE TABLE IF NOT EXISTS login_token (
+    token_hash TEXT PRIMARY KEY,
+    email TEXT NOT NULL,
+    purpose TEXT NOT NULL DEFAULT 'login',
+    requested_ip TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+ALTER TABLE login_token ADD COLUMN IF NOT EXISTS purpose TEXT NOT NULL DEFAULT 'login';
+ALTER TABLE login_token ADD COLUMN IF NOT EXISTS requested_ip TEXT;
+CREATE INDEX IF NOT EXISTS idx_login_token_email_created_at ON login_token (email, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_token_requested_ip_created_at ON login_token (requested_ip, created_at);`
+
+var CreateWorkspaceTable = `
+CREATE TABLE IF NOT EXISTS workspace (
+    workspace_id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_by BIGINT REFERENCES app_user(user_id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS workspace_member (
+    workspace_id BIGINT REFERENCES workspace(workspace_id),
+    user_id BIGINT REFERENCES app_user(user_id),
+    role TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (workspace_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_workspace_member_user_id ON workspace_member (user_id);
+
+ALTER TABLE insights ADD COLUMN IF NOT EXISTS workspace_id BIGINT REFERENCES workspace(workspace_id);
+CREATE INDEX IF NOT EXISTS idx_insights_workspace_id ON insights (workspace_id);
+
+CREATE TABLE IF NOT EXISTS insight_share (
+    insight_id BIGINT REFERENCES insights(insight_id),
+    user_id BIGINT REFERENCES app_user(user_id),
+    role TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (insight_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_insight_share_user_id ON insight_share (user_id);`
+
+var CreateShareLinkTable = `
+CREATE TABLE IF NOT EXISTS chart_share_link (
+    link_id BIGSERIAL PRIMARY KEY,
+    insight_id BIGINT NOT NULL REFERENCES insights(insight_id),
+    created_by BIGINT REFERENCES app_user(user_id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    view_count BIGINT NOT NULL DEFAULT 0,
+    last_viewed_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_chart_share_link_insight_id ON chart_share_link (insight_id);`
@@ -0,0 +1,22 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"web/src/ops"
+)
+
+// PlotlyJS handles GET /static/plotly.min.js with the plotly.js the charts are rendered with.
+// The pages load it from the app instead of a CDN, so the version is pinned with the python environment.
+func PlotlyJS(c *gin.Context) {
+	script, err := ops.PlotlyJS(c.Request.Context())
+	if err != nil {
+		log.Println("Failed to load plotly.js:", err)
+		respondError(c, http.StatusBadGateway, "plotly.js is unavailable")
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.Data(http.StatusOK, "application/javascript", script)
+}
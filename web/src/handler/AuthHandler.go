@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"web/src/dbmodel"
+	"web/src/service"
+	"web/src/util"
+)
+
+// sessionCookie holds the session token. It's HttpOnly and SameSite=Lax, so scripts can't read it
+// and cross-site form posts don't carry it.
+const sessionCookie = "session"
+
+// userKey stores the user resolved by RequireUser in the gin context
+const userKey = "user"
+
+type signUpRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	Name     string `json:"name"`
+}
+
+type loginRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type magicLinkRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+// SignUp handles POST /api/auth/signup and creates a user with email and password.
+// The user is logged in by the link in the verification email.
+func SignUp(c *gin.Context) {
+	var request signUpRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "email and password are required")
+		return
+	}
+
+	user, err := service.SignUp(c.Request.Context(), request.Email, request.Password, request.Name, c.ClientIP())
+	if errors.Is(err, service.ErrInvalidSignup) {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, service.ErrTooManyRequests) {
+		respondError(c, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if err != nil {
+		respondServiceError(c, "", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, user)
+}
+
+// Login handles POST /api/auth/login with email and password
+func Login(c *gin.Context) {
+	var request loginRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "email and password are required")
+		return
+	}
+
+	user, err := service.Login(c.Request.Context(), request.Email, request.Password, c.ClientIP())
+	if errors.Is(err, service.ErrInvalidCredentials) {
+		respondError(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if errors.Is(err, service.ErrEmailNotVerified) {
+		respondError(c, http.StatusForbidden, err.Error())
+		return
+	}
+	if errors.Is(err, service.ErrTooManyRequests) {
+		respondError(c, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if err != nil {
+		respondServiceError(c, "", err)
+		return
+	}
+
+	if !startSession(c, user) {
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// SendMagicLink handles POST /api/auth/magic-link by emailing a login link. It responds the same
+// whether a user with the email exists or not. Links are rate limited per email and per client IP.
+func SendMagicLink(c *gin.Context) {
+	var request magicLinkRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "email is required")
+		return
+	}
+
+	err := service.SendMagicLink(c.Request.Context(), request.Email, c.ClientIP())
+	if errors.Is(err, service.ErrInvalidSignup) {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, service.ErrTooManyRequests) {
+		respondError(c, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if err != nil {
+		respondServiceError(c, "", err)
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// ShowMagicLink handles GET /api/auth/magic-link/verify?token=... from a login link with a page confirming the login.
+// Opening the link doesn't use it up, mail scanners that open links would log in or use up the token otherwise.
+func ShowMagicLink(c *gin.Context) {
+	// The token is in the URL and isn't passed on to other sites
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("X-Robots-Tag", "noindex")
+	c.HTML(http.StatusOK, "magic_link.html", gin.H{"Token": c.Query("token")})
+}
+
+// VerifyMagicLink handles POST /api/auth/magic-link/verify from the confirmation page,
+// uses up the token, starts a session and redirects to the app
+func VerifyMagicLink(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	user, err := service.VerifyMagicLink(c.PostForm("token"))
+	if errors.Is(err, service.ErrInvalidToken) {
+		c.HTML(http.StatusUnauthorized, "magic_link.html", gin.H{"Error": "The login link is invalid or expired."})
+		return
+	}
+	if err != nil {
+		respondServiceError(c, "", err)
+		return
+	}
+
+	if !startSession(c, user) {
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/")
+}
+
+// Logout handles POST /api/auth/logout and ends the current session
+func Logout(c *gin.Context) {
+	if token := sessionToken(c); token != "" {
+		if err := service.DeleteSession(token); err != nil {
+			respondServiceError(c, "", err)
+			return
+		}
+	}
+
+	setSessionCookie(c, "", -1)
+	c.Status(http.StatusNoContent)
+}
+
+// GetCurrentUser handles GET /api/auth/me
+func GetCurrentUser(c *gin.Context) {
+	c.JSON(http.StatusOK, CurrentUser(c))
+}
+
+// RequireUser resolves the session of the request to its user and rejects requests without a valid session.
+// Browsers send the session cookie, API clients the session token as bearer token.
+func RequireUser(c *gin.Context) {
+	token := sessionToken(c)
+	if token == "" {
+		respondError(c, http.StatusUnauthorized, "login required")
+		c.Abort()
+		return
+	}
+
+	user, err := service.GetSessionUser(token)
+	if errors.Is(err, service.ErrInvalidToken) {
+		respondError(c, http.StatusUnauthorized, "login required")
+		c.Abort()
+		return
+	}
+	if err != nil {
+		respondServiceError(c, "", err)
+		c.Abort()
+		return
+	}
+
+	c.Set(userKey, user)
+	c.Next()
+}
+
+// CurrentUser returns the user resolved by RequireUser
+func CurrentUser(c *gin.Context) dbmodel.AppUser {
+	return c.MustGet(userKey).(dbmodel.AppUser)
+}
+
+// CurrentUserID returns the id of the user issuing the request
+func CurrentUserID(c *gin.Context) int64 {
+	return CurrentUser(c).UserID
+}
+
+func startSession(c *gin.Context, user dbmodel.AppUser) bool {
+	token, err := service.CreateSession(user.UserID)
+	if err != nil {
+		respondServiceError(c, "", err)
+		return false
+	}
+	setSessionCookie(c, token, int(service.SessionDuration().Seconds()))
+	return true
+}
+
+// setSessionCookie sets the session cookie, it's only sent over HTTPS if the app is served over HTTPS
+func setSessionCookie(c *gin.Context, token string, maxAge int) {
+	secure := strings.HasPrefix(util.Env("BASE_URL"), "https://")
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookie, token, maxAge, "/", "", secure, true)
+}
+
+func sessionToken(c *gin.Context) string {
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	token, _ := c.Cookie(sessionCookie)
+	return token
+}
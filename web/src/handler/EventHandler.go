@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"io"
+	"time"
+	"web/src/progress"
+	"web/src/service"
+)
+
+const heartbeatInterval = 15 * time.Second
+
+// StreamEvents handles GET /api/insights/:id/events by streaming the progress of the insight as Server-Sent Events.
+// Recent events are replayed first so clients connecting late still see the current step.
+func StreamEvents(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleViewer)
+	if !ok {
+		return
+	}
+
+	recent, events, unsubscribe := progress.Subscribe(insight.InsightID)
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	for _, event := range recent {
+		c.SSEvent(event.Type, event)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event := <-events:
+			c.SSEvent(event.Type, event)
+			return true
+		case <-heartbeat.C:
+			c.SSEvent("ping", gin.H{"time": time.Now()})
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"strconv"
+	"web/src/dbmodel"
+	"web/src/ingest"
+	"web/src/llm"
+	"web/src/service"
+)
+
+// requestContext returns the context for the services handling the request.
+// The query parameter refresh=true bypasses cached LLM responses.
+func requestContext(c *gin.Context) context.Context {
+	ctx := c.Request.Context()
+	if c.Query("refresh") == "true" {
+		ctx = llm.WithoutCache(ctx)
+	}
+	return ctx
+}
+
+// loadInsight resolves the :id path parameter to an insight the current user has at least the required role on.
+// It writes the error response and returns false if the insight can't be used.
+func loadInsight(c *gin.Context, role string) (dbmodel.Insight, bool) {
+	insightID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid insight id")
+		return dbmodel.Insight{}, false
+	}
+
+	insight, err := service.GetInsight(CurrentUserID(c), insightID)
+	if err != nil {
+		respondServiceError(c, "insight not found", err)
+		return dbmodel.Insight{}, false
+	}
+	if !service.HasRole(insight.Role, role) {
+		respondError(c, http.StatusForbidden, role+" role required")
+		return dbmodel.Insight{}, false
+	}
+
+	return insight, true
+}
+
+func respondError(c *gin.Context, status int, message string) {
+	c.JSON(status, gin.H{"error": message})
+}
+
+// respondServiceError maps missing rows to 404, exceeded budgets to 402, missing roles to 403,
+// failed operations to 502 and everything else to 500
+func respondServiceError(c *gin.Context, notFoundMessage string, err error) {
+	if errors.Is(err, service.ErrForbidden) {
+		respondError(c, http.StatusForbidden, err.Error())
+		return
+	}
+	if errors.Is(err, service.ErrBudgetExceeded) {
+		respondError(c, http.StatusPaymentRequired, err.Error())
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, http.StatusNotFound, notFoundMessage)
+		return
+	}
+	if errors.Is(err, service.ErrOperationFailed) {
+		log.Println("Operation failed:", err)
+		respondError(c, http.StatusBadGateway, "the analysis failed, please try again")
+		return
+	}
+	log.Println("Request failed:", err)
+	respondError(c, http.StatusInternalServerError, "Oops! Something went wrong.")
+}
+
+// respondUploadError maps files exceeding the upload limit to 413 and invalid files to 422
+func respondUploadError(c *gin.Context, err error) {
+	if errors.Is(err, ingest.ErrFileTooLarge) {
+		respondError(c, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if errors.Is(err, ingest.ErrInvalidFile) {
+		respondError(c, http.StatusUnprocessableEntity, "there is a problem with the file data: "+err.Error())
+		return
+	}
+	respondServiceError(c, "", err)
+}
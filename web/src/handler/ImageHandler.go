@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"web/src/ingest"
+	"web/src/model"
+	"web/src/service"
+)
+
+// UploadImage handles POST /api/images by extracting the table in a PNG or JPEG image through the vision model.
+// The extracted table is stored for review, it becomes the data of an insight once it's confirmed.
+func UploadImage(c *gin.Context) {
+	image, ext, err := ingest.ReceiveImage(c.Request, "image")
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	extraction, err := service.ExtractImage(requestContext(c), CurrentUserID(c), image, ext)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, extraction)
+}
+
+// GetImageExtraction handles GET /api/images/:extraction_id
+func GetImageExtraction(c *gin.Context) {
+	extractionID, ok := extractionIDParam(c)
+	if !ok {
+		return
+	}
+
+	extraction, err := service.GetImageExtraction(CurrentUserID(c), extractionID)
+	if err != nil {
+		respondServiceError(c, "image extraction not found", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, extraction)
+}
+
+// UpdateImageExtraction handles PUT /api/images/:extraction_id with the table corrected by the user
+func UpdateImageExtraction(c *gin.Context) {
+	extractionID, ok := extractionIDParam(c)
+	if !ok {
+		return
+	}
+
+	var table model.Table
+	if err := c.ShouldBindJSON(&table); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid table")
+		return
+	}
+
+	extraction, err := service.UpdateImageExtraction(CurrentUserID(c), extractionID, table)
+	if err != nil {
+		respondExtractionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, extraction)
+}
+
+// ConfirmImageExtraction handles POST /api/images/:extraction_id/confirm and stores the reviewed table as a new insight
+func ConfirmImageExtraction(c *gin.Context) {
+	extractionID, ok := extractionIDParam(c)
+	if !ok {
+		return
+	}
+
+	insightID, err := service.ConfirmImageExtraction(CurrentUserID(c), extractionID)
+	if err != nil {
+		respondExtractionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"insight_id": insightID})
+}
+
+func extractionIDParam(c *gin.Context) (int64, bool) {
+	extractionID, err := strconv.ParseInt(c.Param("extraction_id"), 10, 64)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid extraction id")
+		return 0, false
+	}
+	return extractionID, true
+}
+
+// respondExtractionError maps changes to confirmed extractions to 409 and invalid tables to 422
+func respondExtractionError(c *gin.Context, err error) {
+	if errors.Is(err, service.ErrInvalidExtraction) {
+		respondError(c, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, ingest.ErrInvalidFile) {
+		respondError(c, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	respondServiceError(c, "image extraction not found", err)
+}
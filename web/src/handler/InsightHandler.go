@@ -0,0 +1,301 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"web/src/dbmodel"
+	"web/src/ingest"
+	"web/src/service"
+)
+
+// CreateInsight handles POST /api/insights by streaming the uploaded data file to storage and storing it as a new insight.
+// With the query parameter workspace_id the insight is created in a workspace the user is an editor of.
+func CreateInsight(c *gin.Context) {
+	workspaceID, ok := workspaceIDQuery(c)
+	if !ok {
+		return
+	}
+	if workspaceID != nil {
+		if _, err := service.RequireWorkspaceRole(CurrentUserID(c), *workspaceID, service.RoleEditor); err != nil {
+			respondServiceError(c, "workspace not found", err)
+			return
+		}
+	}
+
+	upload, err := ingest.ReceiveMultipart(c.Request, "file")
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	insightID, err := service.CreateInsight(CurrentUserID(c), workspaceID)
+	if err != nil {
+		respondServiceError(c, "workspace not found", err)
+		return
+	}
+	err = service.SaveUploadedData(insightID, upload)
+	if err != nil {
+		respondServiceError(c, "", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"insight_id": insightID})
+}
+
+// ListInsights handles GET /api/insights and lists the insights the user owns or has a role on.
+// The query parameter workspace_id lists the insights of a workspace only.
+func ListInsights(c *gin.Context) {
+	workspaceID, ok := workspaceIDQuery(c)
+	if !ok {
+		return
+	}
+
+	var insights []dbmodel.Insight
+	var err error
+	if workspaceID != nil {
+		insights, err = service.ListWorkspaceInsights(CurrentUserID(c), *workspaceID)
+	} else {
+		insights, err = service.ListInsights(CurrentUserID(c))
+	}
+	if err != nil {
+		respondServiceError(c, "", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, insights)
+}
+
+type moveInsightRequest struct {
+	WorkspaceID *int64 `json:"workspace_id"`
+}
+
+// MoveInsight handles PUT /api/insights/:id/workspace. The user owning the insight moves it into a workspace
+// they are an editor of, a null workspace_id makes the insight private again. Workspace owners don't move the
+// insights of other members.
+func MoveInsight(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleOwner)
+	if !ok {
+		return
+	}
+
+	var request moveInsightRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid workspace id")
+		return
+	}
+
+	err := service.MoveInsight(CurrentUserID(c), insight.InsightID, request.WorkspaceID)
+	if err != nil {
+		respondServiceError(c, "workspace not found", err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetInsight handles GET /api/insights/:id and returns the insight with its data file metadata
+func GetInsight(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleViewer)
+	if !ok {
+		return
+	}
+
+	data, err := service.GetInsightData(insight.InsightID)
+	if err != nil {
+		respondServiceError(c, "insight data not found", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"insight": insight, "data": data})
+}
+
+// GenerateOptions handles POST /api/insights/:id/options by asking the LLM for analysis options
+func GenerateOptions(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleEditor)
+	if !ok {
+		return
+	}
+
+	options, err := service.GenerateOptions(requestContext(c), CurrentUserID(c), insight.InsightID)
+	if err != nil {
+		respondServiceError(c, "insight data not found", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, options)
+}
+
+// ListOptions handles GET /api/insights/:id/options
+func ListOptions(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleViewer)
+	if !ok {
+		return
+	}
+
+	options, err := service.GetAnalysisOptions(insight.InsightID)
+	if err != nil {
+		respondServiceError(c, "", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, options)
+}
+
+type selectOptionRequest struct {
+	OptionID int64 `json:"option_id" binding:"required"`
+}
+
+// SelectOption handles PUT /api/insights/:id/analysis by selecting one of the analysis options
+func SelectOption(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var request selectOptionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "option_id is required")
+		return
+	}
+
+	err := service.SelectAnalysisOption(insight.InsightID, request.OptionID)
+	if err != nil {
+		respondServiceError(c, "analysis option not found", err)
+		return
+	}
+
+	respondAnalysis(c, insight.InsightID)
+}
+
+// GetAnalysis handles GET /api/insights/:id/analysis
+func GetAnalysis(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleViewer)
+	if !ok {
+		return
+	}
+
+	respondAnalysis(c, insight.InsightID)
+}
+
+// respondAnalysis writes the selected analysis of an insight together with its option
+func respondAnalysis(c *gin.Context, insightID int64) {
+	analysis, err := service.GetInsightAnalysis(insightID)
+	if err != nil {
+		respondServiceError(c, "no analysis selected", err)
+		return
+	}
+
+	option, err := service.GetSelectedOption(insightID)
+	if err != nil {
+		respondServiceError(c, "no analysis selected", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"insight_id":         analysis.InsightID,
+		"selected_option_id": analysis.SelectedOptionID,
+		"option":             option,
+		"updated_at":         analysis.UpdatedAt,
+	})
+}
+
+// GenerateCode handles POST /api/insights/:id/code by generating the code for the selected analysis option
+func GenerateCode(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleEditor)
+	if !ok {
+		return
+	}
+
+	code, err := service.GenerateCode(requestContext(c), CurrentUserID(c), insight.InsightID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(c, http.StatusConflict, "select an analysis option first")
+			return
+		}
+		respondServiceError(c, "", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, code)
+}
+
+// GetCode handles GET /api/insights/:id/code
+func GetCode(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleViewer)
+	if !ok {
+		return
+	}
+
+	code, err := service.GetInsightCode(insight.InsightID)
+	if err != nil {
+		respondServiceError(c, "no code generated", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, code)
+}
+
+// ListCodeAttempts handles GET /api/insights/:id/code/attempts
+func ListCodeAttempts(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleViewer)
+	if !ok {
+		return
+	}
+
+	attempts, err := service.GetCodeAttempts(insight.InsightID)
+	if err != nil {
+		respondServiceError(c, "", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, attempts)
+}
+
+// GenerateChart handles POST /api/insights/:id/chart by running the stored code against the data file.
+// Failing code is repaired by the LLM and the repaired code replaces the stored one.
+func GenerateChart(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleEditor)
+	if !ok {
+		return
+	}
+
+	_, err := service.GenerateChart(requestContext(c), CurrentUserID(c), insight.InsightID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(c, http.StatusConflict, "generate the code first")
+			return
+		}
+		respondServiceError(c, "", err)
+		return
+	}
+
+	respondChart(c, http.StatusCreated, insight.InsightID)
+}
+
+// GetChart handles GET /api/insights/:id/chart
+func GetChart(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleViewer)
+	if !ok {
+		return
+	}
+
+	respondChart(c, http.StatusOK, insight.InsightID)
+}
+
+// respondChart writes the stored chart with its Plotly JSON embedded as an object
+func respondChart(c *gin.Context, status int, insightID int64) {
+	chart, err := service.GetInsightChart(insightID)
+	if err != nil {
+		respondServiceError(c, "no chart generated", err)
+		return
+	}
+
+	c.JSON(status, gin.H{
+		"insight_id": chart.InsightID,
+		"chart_data": json.RawMessage(chart.ChartData),
+		"updated_at": chart.UpdatedAt,
+	})
+}
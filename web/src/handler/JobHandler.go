@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"web/src/jobs"
+	"web/src/service"
+)
+
+var enqueueableKinds = map[string]bool{
+	jobs.KindProcessInsight:  true,
+	jobs.KindGenerateOptions: true,
+	jobs.KindGenerateCode:    true,
+	jobs.KindGenerateChart:   true,
+}
+
+type enqueueJobRequest struct {
+	Kind string `json:"kind" binding:"required"`
+}
+
+// EnqueueJob handles POST /api/insights/:id/jobs by queueing a processing step of the insight
+func EnqueueJob(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var request enqueueJobRequest
+	if err := c.ShouldBindJSON(&request); err != nil || !enqueueableKinds[request.Kind] {
+		respondError(c, http.StatusBadRequest, "invalid job kind")
+		return
+	}
+
+	if err := service.CheckBudget(CurrentUserID(c)); err != nil {
+		respondServiceError(c, "", err)
+		return
+	}
+
+	jobID, err := jobs.Enqueue(CurrentUserID(c), insight.InsightID, request.Kind)
+	if err != nil {
+		respondServiceError(c, "", err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"insight_id": insight.InsightID, "job_id": jobID})
+}
+
+// GetInsightStatus handles GET /insights/:id/status and reports the state of the insight's jobs.
+// The state is the one of the latest job, or "idle" if no job was queued for the insight.
+func GetInsightStatus(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleViewer)
+	if !ok {
+		return
+	}
+
+	insightJobs, err := jobs.GetJobs(insight.InsightID)
+	if err != nil {
+		respondServiceError(c, "", err)
+		return
+	}
+
+	state := "idle"
+	if len(insightJobs) > 0 {
+		state = insightJobs[0].State
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"insight_id": insight.InsightID,
+		"state":      state,
+		"jobs":       insightJobs,
+	})
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"web/src/ingest"
+	"web/src/jobs"
+	"web/src/service"
+)
+
+// UploadPDF handles POST /api/pdfs by queueing the extraction of the tables of a PDF file. Clients poll
+// /api/pdfs/:document_id for the processed pages. Each table is returned as an image extraction,
+// the user reviews and confirms the tables to analyze via /api/images/:extraction_id.
+func UploadPDF(c *gin.Context) {
+	// Check the budget before the file is stored
+	if err := service.CheckBudget(CurrentUserID(c)); err != nil {
+		respondServiceError(c, "", err)
+		return
+	}
+
+	fileName, key, err := ingest.ReceivePDF(c.Request, "file")
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	document, err := service.CreatePDFDocument(CurrentUserID(c), fileName, key)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	jobID, err := jobs.EnqueueDocument(document.UserID, document.DocumentID, jobs.KindExtractPDF)
+	if err != nil {
+		service.FailPDFDocument(document.DocumentID, err)
+		respondServiceError(c, "", err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"document": document, "job_id": jobID})
+}
+
+// GetPDF handles GET /api/pdfs/:document_id and returns the document with the tables extracted from it
+func GetPDF(c *gin.Context) {
+	documentID, err := strconv.ParseInt(c.Param("document_id"), 10, 64)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid document id")
+		return
+	}
+
+	document, tables, err := service.GetPDFDocument(CurrentUserID(c), documentID)
+	if err != nil {
+		respondServiceError(c, "pdf document not found", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"document": document, "tables": tables})
+}
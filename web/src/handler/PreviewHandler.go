@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"web/src/model"
+	"web/src/service"
+)
+
+// GetDataPreview handles GET /api/insights/:id/preview and returns a page of the uploaded rows.
+// Query parameters: page, page_size, sort, order=asc|desc and filter[<column>]=<value>.
+func GetDataPreview(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleViewer)
+	if !ok {
+		return
+	}
+
+	query := model.PreviewQuery{
+		Sort:       c.Query("sort"),
+		Descending: c.Query("order") == "desc",
+		Filters:    c.QueryMap("filter"),
+	}
+	var err error
+	if page := c.Query("page"); page != "" {
+		if query.Page, err = strconv.Atoi(page); err != nil {
+			respondError(c, http.StatusBadRequest, "page must be a number")
+			return
+		}
+	}
+	if pageSize := c.Query("page_size"); pageSize != "" {
+		if query.PageSize, err = strconv.Atoi(pageSize); err != nil {
+			respondError(c, http.StatusBadRequest, "page_size must be a number")
+			return
+		}
+	}
+
+	preview, err := service.GetDataPreview(requestContext(c), insight.InsightID, query)
+	if errors.Is(err, service.ErrInvalidPreviewQuery) {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondServiceError(c, "insight data not found", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, preview)
+}
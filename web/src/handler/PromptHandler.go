@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"web/src/prompts"
+	"web/src/util"
+)
+
+// RequireAdmin only lets requests through that carry the ADMIN_TOKEN in the X-Admin-Token header.
+// Without a configured token the admin endpoints are disabled.
+func RequireAdmin(c *gin.Context) {
+	token := util.Env("ADMIN_TOKEN")
+	if token == "" || subtle.ConstantTimeCompare([]byte(c.GetHeader("X-Admin-Token")), []byte(token)) != 1 {
+		respondError(c, http.StatusForbidden, "forbidden")
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// ListPrompts handles GET /api/admin/prompts and lists the versions of all prompt templates
+func ListPrompts(c *gin.Context) {
+	versions, err := prompts.ListVersions()
+	if err != nil {
+		respondServiceError(c, "", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, versions)
+}
+
+// GetPrompt handles GET /api/admin/prompts/:name/versions/:version and returns the source of a template version
+func GetPrompt(c *gin.Context) {
+	body, err := prompts.GetBody(c.Param("name"), c.Param("version"))
+	if err != nil {
+		respondPromptError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"name": c.Param("name"), "version": c.Param("version"), "body": body})
+}
+
+// CreatePrompt handles PUT /api/admin/prompts/:name/versions/:version and stores a new template version.
+// The version has to be activated before it's used.
+func CreatePrompt(c *gin.Context) {
+	var request struct {
+		Body string `json:"body" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "body is required")
+		return
+	}
+
+	err := prompts.SaveVersion(c.Param("name"), c.Param("version"), request.Body)
+	if err != nil {
+		respondPromptError(c, err)
+		return
+	}
+
+	c.Status(http.StatusCreated)
+}
+
+// ActivatePrompt handles PUT /api/admin/prompts/:name/active with a map of versions to weights,
+// e.g. {"v1": 1, "v2": 1} splits the renders evenly between v1 and v2. An empty map rolls back
+// to the latest embedded version.
+func ActivatePrompt(c *gin.Context) {
+	var weights map[string]int
+	if err := c.ShouldBindJSON(&weights); err != nil {
+		respondError(c, http.StatusBadRequest, "expected a map of versions to weights")
+		return
+	}
+
+	err := prompts.Activate(c.Param("name"), weights)
+	if err != nil {
+		respondPromptError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func respondPromptError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, prompts.ErrUnknownVersion):
+		respondError(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, prompts.ErrVersionExists):
+		respondError(c, http.StatusConflict, err.Error())
+	case errors.Is(err, prompts.ErrInvalidTemplate):
+		respondError(c, http.StatusBadRequest, err.Error())
+	default:
+		respondServiceError(c, "", err)
+	}
+}
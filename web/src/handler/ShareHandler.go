@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"web/src/service"
+)
+
+// ListInsightShares handles GET /api/insights/:id/shares and lists the users the insight is shared with
+func ListInsightShares(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleOwner)
+	if !ok {
+		return
+	}
+
+	shares, err := service.ListInsightShares(insight.InsightID)
+	if err != nil {
+		respondServiceError(c, "", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, shares)
+}
+
+// ShareInsight handles PUT /api/insights/:id/shares by sharing the insight with a registered user as editor or viewer.
+// Viewers see the data, analyses and charts, editors also generate them.
+func ShareInsight(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleOwner)
+	if !ok {
+		return
+	}
+
+	var request memberRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "email and role are required")
+		return
+	}
+
+	err := service.ShareInsight(insight.InsightID, request.Email, request.Role)
+	if err != nil {
+		respondMembershipError(c, err)
+		return
+	}
+
+	shares, err := service.ListInsightShares(insight.InsightID)
+	if err != nil {
+		respondServiceError(c, "", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, shares)
+}
+
+// UnshareInsight handles DELETE /api/insights/:id/shares/:user_id
+func UnshareInsight(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleOwner)
+	if !ok {
+		return
+	}
+	userID, err := strconv.ParseInt(c.Param("user_id"), 10, 64)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	err = service.UnshareInsight(insight.InsightID, userID)
+	if err != nil {
+		respondServiceError(c, "share not found", err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
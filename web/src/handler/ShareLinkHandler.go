@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"web/src/dbmodel"
+	"web/src/service"
+	"web/src/util"
+)
+
+type createShareLinkRequest struct {
+	// ExpiresInHours limits how long the link is valid, links without expiry are valid until they're revoked
+	ExpiresInHours int `json:"expires_in_hours"`
+}
+
+// shareLinkResponse is a share link with its public URL and the HTML snippet embedding the chart
+type shareLinkResponse struct {
+	dbmodel.ShareLink
+	URL   string `json:"url"`
+	Embed string `json:"embed"`
+}
+
+// CreateShareLink handles POST /api/insights/:id/links and creates a public, read-only link to the chart of the insight
+func CreateShareLink(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleOwner)
+	if !ok {
+		return
+	}
+
+	// The body is optional, a request without one creates a link without expiry
+	var request createShareLinkRequest
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		respondError(c, http.StatusBadRequest, "invalid expires_in_hours")
+		return
+	}
+
+	expiresIn := time.Duration(request.ExpiresInHours) * time.Hour
+	link, token, err := service.CreateShareLink(insight.InsightID, CurrentUserID(c), expiresIn)
+	if errors.Is(err, service.ErrInvalidShareLink) {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, service.ErrShareLinksDisabled) {
+		respondError(c, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if err != nil {
+		respondServiceError(c, "no chart generated", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, newShareLinkResponse(c, link, token))
+}
+
+// ListShareLinks handles GET /api/insights/:id/links and lists the share links of the insight with their view counts
+func ListShareLinks(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleOwner)
+	if !ok {
+		return
+	}
+
+	links, err := service.ListShareLinks(insight.InsightID)
+	if err != nil {
+		respondServiceError(c, "", err)
+		return
+	}
+
+	responses := make([]shareLinkResponse, 0, len(links))
+	for _, link := range links {
+		token, err := service.ShareLinkToken(link.LinkID)
+		if err != nil {
+			respondError(c, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		responses = append(responses, newShareLinkResponse(c, link, token))
+	}
+
+	c.JSON(http.StatusOK, responses)
+}
+
+// RevokeShareLink handles DELETE /api/insights/:id/links/:link_id
+func RevokeShareLink(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleOwner)
+	if !ok {
+		return
+	}
+	linkID, err := strconv.ParseInt(c.Param("link_id"), 10, 64)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid link id")
+		return
+	}
+
+	err = service.RevokeShareLink(insight.InsightID, linkID)
+	if err != nil {
+		respondServiceError(c, "share link not found", err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ShowSharedChart handles GET /share/:token and renders the shared chart on a standalone page without login
+func ShowSharedChart(c *gin.Context) {
+	// The standalone page isn't framed, embeds use /embed/:token
+	c.Header("X-Frame-Options", "DENY")
+	renderSharedChart(c, false)
+}
+
+// EmbedSharedChart handles GET /embed/:token and renders only the shared chart, for iframes on other sites
+func EmbedSharedChart(c *gin.Context) {
+	renderSharedChart(c, true)
+}
+
+func renderSharedChart(c *gin.Context, embed bool) {
+	// Every request counts as a view and revoked links must stop working, so the page isn't cached.
+	// The token is in the URL and isn't passed on to other sites.
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("X-Robots-Tag", "noindex")
+
+	chart, err := service.ViewSharedChart(c.Param("token"))
+	if errors.Is(err, service.ErrInvalidToken) || errors.Is(err, sql.ErrNoRows) {
+		c.String(http.StatusNotFound, "This link is invalid, expired or was revoked.")
+		return
+	}
+	if err != nil {
+		log.Println("Failed to show shared chart:", err)
+		c.String(http.StatusInternalServerError, "Oops! Something went wrong.")
+		return
+	}
+
+	// The chart is passed as JSON instead of template.JS, so html/template escapes it inside the script
+	c.HTML(http.StatusOK, "share.html", gin.H{
+		"Chart": json.RawMessage(chart.ChartData),
+		"Embed": embed,
+	})
+}
+
+func newShareLinkResponse(c *gin.Context, link dbmodel.ShareLink, token string) shareLinkResponse {
+	base := publicBaseURL(c)
+	embedURL := base + "/embed/" + token
+	return shareLinkResponse{
+		ShareLink: link,
+		URL:       base + "/share/" + token,
+		Embed: fmt.Sprintf(`<iframe src="%s" width="100%%" height="480" style="border: 0;" loading="lazy" title="Chart"></iframe>`,
+			html.EscapeString(embedURL)),
+	}
+}
+
+// publicBaseURL returns BASE_URL, or the origin of the request if it isn't configured
+func publicBaseURL(c *gin.Context) string {
+	if base := strings.TrimSuffix(util.Env("BASE_URL"), "/"); base != "" {
+		return base
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"web/src/service"
+)
+
+type selectSheetsRequest struct {
+	Sheets []string `json:"sheets" binding:"required"`
+}
+
+// GetSheets handles GET /api/insights/:id/sheets and returns the sheets of an uploaded workbook
+// with their dimensions and headers together with the selected sheets
+func GetSheets(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleViewer)
+	if !ok {
+		return
+	}
+
+	sheets, selected, err := service.GetSheets(insight.InsightID)
+	if err != nil {
+		respondServiceError(c, "insight data not found", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sheets": sheets, "selected": selected})
+}
+
+// SelectSheets handles PUT /api/insights/:id/sheets. The first selected sheet is the data of the insight,
+// generated code can access every selected sheet in the dict dfs.
+func SelectSheets(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var request selectSheetsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "sheets is required")
+		return
+	}
+
+	err := service.SelectSheets(requestContext(c), insight.InsightID, request.Sheets)
+	if errors.Is(err, service.ErrInvalidSheetSelection) {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	sheets, selected, err := service.GetSheets(insight.InsightID)
+	if err != nil {
+		respondServiceError(c, "insight data not found", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sheets": sheets, "selected": selected})
+}
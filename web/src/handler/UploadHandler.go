@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"strconv"
+	"web/src/service"
+)
+
+type createUploadRequest struct {
+	FileName string `json:"file_name" binding:"required"`
+}
+
+// CreateUpload handles POST /api/uploads and starts a chunked upload for files too large for a single request
+func CreateUpload(c *gin.Context) {
+	var request createUploadRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "file_name is required")
+		return
+	}
+
+	session, err := service.CreateUploadSession(CurrentUserID(c), request.FileName)
+	if err != nil {
+		respondUploadError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"upload_id":     session.UploadID,
+		"min_part_size": service.MinUploadPartSize,
+		"max_part_size": service.MaxUploadPartSize(),
+	})
+}
+
+// GetUpload handles GET /api/uploads/:upload_id and returns the session with the parts received so far,
+// so a client can resume an interrupted upload
+func GetUpload(c *gin.Context) {
+	uploadID, ok := uploadIDParam(c)
+	if !ok {
+		return
+	}
+
+	session, parts, err := service.GetUploadSession(CurrentUserID(c), uploadID)
+	if err != nil {
+		respondServiceError(c, "upload not found", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"upload": session, "parts": parts})
+}
+
+// UploadPart handles PUT /api/uploads/:upload_id/parts/:part with the raw bytes of the part as body
+func UploadPart(c *gin.Context) {
+	uploadID, ok := uploadIDParam(c)
+	if !ok {
+		return
+	}
+	partNumber, err := strconv.ParseInt(c.Param("part"), 10, 64)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid part number")
+		return
+	}
+
+	maxSize := service.MaxUploadPartSize()
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSize+1))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "failed to read part")
+		return
+	}
+	if int64(len(data)) > maxSize {
+		respondError(c, http.StatusRequestEntityTooLarge, "part exceeds the part size limit")
+		return
+	}
+
+	part, err := service.SaveUploadPart(CurrentUserID(c), uploadID, partNumber, data)
+	if err != nil {
+		respondSessionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, part)
+}
+
+// CompleteUpload handles POST /api/uploads/:upload_id/complete and stores the assembled file as a new insight
+func CompleteUpload(c *gin.Context) {
+	uploadID, ok := uploadIDParam(c)
+	if !ok {
+		return
+	}
+
+	insightID, err := service.CompleteUploadSession(requestContext(c), CurrentUserID(c), uploadID)
+	if err != nil {
+		respondSessionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"insight_id": insightID})
+}
+
+// AbortUpload handles DELETE /api/uploads/:upload_id
+func AbortUpload(c *gin.Context) {
+	uploadID, ok := uploadIDParam(c)
+	if !ok {
+		return
+	}
+
+	err := service.AbortUploadSession(CurrentUserID(c), uploadID)
+	if err != nil {
+		respondSessionError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func uploadIDParam(c *gin.Context) (int64, bool) {
+	uploadID, err := strconv.ParseInt(c.Param("upload_id"), 10, 64)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid upload id")
+		return 0, false
+	}
+	return uploadID, true
+}
+
+// respondSessionError maps parts and completions that don't fit the upload session to 400
+func respondSessionError(c *gin.Context, err error) {
+	if errors.Is(err, service.ErrInvalidUpload) {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, http.StatusNotFound, "upload not found")
+		return
+	}
+	respondUploadError(c, err)
+}
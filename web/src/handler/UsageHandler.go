@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+	"web/src/service"
+)
+
+type setBudgetRequest struct {
+	// MonthlyTokenBudget is the budget of the user, zero is unlimited and null uses MONTHLY_TOKEN_BUDGET
+	MonthlyTokenBudget *int64 `json:"monthly_token_budget"`
+}
+
+// GetUsage handles GET /api/usage?month=2006-01 and reports the LLM spend of the current user in a month,
+// the current month by default
+func GetUsage(c *gin.Context) {
+	monthStart := service.MonthStart(time.Now())
+	if month := c.Query("month"); month != "" {
+		parsed, err := time.ParseInLocation("2006-01", month, time.Local)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "month must be formatted as YYYY-MM")
+			return
+		}
+		monthStart = parsed
+	}
+
+	usage, err := service.GetUserUsage(CurrentUserID(c), monthStart)
+	if err != nil {
+		respondServiceError(c, "user not found", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, usage)
+}
+
+// GetInsightUsage handles GET /api/insights/:id/usage and reports the LLM spend of an insight by operation
+func GetInsightUsage(c *gin.Context) {
+	insight, ok := loadInsight(c, service.RoleViewer)
+	if !ok {
+		return
+	}
+
+	usage, err := service.GetInsightUsage(insight.InsightID)
+	if err != nil {
+		respondServiceError(c, "", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"insight_id": insight.InsightID, "by_operation": usage})
+}
+
+// GetUserBudget handles GET /api/admin/users/:user_id/budget and returns the monthly token budget of a user
+// with the tokens used in the current month
+func GetUserBudget(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("user_id"), 10, 64)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	budget, err := service.GetUserBudget(userID)
+	if err != nil {
+		respondServiceError(c, "user not found", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, budget)
+}
+
+// SetUserBudget handles PUT /api/admin/users/:user_id/budget and sets the monthly token budget of a user
+func SetUserBudget(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("user_id"), 10, 64)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	var request setBudgetRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "invalid monthly_token_budget")
+		return
+	}
+
+	budget, err := service.SetUserBudget(userID, request.MonthlyTokenBudget)
+	if errors.Is(err, service.ErrInvalidBudget) {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondServiceError(c, "user not found", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, budget)
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"web/src/db/dbtest"
+	"web/src/service"
+)
+
+func budgetRouter(t *testing.T) *gin.Engine {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	admin := r.Group("/api/admin", RequireAdmin)
+	admin.GET("/users/:user_id/budget", GetUserBudget)
+	admin.PUT("/users/:user_id/budget", SetUserBudget)
+	return r
+}
+
+func TestSetUserBudget(t *testing.T) {
+	t.Setenv("MONTHLY_TOKEN_BUDGET", "50000")
+	tests := []struct {
+		name       string
+		token      string
+		path       string
+		body       string
+		found      bool
+		stored     driver.Value
+		wantStatus int
+		wantBudget int64
+	}{
+		{name: "sets the budget", token: "secret", path: "/api/admin/users/7/budget", body: `{"monthly_token_budget": 1000}`, found: true, stored: int64(1000), wantStatus: http.StatusOK, wantBudget: 1000},
+		{name: "resets to the default", token: "secret", path: "/api/admin/users/7/budget", body: `{"monthly_token_budget": null}`, found: true, wantStatus: http.StatusOK, wantBudget: 50000},
+		{name: "requires the admin token", token: "wrong", path: "/api/admin/users/7/budget", body: `{"monthly_token_budget": 1000}`, found: true, wantStatus: http.StatusForbidden},
+		{name: "rejects invalid user ids", token: "secret", path: "/api/admin/users/ada/budget", body: `{"monthly_token_budget": 1000}`, found: true, wantStatus: http.StatusBadRequest},
+		{name: "rejects negative budgets", token: "secret", path: "/api/admin/users/7/budget", body: `{"monthly_token_budget": -1}`, found: true, wantStatus: http.StatusBadRequest},
+		{name: "reports unknown users", token: "secret", path: "/api/admin/users/8/budget", body: `{"monthly_token_budget": 1000}`, wantStatus: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := &dbtest.Conn{Results: map[string]dbtest.Result{
+				"FROM llm_usage": {Columns: []string{"used"}, Rows: [][]driver.Value{{int64(400)}}},
+			}}
+			if test.found {
+				conn.Results["UPDATE app_user"] = dbtest.Result{
+					Columns: []string{"user_id", "monthly_token_budget"},
+					Rows:    [][]driver.Value{{int64(7), test.stored}},
+				}
+			}
+			dbtest.Use(t, conn)
+
+			request := httptest.NewRequest(http.MethodPut, test.path, strings.NewReader(test.body))
+			request.Header.Set("X-Admin-Token", test.token)
+			recorder := httptest.NewRecorder()
+			budgetRouter(t).ServeHTTP(recorder, request)
+
+			if recorder.Code != test.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, test.wantStatus, recorder.Body)
+			}
+			if test.wantStatus == http.StatusForbidden || test.wantStatus == http.StatusBadRequest {
+				if conn.Ran("UPDATE app_user") {
+					t.Errorf("budget updated for a rejected request")
+				}
+			}
+			if test.wantStatus != http.StatusOK {
+				return
+			}
+			var budget service.UserBudget
+			if err := json.Unmarshal(recorder.Body.Bytes(), &budget); err != nil {
+				t.Fatal(err)
+			}
+			if budget.UserID != 7 || budget.Budget != test.wantBudget || budget.UsedTokens != 400 {
+				t.Errorf("budget = %+v, want user 7 with %d tokens and 400 used", budget, test.wantBudget)
+			}
+		})
+	}
+}
+
+func TestGetUserBudget(t *testing.T) {
+	conn := &dbtest.Conn{Results: map[string]dbtest.Result{
+		"FROM app_user":  {Columns: []string{"user_id", "monthly_token_budget"}, Rows: [][]driver.Value{{int64(7), int64(2000)}}},
+		"FROM llm_usage": {Columns: []string{"used"}, Rows: [][]driver.Value{{int64(1500)}}},
+	}}
+	dbtest.Use(t, conn)
+
+	request := httptest.NewRequest(http.MethodGet, "/api/admin/users/7/budget", nil)
+	request.Header.Set("X-Admin-Token", "secret")
+	recorder := httptest.NewRecorder()
+	budgetRouter(t).ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, http.StatusOK, recorder.Body)
+	}
+	var budget service.UserBudget
+	if err := json.Unmarshal(recorder.Body.Bytes(), &budget); err != nil {
+		t.Fatal(err)
+	}
+	if budget.Budget != 2000 || budget.UsedTokens != 1500 {
+		t.Errorf("budget = %+v, want 2000 tokens with 1500 used", budget)
+	}
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"web/src/service"
+)
+
+type createWorkspaceRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type memberRequest struct {
+	Email string `json:"email" binding:"required"`
+	Role  string `json:"role" binding:"required"`
+}
+
+// CreateWorkspace handles POST /api/workspaces, the user creating a workspace becomes its owner
+func CreateWorkspace(c *gin.Context) {
+	var request createWorkspaceRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	workspace, err := service.CreateWorkspace(CurrentUserID(c), request.Name)
+	if err != nil {
+		respondMembershipError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, workspace)
+}
+
+// ListWorkspaces handles GET /api/workspaces and lists the workspaces of the user with the user's role
+func ListWorkspaces(c *gin.Context) {
+	workspaces, err := service.ListWorkspaces(CurrentUserID(c))
+	if err != nil {
+		respondServiceError(c, "", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, workspaces)
+}
+
+// ListWorkspaceMembers handles GET /api/workspaces/:workspace_id/members
+func ListWorkspaceMembers(c *gin.Context) {
+	workspaceID, ok := workspaceIDParam(c)
+	if !ok {
+		return
+	}
+
+	members, err := service.ListWorkspaceMembers(CurrentUserID(c), workspaceID)
+	if err != nil {
+		respondServiceError(c, "workspace not found", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, members)
+}
+
+// SetWorkspaceMember handles PUT /api/workspaces/:workspace_id/members by adding a registered user as
+// owner, editor or viewer, or by changing the role of a member. Only owners manage members.
+func SetWorkspaceMember(c *gin.Context) {
+	workspaceID, ok := workspaceIDParam(c)
+	if !ok {
+		return
+	}
+
+	var request memberRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, "email and role are required")
+		return
+	}
+
+	err := service.SetWorkspaceMember(CurrentUserID(c), workspaceID, request.Email, request.Role)
+	if err != nil {
+		respondMembershipError(c, err)
+		return
+	}
+
+	members, err := service.ListWorkspaceMembers(CurrentUserID(c), workspaceID)
+	if err != nil {
+		respondServiceError(c, "workspace not found", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, members)
+}
+
+// RemoveWorkspaceMember handles DELETE /api/workspaces/:workspace_id/members/:user_id
+func RemoveWorkspaceMember(c *gin.Context) {
+	workspaceID, ok := workspaceIDParam(c)
+	if !ok {
+		return
+	}
+	memberID, err := strconv.ParseInt(c.Param("user_id"), 10, 64)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	err = service.RemoveWorkspaceMember(CurrentUserID(c), workspaceID, memberID)
+	if err != nil {
+		respondMembershipError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func workspaceIDParam(c *gin.Context) (int64, bool) {
+	workspaceID, err := strconv.ParseInt(c.Param("workspace_id"), 10, 64)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid workspace id")
+		return 0, false
+	}
+	return workspaceID, true
+}
+
+// workspaceIDQuery parses the optional query parameter workspace_id, it's nil if the parameter is missing
+func workspaceIDQuery(c *gin.Context) (*int64, bool) {
+	value := c.Query("workspace_id")
+	if value == "" {
+		return nil, true
+	}
+	workspaceID, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid workspace id")
+		return nil, false
+	}
+	return &workspaceID, true
+}
+
+// respondMembershipError maps invalid roles and memberships to 400
+func respondMembershipError(c *gin.Context, err error) {
+	if errors.Is(err, service.ErrInvalidMembership) {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondServiceError(c, "workspace or user not found", err)
+}
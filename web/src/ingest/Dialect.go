@@ -0,0 +1,294 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/csv"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+	"io"
+	"strings"
+	"unicode/utf8"
+	"web/src/model"
+)
+
+// Encodings of delimited files. Files that are neither UTF-8 nor UTF-16 are read as Windows-1252,
+// the encoding of most spreadsheet exports on Windows.
+const (
+	EncodingUTF8        = "utf-8"
+	EncodingUTF16LE     = "utf-16le"
+	EncodingUTF16BE     = "utf-16be"
+	EncodingWindows1252 = "windows-1252"
+)
+
+// headerScanRows is the number of records searched for the header row
+const headerScanRows = 30
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// sniffDialect detects the encoding, delimiter, quote character and header row of a delimited file.
+// The delimiter is only sniffed if the format doesn't fix it, like TSV does.
+func sniffDialect(format model.FileFormat, head []byte) model.FileFormat {
+	format.Encoding, format.BOM = detectEncoding(head)
+
+	decoded, _, err := transform.Bytes(decoderFor(format).Transformer, head)
+	if err != nil {
+		// Keep what was decoded before the cut off end of the sample
+		decoded = bytes.ToValidUTF8(decoded, nil)
+	}
+	text := string(decoded)
+
+	lines := sampleLines(text, headerScanRows)
+	if format.Name == FormatCSV {
+		format.Delimiter = sniffDelimiter(lines)
+	}
+	format.Quote = sniffQuote(lines, format.Delimiter)
+	format.SkipLines = sniffHeaderLine(text, format)
+	return format
+}
+
+// detectEncoding detects the encoding of a file by its byte order mark, or else by its content
+func detectEncoding(head []byte) (string, bool) {
+	switch {
+	case bytes.HasPrefix(head, bomUTF8):
+		return EncodingUTF8, true
+	case bytes.HasPrefix(head, bomUTF16LE):
+		return EncodingUTF16LE, true
+	case bytes.HasPrefix(head, bomUTF16BE):
+		return EncodingUTF16BE, true
+	}
+
+	// UTF-16 text without a byte order mark has a zero byte next to every ASCII character
+	var evenZeros, oddZeros int
+	sample := head[:min(len(head), 1024)]
+	for i, b := range sample {
+		if b == 0 && i%2 == 0 {
+			evenZeros++
+		} else if b == 0 {
+			oddZeros++
+		}
+	}
+	half := len(sample) / 2
+	switch {
+	case half > 0 && oddZeros > half/3 && evenZeros < half/20:
+		return EncodingUTF16LE, false
+	case half > 0 && evenZeros > half/3 && oddZeros < half/20:
+		return EncodingUTF16BE, false
+	case validUTF8(head):
+		return EncodingUTF8, false
+	}
+	return EncodingWindows1252, false
+}
+
+// validUTF8 reports whether the sample is valid UTF-8, ignoring a character cut off at its end
+func validUTF8(head []byte) bool {
+	if utf8.Valid(head) {
+		return true
+	}
+	for i := len(head) - 1; i >= max(0, len(head)-utf8.UTFMax); i-- {
+		if utf8.RuneStart(head[i]) {
+			return !utf8.FullRune(head[i:]) && utf8.Valid(head[:i])
+		}
+	}
+	return false
+}
+
+// decoderFor returns the decoder transcoding a file in the encoding of the format to UTF-8.
+// Byte order marks are removed.
+func decoderFor(format model.FileFormat) *encoding.Decoder {
+	bomPolicy := unicode.IgnoreBOM
+	if format.BOM {
+		bomPolicy = unicode.ExpectBOM
+	}
+	switch format.Encoding {
+	case EncodingUTF16LE:
+		return unicode.UTF16(unicode.LittleEndian, bomPolicy).NewDecoder()
+	case EncodingUTF16BE:
+		return unicode.UTF16(unicode.BigEndian, bomPolicy).NewDecoder()
+	case EncodingWindows1252:
+		return charmap.Windows1252.NewDecoder()
+	}
+	if format.BOM {
+		return unicode.UTF8BOM.NewDecoder()
+	}
+	return encoding.Nop.NewDecoder()
+}
+
+// sniffDelimiter picks the delimiter that splits most lines into the same number of fields.
+// Title rows above the header don't count against a delimiter. Commas are the fallback.
+func sniffDelimiter(lines []string) string {
+	best, bestLines, bestFields := ",", 0, 1
+	for _, delimiter := range delimiters {
+		counts := map[int]int{}
+		for _, line := range lines {
+			counts[countOutsideQuotes(line, delimiter, '"')+1]++
+		}
+		for fields, count := range counts {
+			if fields < 2 {
+				continue
+			}
+			if count > bestLines || (count == bestLines && fields > bestFields) {
+				best, bestLines, bestFields = delimiter, count, fields
+			}
+		}
+	}
+	return best
+}
+
+// sniffQuote picks the character quoting fields, single quotes are used if they enclose more fields than double quotes
+func sniffQuote(lines []string, delimiter string) string {
+	double, single := 0, 0
+	for _, line := range lines {
+		for _, field := range strings.Split(line, delimiter) {
+			field = strings.TrimSpace(field)
+			if len(field) >= 2 && strings.HasPrefix(field, `"`) && strings.HasSuffix(field, `"`) {
+				double++
+			}
+			if len(field) >= 2 && strings.HasPrefix(field, "'") && strings.HasSuffix(field, "'") {
+				single++
+			}
+		}
+	}
+	if single > double {
+		return "'"
+	}
+	return `"`
+}
+
+// sniffHeaderLine returns the number of lines above the header row. Title rows above the header
+// end before the last column, so the header is the first row that spans as many columns as most
+// rows of the sample. A header has no numeric cells, a file starting with data has no header line to skip.
+func sniffHeaderLine(text string, format model.FileFormat) int {
+	reader := newCSVReader(strings.NewReader(text), format)
+	reader.FieldsPerRecord = -1
+
+	type record struct {
+		line  int
+		span  int
+		named bool
+	}
+	var records []record
+	spans := map[int]int{}
+	for len(records) < headerScanRows {
+		fields, err := reader.Read()
+		if err != nil {
+			break // end of sample
+		}
+		line, _ := reader.FieldPos(0)
+		r := record{line: line, named: true}
+		for i, field := range fields {
+			if field = strings.TrimSpace(field); field != "" {
+				r.span = i + 1
+				if isNumeric(field) {
+					r.named = false
+				}
+			}
+		}
+		records = append(records, r)
+		spans[r.span]++
+	}
+
+	width, widthCount := 0, 0
+	for span, count := range spans {
+		if count > widthCount || (count == widthCount && span > width) {
+			width, widthCount = span, count
+		}
+	}
+	for _, r := range records {
+		if r.span >= max(width, 2) {
+			if r.named {
+				return r.line - 1
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// sampleLines returns up to n complete non-empty lines from the start of a file
+func sampleLines(text string, n int) []string {
+	lines := strings.Split(text, "\n")
+	if len(lines) > 1 {
+		// The last line may be cut off
+		lines = lines[:len(lines)-1]
+	}
+	var sampled []string
+	for _, line := range lines {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			sampled = append(sampled, line)
+		}
+		if len(sampled) == n {
+			break
+		}
+	}
+	return sampled
+}
+
+func countOutsideQuotes(line string, delimiter string, quote rune) int {
+	count, quoted := 0, false
+	for _, char := range line {
+		switch {
+		case char == quote:
+			quoted = !quoted
+		case !quoted && string(char) == delimiter:
+			count++
+		}
+	}
+	return count
+}
+
+// newCSVReader returns a CSV reader for UTF-8 text in the dialect of the format.
+// encoding/csv only quotes with double quotes, so single and double quotes are swapped for files
+// quoted with single quotes and swapped back by unquoteFields.
+func newCSVReader(text io.Reader, format model.FileFormat) *csv.Reader {
+	if format.Quote == "'" {
+		text = transform.NewReader(text, swapQuotes{})
+	}
+	reader := csv.NewReader(text)
+	reader.Comma = []rune(format.Delimiter)[0]
+	// Exports often contain stray quotes in unquoted fields
+	reader.LazyQuotes = true
+	return reader
+}
+
+// unquoteFields swaps back the quotes swapped by newCSVReader
+func unquoteFields(fields []string, format model.FileFormat) []string {
+	if format.Quote != "'" {
+		return fields
+	}
+	for i, field := range fields {
+		fields[i] = strings.Map(swapQuote, field)
+	}
+	return fields
+}
+
+func swapQuote(r rune) rune {
+	switch r {
+	case '"':
+		return '\''
+	case '\'':
+		return '"'
+	}
+	return r
+}
+
+// swapQuotes swaps single and double quotes, both are single bytes in UTF-8
+type swapQuotes struct {
+	transform.NopResetter
+}
+
+func (swapQuotes) Transform(dst, src []byte, atEOF bool) (int, int, error) {
+	n := min(len(dst), len(src))
+	for i := 0; i < n; i++ {
+		dst[i] = byte(swapQuote(rune(src[i])))
+	}
+	if n < len(src) {
+		return n, n, transform.ErrShortDst
+	}
+	return n, n, nil
+}
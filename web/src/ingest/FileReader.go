@@ -0,0 +1,225 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"github.com/xuri/excelize/v2"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"web/src/model"
+)
+
+// maxRows is the number of rows sampled from a file for the prompts
+const maxRows = 100
+
+// FileExtension returns the lower case extension of a file name if it's an allowed file type
+func FileExtension(fileName string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if _, ok := formats[ext]; !ok {
+		return "", fmt.Errorf("%w: invalid file type. Supported types are %s", ErrInvalidFile, supportedExtensions())
+	}
+	return ext, nil
+}
+
+// validateHeaders checks that a file has at least two columns with meaningful names
+func validateHeaders(headers []string) error {
+	if len(headers) < 2 {
+		return fmt.Errorf("%w: file must contain at least two columns", ErrInvalidFile)
+	}
+
+	// Validate each header
+	for _, header := range headers {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			return fmt.Errorf("%w: headers must not be empty", ErrInvalidFile)
+		}
+		if isNumeric(header) {
+			return fmt.Errorf("%w: header must be a non-numeric string: %s", ErrInvalidFile, header)
+		}
+		if !isMeaningfulHeader(header) {
+			return fmt.Errorf("%w: header must contain at least one letter: %s", ErrInvalidFile, header)
+		}
+		if !isValidLength(header) {
+			return fmt.Errorf("%w: header length must be between 2 and 250 characters: %s", ErrInvalidFile, header)
+		}
+	}
+	return nil
+}
+
+// Helper function to check if a string is numeric
+func isNumeric(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+// Helper function to check if the header has alphabetic characters
+func isMeaningfulHeader(header string) bool {
+	match, _ := regexp.MatchString(`[a-zA-Z]`, header)
+	return match
+}
+
+// Helper function to check the length of the header
+func isValidLength(header string) bool {
+	return len(header) > 1 && len(header) <= 250
+}
+
+// RowReader reads the rows of a data file one by one. Delimited and JSON files are parsed while
+// they are read, spreadsheets are read completely before the first row is returned.
+type RowReader struct {
+	format  Format
+	sheets  []model.SheetInfo
+	headers []string
+	next    func() ([]string, error)
+	close   func() error
+	skipped int
+}
+
+// NewRowReader detects the format of a file, reads its header row and returns a reader for the remaining rows
+func NewRowReader(ctx context.Context, reader io.Reader, ext string) (*RowReader, error) {
+	buffered, head, err := peek(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	format, err := DetectFormat(ext, head)
+	if err != nil {
+		return nil, err
+	}
+	return format.open(ctx, buffered, format)
+}
+
+// OpenRows returns a reader for the rows of a file in a format detected before,
+// e.g. the stored format of an upload with its dialect and selected sheets
+func OpenRows(ctx context.Context, reader io.Reader, fileFormat model.FileFormat) (*RowReader, error) {
+	for _, format := range formats {
+		if format.Name == fileFormat.Name {
+			format.FileFormat = fileFormat
+			return format.open(ctx, reader, format)
+		}
+	}
+	return nil, fmt.Errorf("%w: unknown format %s", ErrInvalidFile, fileFormat.Name)
+}
+
+// openExcel reads the first selected sheet of an Excel file. Without a selection the first sheet
+// holding data is selected. All sheets are enumerated with their dimensions and headers.
+func openExcel(ctx context.Context, reader io.Reader, format Format) (*RowReader, error) {
+	excelFile, err := excelize.OpenReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Excel file: %w", err)
+	}
+
+	sheets, err := excelSheets(excelFile)
+	if err != nil {
+		excelFile.Close()
+		return nil, err
+	}
+	if len(format.Sheets) == 0 {
+		format.Sheets = []string{defaultSheet(sheets)}
+	}
+
+	sheetName := format.Sheets[0]
+	if index, _ := excelFile.GetSheetIndex(sheetName); index < 0 {
+		excelFile.Close()
+		return nil, fmt.Errorf("%w: the workbook has no sheet %s", ErrInvalidFile, sheetName)
+	}
+	rows, err := excelFile.Rows(sheetName)
+	if err != nil || !rows.Next() {
+		excelFile.Close()
+		return nil, fmt.Errorf("failed to read rows from Excel sheet %s", sheetName)
+	}
+	headers, err := rows.Columns()
+	if err != nil {
+		excelFile.Close()
+		return nil, fmt.Errorf("failed to read rows from Excel sheet %s: %w", sheetName, err)
+	}
+
+	next := func() ([]string, error) {
+		if !rows.Next() {
+			if err := rows.Error(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		return rows.Columns()
+	}
+	closeFile := func() error {
+		rows.Close()
+		return excelFile.Close()
+	}
+	return &RowReader{format: format, sheets: sheets, headers: headers, next: next, close: closeFile}, nil
+}
+
+// excelSheets returns every sheet of a workbook with its dimensions and header row
+func excelSheets(excelFile *excelize.File) ([]model.SheetInfo, error) {
+	var sheets []model.SheetInfo
+	for _, name := range excelFile.GetSheetList() {
+		rows, err := excelFile.Rows(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Excel sheet %s: %w", name, err)
+		}
+
+		sheet := model.SheetInfo{Name: name, Headers: []string{}}
+		for first := true; rows.Next(); first = false {
+			columns, err := rows.Columns()
+			if err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to read Excel sheet %s: %w", name, err)
+			}
+			if first {
+				sheet.Headers = columns
+			} else {
+				sheet.Rows++
+			}
+			sheet.Columns = max(sheet.Columns, len(columns))
+		}
+		if err := rows.Close(); err != nil {
+			return nil, fmt.Errorf("failed to read Excel sheet %s: %w", name, err)
+		}
+		sheets = append(sheets, sheet)
+	}
+	if len(sheets) == 0 {
+		return nil, fmt.Errorf("the workbook has no sheets")
+	}
+	return sheets, nil
+}
+
+// defaultSheet returns the first sheet holding data, or the first sheet of an empty workbook
+func defaultSheet(sheets []model.SheetInfo) string {
+	for _, sheet := range sheets {
+		if len(sheet.Headers) > 0 {
+			return sheet.Name
+		}
+	}
+	return sheets[0].Name
+}
+
+// Format returns the detected format of the file
+func (r *RowReader) Format() model.FileFormat {
+	return r.format.FileFormat
+}
+
+// Sheets returns the sheets of a workbook, it's empty for other formats
+func (r *RowReader) Sheets() []model.SheetInfo {
+	return r.sheets
+}
+
+// Skipped returns the number of rows read so far that were skipped because they have more fields than the header row
+func (r *RowReader) Skipped() int {
+	return r.skipped
+}
+
+// Headers returns the header row
+func (r *RowReader) Headers() []string {
+	return r.headers
+}
+
+// Read returns the next row, or io.EOF after the last row
+func (r *RowReader) Read() ([]string, error) {
+	return r.next()
+}
+
+func (r *RowReader) Close() error {
+	return r.close()
+}
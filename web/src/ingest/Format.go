@@ -0,0 +1,142 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"golang.org/x/text/transform"
+	"io"
+	"sort"
+	"strings"
+	"web/src/model"
+)
+
+// Names of the formats as passed to the python environment
+const (
+	FormatCSV     = "csv"
+	FormatTSV     = "tsv"
+	FormatExcel   = "excel"
+	FormatODS     = "ods"
+	FormatJSON    = "json"
+	FormatNDJSON  = "ndjson"
+	FormatParquet = "parquet"
+)
+
+// SniffSize is the number of bytes inspected to detect the format of a file
+const SniffSize = 64 << 10
+
+// Format is a file format that can be uploaded together with the reader for its rows
+type Format struct {
+	model.FileFormat
+	open func(ctx context.Context, reader io.Reader, format Format) (*RowReader, error)
+}
+
+// formats maps the allowed file extensions to their format
+var formats = map[string]Format{
+	".csv":     {FileFormat: model.FileFormat{Name: FormatCSV, Delimiter: ","}, open: openDelimited},
+	".tsv":     {FileFormat: model.FileFormat{Name: FormatTSV, Delimiter: "\t"}, open: openDelimited},
+	".tab":     {FileFormat: model.FileFormat{Name: FormatTSV, Delimiter: "\t"}, open: openDelimited},
+	".xls":     {FileFormat: model.FileFormat{Name: FormatExcel}, open: openExcel},
+	".xlsx":    {FileFormat: model.FileFormat{Name: FormatExcel}, open: openExcel},
+	".ods":     {FileFormat: model.FileFormat{Name: FormatODS}, open: openODS},
+	".json":    {FileFormat: model.FileFormat{Name: FormatJSON}, open: openJSON},
+	".jsonl":   {FileFormat: model.FileFormat{Name: FormatNDJSON}, open: openJSON},
+	".ndjson":  {FileFormat: model.FileFormat{Name: FormatNDJSON}, open: openJSON},
+	".parquet": {FileFormat: model.FileFormat{Name: FormatParquet}, open: openParquet},
+}
+
+// delimiters are the candidates sniffed for CSV files
+var delimiters = []string{",", ";", "\t", "|"}
+
+// parquetMagic starts and ends every Parquet file
+var parquetMagic = []byte("PAR1")
+
+// supportedExtensions returns the allowed file extensions for error messages
+func supportedExtensions() string {
+	extensions := make([]string, 0, len(formats))
+	for ext := range formats {
+		extensions = append(extensions, ext)
+	}
+	sort.Strings(extensions)
+	return strings.Join(extensions, ", ")
+}
+
+// DetectFormat returns the format of a file by its extension, refined by the first bytes of its content.
+// The dialect of CSV and TSV files is sniffed and JSON files holding an object per line are read as NDJSON.
+func DetectFormat(ext string, head []byte) (Format, error) {
+	format, ok := formats[strings.ToLower(ext)]
+	if !ok {
+		return Format{}, fmt.Errorf("%w: invalid file type. Supported types are %s", ErrInvalidFile, supportedExtensions())
+	}
+
+	switch format.Name {
+	case FormatCSV, FormatTSV:
+		format.FileFormat = sniffDialect(format.FileFormat, head)
+	case FormatJSON:
+		if trimmed := bytes.TrimSpace(head); len(trimmed) > 0 && trimmed[0] == '{' {
+			format = formats[".ndjson"]
+		}
+	case FormatParquet:
+		if !bytes.HasPrefix(head, parquetMagic) {
+			return Format{}, fmt.Errorf("%w: not a Parquet file", ErrInvalidFile)
+		}
+	}
+	return format, nil
+}
+
+// openDelimited reads CSV and TSV files in their sniffed dialect, transcoded to UTF-8.
+// The lines above the header row are skipped. Like the python environment, rows with fewer fields than
+// the header row are padded with empty fields and rows with more fields are skipped and counted.
+// Other malformed rows fail with ErrInvalidFile.
+func openDelimited(ctx context.Context, reader io.Reader, format Format) (*RowReader, error) {
+	csvReader := newCSVReader(transform.NewReader(reader, decoderFor(format.FileFormat)), format.FileFormat)
+	csvReader.FieldsPerRecord = -1
+
+	var headers []string
+	for {
+		record, err := csvReader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s headers: %w", strings.ToUpper(format.Name), err)
+		}
+		if line, _ := csvReader.FieldPos(0); line > format.SkipLines {
+			headers = unquoteFields(record, format.FileFormat)
+			break
+		}
+	}
+
+	rows := &RowReader{format: format, headers: headers, close: func() error { return nil }}
+	rows.next = func() ([]string, error) {
+		for {
+			record, err := csvReader.Read()
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidFile, parseErr.Line, parseErr.Err)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if len(record) > len(headers) {
+				rows.skipped++
+				continue
+			}
+			for len(record) < len(headers) {
+				record = append(record, "")
+			}
+			return unquoteFields(record, format.FileFormat), nil
+		}
+	}
+	return rows, nil
+}
+
+// peek buffers a reader and returns the first bytes of its content for format detection
+func peek(reader io.Reader) (*bufio.Reader, []byte, error) {
+	buffered := bufio.NewReaderSize(reader, SniffSize)
+	head, err := buffered.Peek(SniffSize)
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	return buffered, head, nil
+}
@@ -0,0 +1,51 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/xuri/excelize/v2"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSamplePadsShortRowsAndSkipsLongRows(t *testing.T) {
+	data := "name,city,age\n" +
+		"Ada,London,36\n" +
+		"Grace,New York\n" +
+		"Alan,Wilmslow,41,extra\n" +
+		"Edsger,Austin,72\n"
+
+	upload, err := sample(context.Background(), strings.NewReader(data), ".csv")
+	if err != nil {
+		t.Fatalf("sample failed: %v", err)
+	}
+
+	want := [][]string{{"Ada", "London", "36"}, {"Grace", "New York", ""}, {"Edsger", "Austin", "72"}}
+	if !reflect.DeepEqual(upload.FirstRows, want) {
+		t.Errorf("first rows = %v, want %v", upload.FirstRows, want)
+	}
+	if upload.Profile.Rows != 3 || upload.Profile.SkippedRows != 1 {
+		t.Errorf("profile counts %d rows and %d skipped rows, want 3 and 1", upload.Profile.Rows, upload.Profile.SkippedRows)
+	}
+}
+
+func TestSampleRejectsHeadersWithoutRows(t *testing.T) {
+	workbook := excelize.NewFile()
+	if err := workbook.SetSheetRow("Sheet1", "A1", &[]string{"name", "city", "age"}); err != nil {
+		t.Fatal(err)
+	}
+	var xlsx bytes.Buffer
+	if err := workbook.Write(&xlsx); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{".csv": []byte("name,city,age\n"), ".xlsx": xlsx.Bytes()}
+	for ext, data := range files {
+		_, err := sample(context.Background(), bytes.NewReader(data), ext)
+		if !errors.Is(err, ErrInvalidFile) {
+			t.Errorf("%s: err = %v, want ErrInvalidFile", ext, err)
+		}
+	}
+}
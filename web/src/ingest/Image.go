@@ -0,0 +1,35 @@
+package ingest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"web/src/util"
+)
+
+// imageExtensions maps the content types of the supported images of tables to their file extension
+var imageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+}
+
+// MaxImageSize returns the size limit of uploaded images, configured by MAX_IMAGE_MB, 20MB by default
+func MaxImageSize() int64 {
+	return int64(util.EnvInt("MAX_IMAGE_MB", 20)) << 20
+}
+
+// ReadImage reads an uploaded image of a table and returns it together with its file extension.
+// The type is detected from the content, only PNG and JPEG images are accepted.
+func ReadImage(reader io.Reader) ([]byte, string, error) {
+	limited := &limitedReader{reader: reader, remaining: MaxImageSize()}
+	image, err := io.ReadAll(limited)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ext, ok := imageExtensions[http.DetectContentType(image)]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: only PNG and JPEG images are supported", ErrInvalidFile)
+	}
+	return image, ext, nil
+}
@@ -0,0 +1,116 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// openJSON reads a JSON array of objects or NDJSON with an object per line.
+// The keys of the first object are the headers, keys that only appear in later objects are ignored.
+func openJSON(ctx context.Context, reader io.Reader, format Format) (*RowReader, error) {
+	decoder := json.NewDecoder(reader)
+	if format.Name == FormatJSON {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JSON: %w", err)
+		}
+		if delim, ok := token.(json.Delim); !ok || delim != '[' {
+			return nil, errors.New("JSON files must contain an array of objects")
+		}
+	}
+
+	// more reports whether another object follows, the end of an array ends a JSON file
+	more := func() bool {
+		return format.Name == FormatNDJSON || decoder.More()
+	}
+	if !more() {
+		return nil, errors.New("JSON file contains no records")
+	}
+	headers, first, err := readObject(decoder)
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("JSON file contains no records")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pending := [][]string{objectRow(headers, first)}
+	next := func() ([]string, error) {
+		if len(pending) > 0 {
+			row := pending[0]
+			pending = nil
+			return row, nil
+		}
+		if !more() {
+			return nil, io.EOF
+		}
+		_, values, err := readObject(decoder)
+		if err != nil {
+			return nil, err
+		}
+		return objectRow(headers, values), nil
+	}
+	return &RowReader{format: format, headers: headers, next: next, close: func() error { return nil }}, nil
+}
+
+// readObject reads the next object and returns its keys in order together with the values as cells
+func readObject(decoder *json.Decoder) ([]string, map[string]string, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return nil, nil, fmt.Errorf("expected a JSON object, found %v", token)
+	}
+
+	var keys []string
+	values := map[string]string{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read JSON object: %w", err)
+		}
+		key := token.(string) // object keys are always strings
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, nil, fmt.Errorf("failed to read JSON value of %s: %w", key, err)
+		}
+		if _, seen := values[key]; !seen {
+			keys = append(keys, key)
+		}
+		values[key] = jsonCell(raw)
+	}
+	// Consume the closing brace
+	if _, err := decoder.Token(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read JSON object: %w", err)
+	}
+	return keys, values, nil
+}
+
+// jsonCell returns strings unquoted, null as an empty cell and any other value as compact JSON
+func jsonCell(raw json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+	if string(raw) == "null" {
+		return ""
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, raw); err != nil {
+		return string(raw)
+	}
+	return compact.String()
+}
+
+func objectRow(headers []string, values map[string]string) []string {
+	row := make([]string, len(headers))
+	for i, header := range headers {
+		row[i] = values[header]
+	}
+	return row
+}
@@ -0,0 +1,211 @@
+package ingest
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	odsOfficeNamespace = "urn:oasis:names:tc:opendocument:xmlns:office:1.0"
+	odsTableNamespace  = "urn:oasis:names:tc:opendocument:xmlns:table:1.0"
+	odsTextNamespace   = "urn:oasis:names:tc:opendocument:xmlns:text:1.0"
+)
+
+// openODS reads the first sheet of an OpenDocument spreadsheet. The archive is read into memory,
+// the sheet itself is parsed row by row. Empty rows are skipped.
+func openODS(ctx context.Context, reader io.Reader, format Format) (*RowReader, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ODS file: %w", err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ODS file: %w", err)
+	}
+	content, err := archive.Open("content.xml")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ODS content: %w", err)
+	}
+
+	sheet := &odsSheet{decoder: xml.NewDecoder(content)}
+	headers, err := sheet.next()
+	if err != nil {
+		content.Close()
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("ODS sheet is empty")
+		}
+		return nil, err
+	}
+	return &RowReader{format: format, headers: headers, next: sheet.next, close: content.Close}, nil
+}
+
+// odsSheet streams the rows of the first table in content.xml
+type odsSheet struct {
+	decoder *xml.Decoder
+	// row is returned again while repeats are left, ODS stores identical consecutive rows once
+	row     []string
+	repeats int
+	done    bool
+}
+
+func (s *odsSheet) next() ([]string, error) {
+	for {
+		if s.repeats > 0 {
+			s.repeats--
+			return append([]string(nil), s.row...), nil
+		}
+		if s.done {
+			return nil, io.EOF
+		}
+
+		token, err := s.decoder.Token()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ODS content: %w", err)
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			if element.Name.Space == odsTableNamespace && element.Name.Local == "table-row" {
+				row, err := s.readRow()
+				if err != nil {
+					return nil, err
+				}
+				if len(row) > 0 {
+					s.row, s.repeats = row, repeatCount(element, "number-rows-repeated")
+				}
+			}
+		case xml.EndElement:
+			// Only the first sheet is read
+			if element.Name.Space == odsTableNamespace && element.Name.Local == "table" {
+				s.done = true
+			}
+		}
+	}
+}
+
+// readRow reads the cells of a row up to its end element. Trailing empty cells are dropped,
+// which also drops the empty cells LibreOffice repeats up to the last column of the sheet.
+func (s *odsSheet) readRow() ([]string, error) {
+	var row []string
+	emptyCells := 0
+	for {
+		token, err := s.decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ODS row: %w", err)
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			if element.Name.Space != odsTableNamespace || (element.Name.Local != "table-cell" && element.Name.Local != "covered-table-cell") {
+				continue
+			}
+			value, err := s.readCell(element)
+			if err != nil {
+				return nil, err
+			}
+			repeats := repeatCount(element, "number-columns-repeated")
+			if value == "" {
+				emptyCells += repeats
+				continue
+			}
+			for ; emptyCells > 0; emptyCells-- {
+				row = append(row, "")
+			}
+			for i := 0; i < repeats; i++ {
+				row = append(row, value)
+			}
+		case xml.EndElement:
+			if element.Name.Space == odsTableNamespace && element.Name.Local == "table-row" {
+				return row, nil
+			}
+		}
+	}
+}
+
+// readCell returns the value of a cell: the typed value for numbers, dates and booleans
+// and the text of its paragraphs otherwise
+func (s *odsSheet) readCell(cell xml.StartElement) (string, error) {
+	value := ""
+	valueType := odsAttr(cell, odsOfficeNamespace, "value-type")
+	switch valueType {
+	case "float", "percentage", "currency":
+		value = odsAttr(cell, odsOfficeNamespace, "value")
+	case "date":
+		value = odsAttr(cell, odsOfficeNamespace, "date-value")
+	case "time":
+		value = odsAttr(cell, odsOfficeNamespace, "time-value")
+	case "boolean":
+		value = odsAttr(cell, odsOfficeNamespace, "boolean-value")
+	}
+
+	var paragraphs []string
+	var text strings.Builder
+	depth := 1
+	for depth > 0 {
+		token, err := s.decoder.Token()
+		if err != nil {
+			return "", fmt.Errorf("failed to read ODS cell: %w", err)
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			depth++
+			if element.Name.Space == odsTextNamespace {
+				switch element.Name.Local {
+				case "p":
+					text.Reset()
+				case "s":
+					text.WriteString(strings.Repeat(" ", repeatCount(element, "c")))
+				case "tab":
+					text.WriteString("\t")
+				case "line-break":
+					text.WriteString("\n")
+				}
+			}
+		case xml.EndElement:
+			depth--
+			if element.Name.Space == odsTextNamespace && element.Name.Local == "p" {
+				paragraphs = append(paragraphs, text.String())
+				text.Reset()
+			}
+		case xml.CharData:
+			text.Write(element)
+		}
+	}
+
+	if value != "" {
+		return value, nil
+	}
+	return strings.Join(paragraphs, "\n"), nil
+}
+
+func odsAttr(element xml.StartElement, space string, local string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Space == space && attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// repeatCount returns the value of a repeat attribute, 1 if it's missing
+func repeatCount(element xml.StartElement, local string) int {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == local {
+			if count, err := strconv.Atoi(attr.Value); err == nil && count > 0 {
+				return count
+			}
+		}
+	}
+	return 1
+}
@@ -0,0 +1,162 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"web/src/model"
+	"web/src/util"
+)
+
+// pythonPDFTablesURL extracts the tables of PDF files
+const pythonPDFTablesURL = "http://localhost:7000/pdf-tables/"
+
+// pdfMagic starts every PDF file
+var pdfMagic = []byte("%PDF-")
+
+// PDFTables are the tables found in the text of a PDF file together with images of the pages without one.
+// Scanned pages have no text, their tables are extracted from the images by the vision model.
+type PDFTables struct {
+	Pages  int
+	Tables []PDFTable
+	Images []PDFPageImage
+}
+
+// PDFTable is a table found on a page of a PDF file, pages are numbered from 1
+type PDFTable struct {
+	Page  int
+	Table model.Table
+}
+
+// PDFPageImage is a page of a PDF file rendered to PNG
+type PDFPageImage struct {
+	Page  int    `json:"page"`
+	Image []byte `json:"image"`
+}
+
+// MaxPDFSize returns the size limit of uploaded PDF files, configured by MAX_PDF_MB, 50MB by default
+func MaxPDFSize() int64 {
+	return int64(util.EnvInt("MAX_PDF_MB", 50)) << 20
+}
+
+// ReceivePDF streams the PDF file in the given field of a multipart request to S3 and returns its name and key.
+// Only the start of the file is read to check that it is a PDF file, the file is never held in memory as a whole.
+func ReceivePDF(request *http.Request, field string) (string, string, error) {
+	part, err := filePart(request, field)
+	if err != nil {
+		return "", "", err
+	}
+	defer closePart(part)
+
+	limited := &limitedReader{reader: part, remaining: MaxPDFSize()}
+	head := make([]byte, len(pdfMagic))
+	if _, err := io.ReadFull(limited, head); err != nil || !bytes.Equal(head, pdfMagic) {
+		return "", "", fmt.Errorf("%w: not a PDF file", ErrInvalidFile)
+	}
+
+	key, err := NewKey(".pdf")
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := util.StreamToS3(key, io.MultiReader(bytes.NewReader(head), limited)); err != nil {
+		// The S3 uploader doesn't keep the error of the body, the reader knows whether it stopped at the limit
+		if limited.remaining < 0 {
+			return "", "", ErrFileTooLarge
+		}
+		return "", "", fmt.Errorf("%w: %v", ErrStorageFailed, err)
+	}
+	return part.FileName(), key, nil
+}
+
+// ExtractPDFTables extracts the tables of a PDF file in the python environment. At most maxImages pages
+// without a table are rendered to images. Tables without valid headers are skipped.
+// The file is streamed to the python environment, canceling the context aborts the extraction.
+func ExtractPDFTables(ctx context.Context, reader io.Reader, maxImages int) (PDFTables, error) {
+	pipeReader, pipeWriter := io.Pipe()
+	writer := multipart.NewWriter(pipeWriter)
+	written := make(chan error, 1)
+	go func() {
+		err := writePDFForm(writer, reader, maxImages)
+		written <- err
+		pipeWriter.CloseWithError(err)
+	}()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, pythonPDFTablesURL, pipeReader)
+	if err != nil {
+		pipeReader.Close()
+		return PDFTables{}, fmt.Errorf("error creating HTTP request: %v", err)
+	}
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+
+	response, err := convertClient.Do(request)
+	if err != nil {
+		// Report why the file couldn't be sent, e.g. because it can't be read from S3
+		select {
+		case writeErr := <-written:
+			if writeErr != nil {
+				return PDFTables{}, writeErr
+			}
+		default:
+		}
+		return PDFTables{}, fmt.Errorf("failed to extract tables of pdf file: %w", err)
+	}
+	defer func(body io.ReadCloser) {
+		if err := body.Close(); err != nil {
+			log.Println("Failed to close response body", err)
+		}
+	}(response.Body)
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return PDFTables{}, fmt.Errorf("error reading response body: %v", err)
+	}
+	if response.StatusCode == http.StatusBadRequest {
+		return PDFTables{}, fmt.Errorf("%w: %s", ErrInvalidFile, body)
+	}
+	if response.StatusCode != http.StatusOK {
+		return PDFTables{}, fmt.Errorf("failed to extract tables of pdf file: %s", body)
+	}
+
+	var extracted struct {
+		Pages  int `json:"pages"`
+		Tables []struct {
+			Page int        `json:"page"`
+			Rows [][]string `json:"rows"`
+		} `json:"tables"`
+		Images []PDFPageImage `json:"images"`
+	}
+	if err := json.Unmarshal(body, &extracted); err != nil {
+		return PDFTables{}, fmt.Errorf("error decoding JSON response: %v", err)
+	}
+
+	tables := PDFTables{Pages: extracted.Pages, Images: extracted.Images}
+	for _, table := range extracted.Tables {
+		parsed, err := NewTable(table.Rows)
+		if err != nil {
+			log.Printf("Skipped table on page %d: %v", table.Page, err)
+			continue
+		}
+		tables.Tables = append(tables.Tables, PDFTable{Page: table.Page, Table: parsed})
+	}
+	return tables, nil
+}
+
+func writePDFForm(writer *multipart.Writer, reader io.Reader, maxImages int) error {
+	if err := writer.WriteField("max_images", strconv.Itoa(maxImages)); err != nil {
+		return fmt.Errorf("error writing max_images to form field: %v", err)
+	}
+	filePart, err := writer.CreateFormFile("file", "data.pdf")
+	if err != nil {
+		return fmt.Errorf("error creating form file for upload: %v", err)
+	}
+	if _, err := io.Copy(filePart, reader); err != nil {
+		return fmt.Errorf("error copying file data: %v", err)
+	}
+	return writer.Close()
+}
@@ -0,0 +1,89 @@
+package ingest
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"time"
+)
+
+// pythonConvertURL converts files that can't be parsed in Go to CSV
+const pythonConvertURL = "http://localhost:7000/to-csv/"
+
+// convertClient bounds conversions, which read the whole file before the first row is returned
+var convertClient = &http.Client{Timeout: 10 * time.Minute}
+
+// openParquet reads a Parquet file by streaming it to the python environment and parsing the CSV it returns
+func openParquet(ctx context.Context, reader io.Reader, format Format) (*RowReader, error) {
+	body, err := convertToCSV(ctx, reader, format)
+	if err != nil {
+		return nil, err
+	}
+
+	csvReader := csv.NewReader(body)
+	headers, err := csvReader.Read()
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("failed to read converted %s headers: %w", format.Name, err)
+	}
+	return &RowReader{format: format, headers: headers, next: csvReader.Read, close: body.Close}, nil
+}
+
+// convertToCSV posts a file to the python environment and returns the body of the CSV response.
+// Canceling the context aborts the conversion.
+func convertToCSV(ctx context.Context, reader io.Reader, format Format) (io.ReadCloser, error) {
+	pipeReader, pipeWriter := io.Pipe()
+	writer := multipart.NewWriter(pipeWriter)
+	written := make(chan error, 1)
+	go func() {
+		err := writeConvertForm(writer, reader, format)
+		written <- err
+		pipeWriter.CloseWithError(err)
+	}()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, pythonConvertURL, pipeReader)
+	if err != nil {
+		pipeReader.Close()
+		return nil, fmt.Errorf("error creating HTTP request: %v", err)
+	}
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+
+	response, err := convertClient.Do(request)
+	if err != nil {
+		// Report why the file couldn't be sent, e.g. because it exceeds the upload limit
+		select {
+		case writeErr := <-written:
+			if writeErr != nil {
+				return nil, writeErr
+			}
+		default:
+		}
+		return nil, fmt.Errorf("failed to convert %s file: %w", format.Name, err)
+	}
+	if response.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		if err := response.Body.Close(); err != nil {
+			log.Println("Failed to close response body", err)
+		}
+		return nil, fmt.Errorf("failed to convert %s file: %s", format.Name, detail)
+	}
+	return response.Body, nil
+}
+
+func writeConvertForm(writer *multipart.Writer, reader io.Reader, format Format) error {
+	if err := writer.WriteField("format", format.Name); err != nil {
+		return err
+	}
+	filePart, err := writer.CreateFormFile("file", "data."+format.Name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(filePart, reader); err != nil {
+		return err
+	}
+	return writer.Close()
+}
@@ -0,0 +1,116 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"web/src/model"
+	"web/src/profile"
+	"web/src/util"
+)
+
+// MaxTableRows is the number of rows a table extracted from an image may have
+const MaxTableRows = 5000
+
+// ParseTable parses CSV text written by the LLM into a table. Markdown code fences around the CSV are removed.
+func ParseTable(text string) (model.Table, error) {
+	text = strings.TrimSpace(text)
+	if rest, ok := strings.CutPrefix(text, "```"); ok {
+		// Drop the language of the opening fence and the closing fence
+		_, rest, _ = strings.Cut(rest, "\n")
+		text = strings.TrimSuffix(strings.TrimSpace(rest), "```")
+	}
+
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return model.Table{}, fmt.Errorf("%w: failed to parse extracted CSV: %v", ErrInvalidFile, err)
+	}
+	return NewTable(records)
+}
+
+// NewTable builds a table from extracted records, the first record is the header row.
+// Cells are trimmed, empty rows are dropped and short rows are padded to the width of the header row.
+// Empty cells beyond the header row are dropped, a row with values beyond it is ErrInvalidFile, no values are lost.
+func NewTable(records [][]string) (model.Table, error) {
+	var table model.Table
+	for _, record := range records {
+		empty := true
+		for i, cell := range record {
+			record[i] = strings.TrimSpace(cell)
+			empty = empty && record[i] == ""
+		}
+		if empty {
+			continue
+		}
+		if table.Headers == nil {
+			table.Headers = record
+			continue
+		}
+
+		for _, cell := range record[min(len(record), len(table.Headers)):] {
+			if cell != "" {
+				return model.Table{}, fmt.Errorf("%w: row %d has %d cells, the header row has %d", ErrInvalidFile, len(table.Rows)+1, len(record), len(table.Headers))
+			}
+		}
+		row := make([]string, len(table.Headers))
+		copy(row, record)
+		table.Rows = append(table.Rows, row)
+	}
+
+	return table, ValidateTable(table)
+}
+
+// ValidateTable checks that a table has valid headers, at least one row and that every row is as wide as the header row
+func ValidateTable(table model.Table) error {
+	if err := validateHeaders(table.Headers); err != nil {
+		return err
+	}
+	if len(table.Rows) == 0 {
+		return fmt.Errorf("%w: table must contain at least one row", ErrInvalidFile)
+	}
+	if len(table.Rows) > MaxTableRows {
+		return fmt.Errorf("%w: table must not contain more than %d rows", ErrInvalidFile, MaxTableRows)
+	}
+	for i, row := range table.Rows {
+		if len(row) != len(table.Headers) {
+			return fmt.Errorf("%w: row %d has %d cells, the header row has %d", ErrInvalidFile, i+1, len(row), len(table.Headers))
+		}
+	}
+	return nil
+}
+
+// StoreTable stores a table as a CSV file in S3. The table is sampled and profiled directly, its format is known.
+func StoreTable(table model.Table) (Upload, error) {
+	var data bytes.Buffer
+	writer := csv.NewWriter(&data)
+	if err := writer.Write(table.Headers); err != nil {
+		return Upload{}, fmt.Errorf("failed to encode table: %w", err)
+	}
+	if err := writer.WriteAll(table.Rows); err != nil {
+		return Upload{}, fmt.Errorf("failed to encode table: %w", err)
+	}
+
+	key, err := NewKey(".csv")
+	if err != nil {
+		return Upload{}, err
+	}
+	if _, err := util.UploadToS3(key, data.Bytes()); err != nil {
+		return Upload{}, fmt.Errorf("%w: %v", ErrStorageFailed, err)
+	}
+
+	return Upload{
+		Key:       key,
+		Size:      int64(data.Len()),
+		Ext:       ".csv",
+		Format:    model.FileFormat{Name: FormatCSV, Delimiter: ",", Quote: `"`, Encoding: EncodingUTF8},
+		Headers:   table.Headers,
+		FirstRows: table.Rows[:min(len(table.Rows), maxRows)],
+		Profile:   profile.Build(table.Headers, table.Rows),
+	}, nil
+}
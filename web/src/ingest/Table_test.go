@@ -0,0 +1,50 @@
+package ingest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNewTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		records [][]string
+		want    [][]string
+		wantErr bool
+	}{
+		{
+			name:    "pads short rows",
+			records: [][]string{{"name", "age"}, {"Ada", "36"}, {"Grace"}},
+			want:    [][]string{{"Ada", "36"}, {"Grace", ""}},
+		},
+		{
+			name:    "drops empty trailing cells",
+			records: [][]string{{"name", "age"}, {"Ada", "36", " ", ""}},
+			want:    [][]string{{"Ada", "36"}},
+		},
+		{
+			name:    "rejects wide rows",
+			records: [][]string{{"name", "age"}, {"Ada", "36"}, {"Alan", "41", "Wilmslow"}},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			table, err := NewTable(test.records)
+			if test.wantErr {
+				if !errors.Is(err, ErrInvalidFile) {
+					t.Fatalf("err = %v, want ErrInvalidFile", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewTable failed: %v", err)
+			}
+			if !reflect.DeepEqual(table.Rows, test.want) {
+				t.Errorf("rows = %v, want %v", table.Rows, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package ingest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"web/src/model"
+	"web/src/profile"
+	"web/src/util"
+)
+
+var (
+	// ErrInvalidFile is returned for files that can't be analyzed
+	ErrInvalidFile = errors.New("invalid file")
+	// ErrFileTooLarge is returned for files exceeding MAX_UPLOAD_MB
+	ErrFileTooLarge = errors.New("file exceeds the upload limit")
+	// ErrStorageFailed is returned when a file can't be stored in S3
+	ErrStorageFailed = errors.New("failed to store file")
+)
+
+// Upload is a file stored in S3 together with the sample and profile taken while storing it
+type Upload struct {
+	Key       string
+	Size      int64
+	Ext       string
+	Format    model.FileFormat
+	Sheets    []model.SheetInfo
+	Headers   []string
+	FirstRows [][]string
+	Profile   model.DataProfile
+}
+
+// MaxUploadSize returns the size limit of uploaded files, configured by MAX_UPLOAD_MB, 1GB by default
+func MaxUploadSize() int64 {
+	return int64(util.EnvInt("MAX_UPLOAD_MB", 1024)) << 20
+}
+
+// ReceiveMultipart streams the file in the given field of a multipart request to S3.
+// The request body is read part by part, the file is never held in memory or on disk as a whole.
+func ReceiveMultipart(request *http.Request, field string) (Upload, error) {
+	part, err := filePart(request, field)
+	if err != nil {
+		return Upload{}, err
+	}
+	defer closePart(part)
+	return StreamFile(request.Context(), part.FileName(), part)
+}
+
+// ReceiveImage reads the image in the given field of a multipart request and returns it together with its file extension
+func ReceiveImage(request *http.Request, field string) ([]byte, string, error) {
+	part, err := filePart(request, field)
+	if err != nil {
+		return nil, "", err
+	}
+	defer closePart(part)
+	return ReadImage(part)
+}
+
+// filePart skips the parts of a multipart request up to the file in the given field
+func filePart(request *http.Request, field string) (*multipart.Part, error) {
+	reader, err := request.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: no file in field %s", ErrInvalidFile, field)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+		}
+		if part.FormName() == field && part.FileName() != "" {
+			return part, nil
+		}
+	}
+}
+
+func closePart(part *multipart.Part) {
+	if err := part.Close(); err != nil {
+		log.Println("Failed to close file:", err)
+	}
+}
+
+// StreamFile stores a file in S3 while it samples the headers and first rows and profiles all rows.
+// Invalid files are removed from S3 again.
+func StreamFile(ctx context.Context, fileName string, body io.Reader) (Upload, error) {
+	ext, err := FileExtension(fileName)
+	if err != nil {
+		return Upload{}, err
+	}
+	key, err := NewKey(ext)
+	if err != nil {
+		return Upload{}, err
+	}
+
+	limited := &limitedReader{reader: body, remaining: MaxUploadSize()}
+	pipeReader, pipeWriter := io.Pipe()
+	stored := make(chan error, 1)
+	go func() {
+		_, err := util.StreamToS3(key, pipeReader)
+		stored <- err
+		// Unblock the sampler if the upload failed
+		pipeReader.CloseWithError(err)
+	}()
+
+	tee := io.TeeReader(limited, pipeWriter)
+	upload, sampleErr := sample(ctx, tee, ext)
+	if sampleErr == nil {
+		// Pass the rest of the file on to S3 if the sampler stopped early
+		_, sampleErr = io.Copy(io.Discard, tee)
+	}
+
+	// The upload can only end before the pipe is closed if it failed
+	select {
+	case err := <-stored:
+		return Upload{}, fmt.Errorf("%w: %v", ErrStorageFailed, err)
+	default:
+	}
+
+	pipeWriter.CloseWithError(sampleErr)
+	storeErr := <-stored
+	if sampleErr != nil {
+		if storeErr == nil {
+			removeStored(key)
+		}
+		return Upload{}, sampleErr
+	}
+	if storeErr != nil {
+		return Upload{}, fmt.Errorf("%w: %v", ErrStorageFailed, storeErr)
+	}
+
+	upload.Key = key
+	upload.Size = limited.read
+	upload.Ext = ext
+	return upload, nil
+}
+
+// SampleStored samples and profiles a file that is already stored in S3, e.g. after a chunked upload
+func SampleStored(ctx context.Context, key string, fileName string) (Upload, error) {
+	ext, err := FileExtension(fileName)
+	if err != nil {
+		return Upload{}, err
+	}
+
+	body, err := util.OpenFromS3(key)
+	if err != nil {
+		return Upload{}, fmt.Errorf("%w: %v", ErrStorageFailed, err)
+	}
+	defer func(body io.ReadCloser) {
+		if err := body.Close(); err != nil {
+			log.Println("Failed to close S3 object body", err)
+		}
+	}(body)
+
+	counter := &limitedReader{reader: body, remaining: MaxUploadSize()}
+	upload, err := sample(ctx, counter, ext)
+	if err != nil {
+		return Upload{}, err
+	}
+
+	upload.Key = key
+	upload.Size = counter.read
+	upload.Ext = ext
+	return upload, nil
+}
+
+// ResampleStored samples and profiles a stored file again in the given format, e.g. after other sheets
+// of a workbook were selected
+func ResampleStored(ctx context.Context, key string, ext string, format model.FileFormat) (Upload, error) {
+	body, err := util.OpenFromS3(key)
+	if err != nil {
+		return Upload{}, fmt.Errorf("%w: %v", ErrStorageFailed, err)
+	}
+	defer func(body io.ReadCloser) {
+		if err := body.Close(); err != nil {
+			log.Println("Failed to close S3 object body", err)
+		}
+	}(body)
+
+	counter := &limitedReader{reader: body, remaining: MaxUploadSize()}
+	rows, err := OpenRows(ctx, counter, format)
+	if err != nil {
+		return Upload{}, readError(err)
+	}
+	upload, err := sampleRows(rows)
+	if err != nil {
+		return Upload{}, err
+	}
+
+	upload.Key = key
+	upload.Size = counter.read
+	upload.Ext = ext
+	return upload, nil
+}
+
+// sample reads the headers and first rows of a file and profiles all of its rows
+func sample(ctx context.Context, reader io.Reader, ext string) (Upload, error) {
+	rows, err := NewRowReader(ctx, reader, ext)
+	if err != nil {
+		return Upload{}, readError(err)
+	}
+	return sampleRows(rows)
+}
+
+// readError marks the error of a file that can't be read as an invalid file, unless it exceeds the upload limit
+func readError(err error) error {
+	if errors.Is(err, ErrFileTooLarge) || errors.Is(err, ErrInvalidFile) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrInvalidFile, err)
+}
+
+// sampleRows reads the headers and first rows of a row reader and profiles all rows
+func sampleRows(rows *RowReader) (Upload, error) {
+	defer rows.Close()
+
+	if err := validateHeaders(rows.Headers()); err != nil {
+		return Upload{}, err
+	}
+
+	upload := Upload{Format: rows.Format(), Sheets: rows.Sheets(), Headers: rows.Headers()}
+	profiler := profile.NewProfiler(rows.Headers())
+	for {
+		row, err := rows.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Upload{}, readError(err)
+		}
+		if len(upload.FirstRows) < maxRows {
+			upload.FirstRows = append(upload.FirstRows, row)
+		}
+		profiler.Add(row)
+	}
+
+	// Every format needs a data row besides the headers, the analysis has nothing to sample otherwise
+	if len(upload.FirstRows) < 1 {
+		return Upload{}, fmt.Errorf("%w: file must contain at least two rows", ErrInvalidFile)
+	}
+	upload.Profile = profiler.Profile()
+	upload.Profile.SkippedRows = rows.Skipped()
+	if upload.Profile.SkippedRows > 0 {
+		log.Printf("Skipped %d rows with more fields than the header row", upload.Profile.SkippedRows)
+	}
+	return upload, nil
+}
+
+// NewKey returns a random S3 key for a file with the given extension
+func NewKey(ext string) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate file key: %w", err)
+	}
+	return hex.EncodeToString(random) + ext, nil
+}
+
+func removeStored(key string) {
+	if err := util.DeleteFromS3(key); err != nil {
+		log.Println("Failed to remove invalid file from S3:", err)
+	}
+}
+
+// limitedReader counts the bytes read and fails with ErrFileTooLarge beyond the limit
+type limitedReader struct {
+	reader    io.Reader
+	remaining int64
+	read      int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if r.remaining < 0 {
+		return 0, ErrFileTooLarge
+	}
+	// Read one byte more than allowed to detect files exceeding the limit
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, ErrFileTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,167 @@
+package jobs
+
+import (
+	"fmt"
+	"time"
+	"web/src/db"
+	"web/src/dbmodel"
+)
+
+// Job states
+const (
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+)
+
+// Job kinds
+const (
+	KindProcessInsight  = "process_insight"
+	KindGenerateOptions = "generate_options"
+	KindGenerateCode    = "generate_code"
+	KindGenerateChart   = "generate_chart"
+	KindExtractPDF      = "extract_pdf"
+)
+
+const jobColumns = "job_id, insight_id, document_id, user_id, kind, state, error, attempts, created_at, started_at, finished_at"
+
+// wakeup notifies idle workers of this process about a newly queued job
+var wakeup = make(chan struct{}, 1)
+
+// Enqueue adds a job for an insight to the queue and returns its id. The LLM calls of the job are attributed to the user.
+func Enqueue(userID int64, insightID int64, kind string) (int64, error) {
+	return enqueue(userID, &insightID, nil, kind)
+}
+
+// EnqueueDocument adds a job for a PDF document to the queue and returns its id
+func EnqueueDocument(userID int64, documentID int64, kind string) (int64, error) {
+	return enqueue(userID, nil, &documentID, kind)
+}
+
+func enqueue(userID int64, insightID *int64, documentID *int64, kind string) (int64, error) {
+	query := `
+		INSERT INTO job (insight_id, document_id, user_id, kind, state, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING job_id;
+	`
+
+	var jobID int64
+	err := db.DB().QueryRow(query, insightID, documentID, userID, kind, StateQueued, time.Now()).Scan(&jobID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to enqueue job: %w", err)
+	}
+
+	select {
+	case wakeup <- struct{}{}:
+	default:
+	}
+
+	return jobID, nil
+}
+
+// GetJobs returns the jobs of an insight, newest first
+func GetJobs(insightID int64) ([]dbmodel.Job, error) {
+	query := `
+		SELECT ` + jobColumns + `
+		FROM job
+		WHERE insight_id = $1
+		ORDER BY job_id DESC;
+	`
+
+	jobs := []dbmodel.Job{}
+	err := db.DB().Select(&jobs, query, insightID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get jobs: %w", err)
+	}
+
+	return jobs, nil
+}
+
+// claim marks the oldest queued job as running and returns it.
+// SKIP LOCKED lets several workers, also in other processes, claim jobs concurrently.
+func claim() (dbmodel.Job, bool, error) {
+	query := `
+		UPDATE job SET
+			state = $1,
+			started_at = $2,
+			attempts = attempts + 1
+		WHERE job_id = (
+			SELECT job_id FROM job
+			WHERE state = $3
+			ORDER BY job_id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING ` + jobColumns + `;
+	`
+
+	var job dbmodel.Job
+	rows, err := db.DB().Queryx(query, StateRunning, time.Now(), StateQueued)
+	if err != nil {
+		return job, false, fmt.Errorf("failed to claim job: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return job, false, rows.Err()
+	}
+	if err = rows.StructScan(&job); err != nil {
+		return job, false, fmt.Errorf("failed to scan job: %w", err)
+	}
+	return job, true, nil
+}
+
+// finish stores the final state of a job
+func finish(jobID int64, jobErr error) error {
+	state, message := StateSucceeded, ""
+	if jobErr != nil {
+		state, message = StateFailed, jobErr.Error()
+	}
+
+	query := `
+		UPDATE job SET state = $2, error = $3, finished_at = $4
+		WHERE job_id = $1;
+	`
+
+	_, err := db.DB().Exec(query, jobID, state, message, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to finish job %d: %w", jobID, err)
+	}
+	return nil
+}
+
+// requeueStale puts jobs back in the queue that are running for longer than maxAge,
+// e.g. because the process running them died. Jobs already started maxAttempts times fail instead,
+// so a job crashing its worker isn't retried forever.
+func requeueStale(maxAge time.Duration, maxAttempts int) error {
+	query := `
+		UPDATE job SET
+			state = CASE WHEN attempts >= $4 THEN $5 ELSE $1 END,
+			error = CASE WHEN attempts >= $4 THEN $6 ELSE error END,
+			finished_at = CASE WHEN attempts >= $4 THEN $7 END
+		WHERE state = $2 AND started_at < $3;
+	`
+
+	now := time.Now()
+	message := fmt.Sprintf("job didn't finish in %d attempts", maxAttempts)
+	_, err := db.DB().Exec(query, StateQueued, StateRunning, now.Add(-maxAge), maxAttempts, StateFailed, message, now)
+	if err != nil {
+		return fmt.Errorf("failed to requeue stale jobs: %w", err)
+	}
+	return nil
+}
+
+// release puts a job interrupted by a shutdown back in the queue, the interrupted run doesn't count as an attempt
+func release(jobID int64) error {
+	query := `
+		UPDATE job SET state = $2, attempts = attempts - 1
+		WHERE job_id = $1 AND state = $3;
+	`
+
+	_, err := db.DB().Exec(query, jobID, StateQueued, StateRunning)
+	if err != nil {
+		return fmt.Errorf("failed to release job %d: %w", jobID, err)
+	}
+	return nil
+}
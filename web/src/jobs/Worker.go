@@ -0,0 +1,180 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+	"web/src/dbmodel"
+	"web/src/ops"
+	"web/src/progress"
+	"web/src/service"
+	"web/src/util"
+)
+
+const pollInterval = 2 * time.Second
+
+// staleCheckInterval is how often running jobs are checked for being stale
+const staleCheckInterval = time.Minute
+
+// StartWorkers starts a pool of workers processing queued jobs in the background.
+// A job running longer than timeout is cancelled. Jobs running longer than staleAfter, e.g. because their worker
+// died, are requeued until they were started maxAttempts times, then they fail.
+// When ctx is done the running jobs are cancelled and put back in the queue, the returned channel is closed once
+// all workers stopped.
+func StartWorkers(ctx context.Context, count int, timeout time.Duration, staleAfter time.Duration, maxAttempts int) <-chan struct{} {
+	var workers sync.WaitGroup
+	for i := 0; i < count; i++ {
+		workers.Add(1)
+		go func(worker int) {
+			defer workers.Done()
+			work(ctx, worker, timeout)
+		}(i)
+	}
+	go watchStale(ctx, staleAfter, maxAttempts)
+	log.Printf("Started %d job workers", count)
+
+	stopped := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(stopped)
+	}()
+	return stopped
+}
+
+// watchStale requeues or fails stale jobs on start and every staleCheckInterval until ctx is done
+func watchStale(ctx context.Context, staleAfter time.Duration, maxAttempts int) {
+	ticker := time.NewTicker(staleCheckInterval)
+	defer ticker.Stop()
+	for {
+		if err := requeueStale(staleAfter, maxAttempts); err != nil {
+			log.Println("Failed to requeue stale jobs:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func work(ctx context.Context, worker int, timeout time.Duration) {
+	for ctx.Err() == nil {
+		job, found, err := claim()
+		if err != nil {
+			log.Println("Worker", worker, "failed to claim a job:", err)
+		}
+		if !found {
+			select {
+			case <-ctx.Done():
+			case <-wakeup:
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+
+		now := time.Now()
+		log.Printf("Worker %d runs job %d (%s)", worker, job.JobID, job.Kind)
+		if job.InsightID != nil {
+			progress.Clear(*job.InsightID)
+		}
+		publish(job, progress.Event{Type: "job_" + StateRunning, Step: job.Kind})
+
+		jobCtx, cancel := context.WithTimeout(ctx, timeout)
+		jobErr := run(jobCtx, job)
+		cancel()
+		if ctx.Err() != nil {
+			// Shutting down, another process or the next start runs the job again
+			log.Printf("Job %d interrupted by shutdown", job.JobID)
+			if err := release(job.JobID); err != nil {
+				log.Println(err)
+			}
+			return
+		}
+		if err := finish(job.JobID, jobErr); err != nil {
+			log.Println(err)
+		}
+
+		if jobErr != nil {
+			log.Printf("Job %d failed in %s: %v", job.JobID, time.Since(now), jobErr)
+			publish(job, progress.Event{Type: "job_" + StateFailed, Step: job.Kind, Error: jobErr.Error()})
+		} else {
+			log.Printf("Job %d succeeded in %s", job.JobID, time.Since(now))
+			publish(job, progress.Event{Type: "job_" + StateSucceeded, Step: job.Kind})
+		}
+	}
+}
+
+// publish sends a progress event of an insight job. Document jobs store their progress in the document.
+func publish(job dbmodel.Job, event progress.Event) {
+	if job.InsightID != nil {
+		progress.Publish(*job.InsightID, event)
+	}
+}
+
+// run executes a job, recovering from panics so a single job can't stop a worker
+func run(ctx context.Context, job dbmodel.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+
+	if job.Kind == KindExtractPDF {
+		if job.DocumentID == nil {
+			return fmt.Errorf("job %d has no document", job.JobID)
+		}
+		return service.ExtractPDF(ctx, *job.DocumentID)
+	}
+	if job.InsightID == nil {
+		return fmt.Errorf("job %d has no insight", job.JobID)
+	}
+	if job.UserID == nil {
+		return fmt.Errorf("job %d has no user", job.JobID)
+	}
+
+	userID, insightID := *job.UserID, *job.InsightID
+	switch job.Kind {
+	case KindProcessInsight:
+		return service.ProcessInsight(ctx, userID, insightID)
+	case KindGenerateOptions:
+		_, err = service.GenerateOptions(ctx, userID, insightID)
+	case KindGenerateCode:
+		_, err = service.GenerateCode(ctx, userID, insightID)
+	case KindGenerateChart:
+		_, err = service.GenerateChart(ctx, userID, insightID)
+	default:
+		err = fmt.Errorf("unknown job kind %s", job.Kind)
+	}
+	return err
+}
+
+// MaxAttempts returns how often a job is started before it fails as stale, configured by JOB_MAX_ATTEMPTS, 3 by default
+func MaxAttempts() int {
+	return max(util.EnvInt("JOB_MAX_ATTEMPTS", 3), 1)
+}
+
+// Timeouts returns the timeout of jobs, configured by JOB_TIMEOUT_MINUTES, and the age after which running jobs
+// are requeued as stale, configured by JOB_STALE_MINUTES. By default jobs get the time processing an insight takes
+// with all chart repair rounds. Timeouts that would cancel the chart repair or requeue running jobs are an error.
+func Timeouts() (time.Duration, time.Duration, error) {
+	repairRounds := service.RepairRounds()
+	timeout := ops.ProcessingTimeout(repairRounds)
+	if minutes := util.EnvInt("JOB_TIMEOUT_MINUTES", 0); minutes > 0 {
+		timeout = time.Duration(minutes) * time.Minute
+	}
+	if minimum := ops.RepairTimeout(repairRounds); timeout < minimum {
+		return 0, 0, fmt.Errorf("JOB_TIMEOUT_MINUTES must be at least %.0f for %d CODE_REPAIR_ROUNDS", math.Ceil(minimum.Minutes()), repairRounds)
+	}
+
+	staleAfter := timeout + 5*time.Minute
+	if minutes := util.EnvInt("JOB_STALE_MINUTES", 0); minutes > 0 {
+		staleAfter = time.Duration(minutes) * time.Minute
+	}
+	if staleAfter <= timeout {
+		return 0, 0, fmt.Errorf("JOB_STALE_MINUTES must be longer than the job timeout of %s", timeout)
+	}
+	return timeout, staleAfter, nil
+}
@@ -0,0 +1,183 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	anthropicAPIURL    = "https://api.anthropic.com/v1/messages"
+	anthropicVersion   = "2023-06-01"
+	anthropicMaxTokens = 4096
+)
+
+// AnthropicProvider talks to the Anthropic Messages API
+type AnthropicProvider struct {
+	apiKey string
+	model  string
+	client *http.Client
+}
+
+func NewAnthropicProvider(apiKey string, model string) *AnthropicProvider {
+	return &AnthropicProvider{apiKey: apiKey, model: model, client: httpClient}
+}
+
+type anthropicRequest struct {
+	Model     string             `json:"model"`
+	MaxTokens int                `json:"max_tokens"`
+	System    string             `json:"system,omitempty"`
+	Messages  []anthropicMessage `json:"messages"`
+}
+
+type anthropicMessage struct {
+	Role    string             `json:"role"`
+	Content []anthropicContent `json:"content"`
+}
+
+type anthropicContent struct {
+	Type   string           `json:"type"`
+	Text   string           `json:"text,omitempty"`
+	Source *anthropicSource `json:"source,omitempty"`
+}
+
+type anthropicSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type anthropicResponse struct {
+	Model   string             `json:"model"`
+	Content []anthropicContent `json:"content"`
+	Usage   struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *AnthropicProvider) Name() string {
+	return "anthropic"
+}
+
+func (p *AnthropicProvider) Model() string {
+	return p.model
+}
+
+func (p *AnthropicProvider) Complete(ctx context.Context, req Request) (Response, error) {
+	body, err := json.Marshal(p.createRequest(req))
+	if err != nil {
+		return Response{}, fmt.Errorf("error encoding request: %v", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", anthropicAPIURL, bytes.NewReader(body))
+	if err != nil {
+		return Response{}, fmt.Errorf("error creating HTTP request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", p.apiKey)
+	httpReq.Header.Set("anthropic-version", anthropicVersion)
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return Response{}, fmt.Errorf("error making request to Anthropic API: %v", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Println("Failed to close response body", err)
+		}
+	}(resp.Body)
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Response{}, fmt.Errorf("error reading response body: %v", err)
+	}
+
+	if rejectedStatus(resp.StatusCode) {
+		return Response{}, fmt.Errorf("%w by the Anthropic API: %s", ErrRejected, respBody)
+	}
+	var anthropicResp anthropicResponse
+	if err := json.Unmarshal(respBody, &anthropicResp); err != nil {
+		return Response{}, fmt.Errorf("error decoding JSON response: %v", err)
+	}
+	if anthropicResp.Error != nil {
+		return Response{}, fmt.Errorf("error from the Anthropic API: %s: %s", anthropicResp.Error.Type, anthropicResp.Error.Message)
+	}
+	if resp.StatusCode > 399 {
+		return Response{}, fmt.Errorf("error from the Anthropic API: %s", respBody)
+	}
+
+	var content strings.Builder
+	for _, part := range anthropicResp.Content {
+		if part.Type == "text" {
+			content.WriteString(part.Text)
+		}
+	}
+
+	return Response{
+		Content: content.String(),
+		Model:   anthropicResp.Model,
+		Usage: Usage{
+			PromptTokens:     anthropicResp.Usage.InputTokens,
+			CompletionTokens: anthropicResp.Usage.OutputTokens,
+		},
+	}, nil
+}
+
+// createRequest moves system messages into the system prompt, the Messages API has no system role
+func (p *AnthropicProvider) createRequest(req Request) anthropicRequest {
+	model := req.Model
+	if model == "" {
+		model = p.model
+	}
+
+	var system []string
+	var messages []anthropicMessage
+	for _, message := range req.Messages {
+		if message.Role == RoleSystem {
+			system = append(system, message.Content)
+			continue
+		}
+
+		var content []anthropicContent
+		for _, image := range message.Images {
+			content = append(content, anthropicContent{
+				Type: "image",
+				Source: &anthropicSource{
+					Type:      "base64",
+					MediaType: image.MimeType,
+					Data:      base64.StdEncoding.EncodeToString(image.Data),
+				},
+			})
+		}
+		content = append(content, anthropicContent{Type: "text", Text: message.Content})
+		messages = append(messages, anthropicMessage{Role: message.Role, Content: content})
+	}
+
+	if req.JSON || req.Schema != nil {
+		system = append(system, "Respond only with a single valid JSON object, without any text before or after it.")
+	}
+	if req.Schema != nil {
+		if schema, err := json.Marshal(req.Schema); err == nil {
+			system = append(system, fmt.Sprintf("The JSON object must conform to this JSON schema:\n%s", schema))
+		}
+	}
+
+	return anthropicRequest{
+		Model:     model,
+		MaxTokens: anthropicMaxTokens,
+		System:    strings.Join(system, "\n\n"),
+		Messages:  messages,
+	}
+}
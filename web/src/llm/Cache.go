@@ -0,0 +1,126 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"web/src/db"
+)
+
+// Cache stores validated LLM responses
+type Cache interface {
+	Get(key string) (string, bool, error)
+	Set(key string, operation string, content string, ttl time.Duration) error
+}
+
+var cache Cache
+
+// SetCache enables caching of responses to requests with a CacheKey, nil disables it
+func SetCache(c Cache) {
+	cache = c
+}
+
+type bypassCacheKey struct{}
+
+// WithoutCache returns a context whose requests skip the cache lookup, fresh responses still refresh the cache
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassCacheKey{}, true)
+}
+
+func cacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(bypassCacheKey{}).(bool)
+	return bypass
+}
+
+// cacheKey identifies the response to a request by the provider, model, prompt version and input of the request.
+// It returns an empty key for requests that must not be cached.
+func cacheKey(provider Provider, req Request) string {
+	if cache == nil || req.CacheKey == "" {
+		return ""
+	}
+
+	model := req.Model
+	if model == "" {
+		model = provider.Model()
+	}
+
+	hash := sha256.Sum256([]byte(strings.Join([]string{
+		provider.Name(),
+		model,
+		req.Operation,
+		req.PromptVersion,
+		req.SchemaName,
+		req.CacheKey,
+	}, "\x00")))
+	return hex.EncodeToString(hash[:])
+}
+
+// cacheTTL returns how long responses of the operation are cached, configured by
+// LLM_<OPERATION>_CACHE_TTL_HOURS or LLM_CACHE_TTL_HOURS, a week by default
+func cacheTTL(operation string) time.Duration {
+	hours := operationEnv(operation, "CACHE_TTL_HOURS", "168")
+	ttl, err := strconv.Atoi(hours)
+	if err != nil {
+		log.Printf("Invalid cache TTL %q for operation %s, using a week", hours, operation)
+		ttl = 168
+	}
+	return time.Duration(ttl) * time.Hour
+}
+
+// PostgresCache keeps cached responses in the llm_cache table
+type PostgresCache struct{}
+
+func NewPostgresCache() *PostgresCache {
+	return &PostgresCache{}
+}
+
+func (c *PostgresCache) Get(key string) (string, bool, error) {
+	query := `
+		SELECT content FROM llm_cache
+		WHERE cache_key = $1 AND expires_at > $2;
+	`
+
+	var content string
+	err := db.DB().QueryRow(query, key, time.Now()).Scan(&content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read llm_cache: %w", err)
+	}
+	return content, true, nil
+}
+
+func (c *PostgresCache) Set(key string, operation string, content string, ttl time.Duration) error {
+	query := `
+		INSERT INTO llm_cache (cache_key, operation, content, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (cache_key) DO UPDATE SET
+			content = EXCLUDED.content,
+			created_at = EXCLUDED.created_at,
+			expires_at = EXCLUDED.expires_at;
+	`
+
+	now := time.Now()
+	_, err := db.DB().Exec(query, key, operation, content, now, now.Add(ttl))
+	if err != nil {
+		return fmt.Errorf("failed to write llm_cache: %w", err)
+	}
+	return nil
+}
+
+// PurgeExpired deletes expired responses
+func (c *PostgresCache) PurgeExpired() error {
+	_, err := db.DB().Exec(`DELETE FROM llm_cache WHERE expires_at <= $1;`, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to purge llm_cache: %w", err)
+	}
+	return nil
+}
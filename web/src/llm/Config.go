@@ -0,0 +1,104 @@
+package llm
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"web/src/util"
+)
+
+// Operations with a separately configurable provider and model
+const (
+	OperationOptions = "options"
+	OperationCode    = "code"
+	OperationRepair  = "repair"
+	OperationImage   = "image"
+)
+
+var defaultModels = map[string]string{
+	"openai":    "gpt-4o-mini",
+	"anthropic": "claude-3-5-sonnet-latest",
+	"local":     "llama3.1",
+}
+
+var (
+	overridesMutex sync.RWMutex
+	overrides      = make(map[string]Provider)
+)
+
+// SetProvider makes all requests of the operation use the given provider instead of the configured one.
+// Passing nil restores the configured provider.
+func SetProvider(operation string, provider Provider) {
+	overridesMutex.Lock()
+	defer overridesMutex.Unlock()
+	if provider == nil {
+		delete(overrides, operation)
+		return
+	}
+	overrides[operation] = provider
+}
+
+// ProviderFor returns the provider for an operation.
+// LLM_<OPERATION>_PROVIDER and LLM_<OPERATION>_MODEL take precedence over LLM_PROVIDER and LLM_MODEL,
+// e.g. LLM_CODE_PROVIDER=anthropic uses Anthropic for code generation only.
+// The provider "replay" answers from the fixtures in LLM_FIXTURES_DIR without network access,
+// "record" stores the responses of LLM_RECORD_PROVIDER there.
+func ProviderFor(operation string) Provider {
+	overridesMutex.RLock()
+	provider, ok := overrides[operation]
+	overridesMutex.RUnlock()
+	if ok {
+		return provider
+	}
+
+	name := operationEnv(operation, "PROVIDER", "openai")
+	model := operationEnv(operation, "MODEL", defaultModels[name])
+
+	return newProvider(operation, name, model)
+}
+
+func newProvider(operation string, name string, model string) Provider {
+	switch name {
+	case "replay":
+		return NewReplayProvider(fixturesDir())
+	case "record":
+		backend := operationEnv(operation, "RECORD_PROVIDER", "openai")
+		if backend == "record" || backend == "replay" {
+			backend = "openai"
+		}
+		backendModel := operationEnv(operation, "MODEL", defaultModels[backend])
+		return NewRecordingProvider(newProvider(operation, backend, backendModel), fixturesDir())
+	case "anthropic":
+		return NewAnthropicProvider(util.Env("ANTHROPIC_API_KEY"), model)
+	case "local":
+		baseURL := util.Env("LOCAL_LLM_URL")
+		if baseURL == "" {
+			baseURL = "http://localhost:11434/v1"
+		}
+		return NewLocalProvider(baseURL, model)
+	case "openai":
+		return NewOpenAIProvider(util.Env("OPENAI_API_KEY"), util.Env("OPENAI_BASE_URL"), model)
+	default:
+		log.Printf("Unknown LLM provider %q for operation %s, using openai", name, operation)
+		return NewOpenAIProvider(util.Env("OPENAI_API_KEY"), util.Env("OPENAI_BASE_URL"), defaultModels["openai"])
+	}
+}
+
+// fixturesDir is where the replay and record providers keep their fixtures
+func fixturesDir() string {
+	if dir := util.Env("LLM_FIXTURES_DIR"); dir != "" {
+		return dir
+	}
+	return "testdata/llm"
+}
+
+// operationEnv reads LLM_<OPERATION>_<KEY>, falling back to LLM_<KEY> and then to def
+func operationEnv(operation string, key string, def string) string {
+	if value := util.Env("LLM_" + strings.ToUpper(operation) + "_" + key); value != "" {
+		return value
+	}
+	if value := util.Env("LLM_" + key); value != "" {
+		return value
+	}
+	return def
+}
@@ -0,0 +1,116 @@
+package llm
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"log"
+)
+
+// OpenAIProvider talks to the OpenAI API or to any server implementing it, like Ollama or llama.cpp
+type OpenAIProvider struct {
+	name   string
+	client *openai.Client
+	model  string
+}
+
+// NewOpenAIProvider creates a provider for the OpenAI API, an empty baseURL uses the official endpoint
+func NewOpenAIProvider(apiKey string, baseURL string, model string) *OpenAIProvider {
+	config := openai.DefaultConfig(apiKey)
+	if baseURL != "" {
+		config.BaseURL = baseURL
+	}
+	config.HTTPClient = httpClient
+	return &OpenAIProvider{name: "openai", client: openai.NewClientWithConfig(config), model: model}
+}
+
+// NewLocalProvider creates a provider for a local OpenAI compatible server, e.g. http://localhost:11434/v1 for Ollama
+func NewLocalProvider(baseURL string, model string) *OpenAIProvider {
+	provider := NewOpenAIProvider("local", baseURL, model)
+	provider.name = "local"
+	return provider
+}
+
+func (p *OpenAIProvider) Name() string {
+	return p.name
+}
+
+func (p *OpenAIProvider) Model() string {
+	return p.model
+}
+
+func (p *OpenAIProvider) Complete(ctx context.Context, req Request) (Response, error) {
+	resp, err := p.client.CreateChatCompletion(ctx, p.createRequest(req))
+	var apiErr *openai.APIError
+	var requestErr *openai.RequestError
+	if errors.As(err, &apiErr) && rejectedStatus(apiErr.HTTPStatusCode) ||
+		errors.As(err, &requestErr) && rejectedStatus(requestErr.HTTPStatusCode) {
+		return Response{}, fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	if err != nil {
+		return Response{}, err
+	}
+	if len(resp.Choices) == 0 {
+		return Response{}, errors.New("no choices in response")
+	}
+
+	return Response{
+		Content: resp.Choices[0].Message.Content,
+		Model:   resp.Model,
+		Usage: Usage{
+			PromptTokens:     resp.Usage.PromptTokens,
+			CompletionTokens: resp.Usage.CompletionTokens,
+		},
+	}, nil
+}
+
+func (p *OpenAIProvider) createRequest(req Request) openai.ChatCompletionRequest {
+	model := req.Model
+	if model == "" {
+		model = p.model
+	}
+
+	messages := make([]openai.ChatCompletionMessage, len(req.Messages))
+	for i, message := range req.Messages {
+		messages[i] = openai.ChatCompletionMessage{Role: message.Role}
+		if len(message.Images) == 0 {
+			messages[i].Content = message.Content
+			continue
+		}
+
+		parts := []openai.ChatMessagePart{{Type: openai.ChatMessagePartTypeText, Text: message.Content}}
+		for _, image := range message.Images {
+			parts = append(parts, openai.ChatMessagePart{
+				Type: openai.ChatMessagePartTypeImageURL,
+				ImageURL: &openai.ChatMessageImageURL{
+					URL:    fmt.Sprintf("data:%s;base64,%s", image.MimeType, base64.StdEncoding.EncodeToString(image.Data)),
+					Detail: openai.ImageURLDetail(image.Detail),
+				},
+			})
+		}
+		messages[i].MultiContent = parts
+	}
+
+	request := openai.ChatCompletionRequest{Model: model, Messages: messages}
+	if req.Schema != nil {
+		schema, err := json.Marshal(req.Schema)
+		if err == nil {
+			request.ResponseFormat = &openai.ChatCompletionResponseFormat{
+				Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+				JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+					Name:   req.SchemaName,
+					Schema: json.RawMessage(schema),
+				},
+			}
+			return request
+		}
+		log.Println("Failed to encode response schema, falling back to JSON mode:", err)
+	}
+	if req.JSON {
+		request.ResponseFormat = &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject}
+	}
+	return request
+}
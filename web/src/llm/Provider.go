@@ -0,0 +1,78 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// httpClient bounds requests to the LLM APIs even when the caller sets no deadline
+var httpClient = &http.Client{Timeout: 5 * time.Minute}
+
+// ErrRejected marks requests the API rejected in a way retrying doesn't change, e.g. an invalid API key
+var ErrRejected = errors.New("request rejected")
+
+// rejectedStatus reports whether an HTTP status rejects a request for good.
+// Timeouts and rate limits are client errors that go away by retrying.
+func rejectedStatus(status int) bool {
+	return status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests
+}
+
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Message is a chat message, optionally carrying images for vision models
+type Message struct {
+	Role    string
+	Content string
+	Images  []Image
+}
+
+// Image is an image attached to a message
+type Image struct {
+	MimeType string
+	Data     []byte
+	// Detail is a hint on the resolution the model should look at the image, "low" or "high"
+	Detail string
+}
+
+// Request is a provider independent chat completion request
+type Request struct {
+	// Operation names the operation issuing the request, e.g. OperationCode
+	Operation string
+	// Model overrides the default model of the provider
+	Model    string
+	Messages []Message
+	// JSON asks the model to respond with a JSON object
+	JSON bool
+	// Schema constrains the JSON response where the provider supports it, SchemaName identifies it
+	Schema     *Schema
+	SchemaName string
+	// CacheKey identifies the input of the request, e.g. a fingerprint of the analyzed data.
+	// Together with the PromptVersion it allows to answer repeated requests from the cache, empty disables caching.
+	CacheKey      string
+	PromptVersion string
+}
+
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+type Response struct {
+	Content string
+	Model   string
+	Usage   Usage
+}
+
+// Provider is a backend for chat completions
+type Provider interface {
+	Name() string
+	// Model returns the model used for requests that don't set one
+	Model() string
+	Complete(ctx context.Context, req Request) (Response, error)
+}
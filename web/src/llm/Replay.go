@@ -0,0 +1,123 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrNoFixture is returned by the ReplayProvider when no response was recorded for a request
+var ErrNoFixture = errors.New("no fixture recorded for request")
+
+// fixture is the file format of a recorded response
+type fixture struct {
+	Model            string `json:"model"`
+	Content          string `json:"content"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+}
+
+// ReplayProvider answers requests with responses recorded by a RecordingProvider.
+// Fixtures are stored in dir as <request hash>.json, see RequestHash.
+type ReplayProvider struct {
+	dir string
+}
+
+func NewReplayProvider(dir string) *ReplayProvider {
+	return &ReplayProvider{dir}
+}
+
+func (p *ReplayProvider) Name() string {
+	return "replay"
+}
+
+func (p *ReplayProvider) Model() string {
+	return "replay"
+}
+
+func (p *ReplayProvider) Complete(ctx context.Context, req Request) (Response, error) {
+	if err := ctx.Err(); err != nil {
+		return Response{}, err
+	}
+
+	hash := RequestHash(req)
+	data, err := os.ReadFile(fixturePath(p.dir, hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return Response{}, fmt.Errorf("%w: %s", ErrNoFixture, hash)
+	}
+	if err != nil {
+		return Response{}, fmt.Errorf("failed to read fixture %s: %w", hash, err)
+	}
+
+	var recorded fixture
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		return Response{}, fmt.Errorf("failed to decode fixture %s: %w", hash, err)
+	}
+
+	return Response{
+		Content: recorded.Content,
+		Model:   recorded.Model,
+		Usage:   Usage{PromptTokens: recorded.PromptTokens, CompletionTokens: recorded.CompletionTokens},
+	}, nil
+}
+
+// RecordingProvider forwards requests to another provider and stores its responses as fixtures for the ReplayProvider
+type RecordingProvider struct {
+	provider Provider
+	dir      string
+}
+
+func NewRecordingProvider(provider Provider, dir string) *RecordingProvider {
+	return &RecordingProvider{provider, dir}
+}
+
+func (p *RecordingProvider) Name() string {
+	return "record:" + p.provider.Name()
+}
+
+func (p *RecordingProvider) Model() string {
+	return p.provider.Model()
+}
+
+func (p *RecordingProvider) Complete(ctx context.Context, req Request) (Response, error) {
+	resp, err := p.provider.Complete(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+
+	data, err := json.MarshalIndent(fixture{
+		Model:            resp.Model,
+		Content:          resp.Content,
+		PromptTokens:     resp.Usage.PromptTokens,
+		CompletionTokens: resp.Usage.CompletionTokens,
+	}, "", "  ")
+	if err != nil {
+		return resp, fmt.Errorf("failed to encode fixture: %w", err)
+	}
+
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		return resp, fmt.Errorf("failed to create fixture directory: %w", err)
+	}
+	if err := os.WriteFile(fixturePath(p.dir, RequestHash(req)), data, 0644); err != nil {
+		return resp, fmt.Errorf("failed to write fixture: %w", err)
+	}
+
+	return resp, nil
+}
+
+// RequestHash returns a stable hash of everything in the request that influences the response
+func RequestHash(req Request) string {
+	hash := sha256.New()
+	// Errors can't occur when encoding into a hash
+	_ = json.NewEncoder(hash).Encode(req)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func fixturePath(dir string, hash string) string {
+	return filepath.Join(dir, hash+".json")
+}
@@ -0,0 +1,47 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestReplayProviderAnswersRecordedRequests(t *testing.T) {
+	dir := t.TempDir()
+	req := testRequest("data")
+	recorder := NewRecordingProvider(NewScriptedProvider(Reply(`{"status": "ok", "answer": "42"}`)), dir)
+
+	var recorded testAnswer
+	if err := SendJSON(context.Background(), recorder, req, &recorded); err != nil {
+		t.Fatalf("recording failed: %v", err)
+	}
+
+	var replayed testAnswer
+	if err := SendJSON(context.Background(), NewReplayProvider(dir), req, &replayed); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if replayed != recorded {
+		t.Errorf("replayed answer = %+v, want %+v", replayed, recorded)
+	}
+}
+
+func TestReplayProviderWithoutFixture(t *testing.T) {
+	_, err := NewReplayProvider(t.TempDir()).Complete(context.Background(), testRequest("data"))
+	if !errors.Is(err, ErrNoFixture) {
+		t.Errorf("err = %v, want %v", err, ErrNoFixture)
+	}
+}
+
+func TestProviderForUsesOverride(t *testing.T) {
+	scripted := NewScriptedProvider()
+	SetProvider(OperationCode, scripted)
+	if ProviderFor(OperationCode) != Provider(scripted) {
+		t.Error("the override isn't used")
+	}
+
+	SetProvider(OperationCode, nil)
+	t.Setenv("LLM_CODE_PROVIDER", "replay")
+	if _, ok := ProviderFor(OperationCode).(*ReplayProvider); !ok {
+		t.Error("removing the override doesn't restore the configured provider")
+	}
+}
@@ -0,0 +1,88 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrScriptExhausted is returned by the ScriptedProvider when it receives more requests than it has steps
+var ErrScriptExhausted = errors.New("scripted provider has no more steps")
+
+// Step is a single scripted reaction to a request
+type Step struct {
+	Content string
+	Err     error
+	// Hang blocks until the request context is done, simulating a timeout
+	Hang bool
+}
+
+// Reply returns a step responding with content
+func Reply(content string) Step {
+	return Step{Content: content}
+}
+
+// Fail returns a step failing with err
+func Fail(err error) Step {
+	return Step{Err: err}
+}
+
+// Hang returns a step that never responds
+func Hang() Step {
+	return Step{Hang: true}
+}
+
+// ScriptedProvider answers requests with a fixed sequence of steps, e.g. a malformed response followed by a valid one.
+// It records the requests it receives.
+type ScriptedProvider struct {
+	mutex    sync.Mutex
+	steps    []Step
+	requests []Request
+}
+
+func NewScriptedProvider(steps ...Step) *ScriptedProvider {
+	return &ScriptedProvider{steps: steps}
+}
+
+func (p *ScriptedProvider) Name() string {
+	return "scripted"
+}
+
+func (p *ScriptedProvider) Model() string {
+	return "scripted"
+}
+
+func (p *ScriptedProvider) Complete(ctx context.Context, req Request) (Response, error) {
+	p.mutex.Lock()
+	p.requests = append(p.requests, req)
+	if len(p.steps) == 0 {
+		p.mutex.Unlock()
+		return Response{}, ErrScriptExhausted
+	}
+	step := p.steps[0]
+	p.steps = p.steps[1:]
+	p.mutex.Unlock()
+
+	if step.Hang {
+		<-ctx.Done()
+		return Response{}, ctx.Err()
+	}
+	if step.Err != nil {
+		return Response{}, step.Err
+	}
+	return Response{Content: step.Content, Model: "scripted"}, nil
+}
+
+// Requests returns the requests received so far
+func (p *ScriptedProvider) Requests() []Request {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]Request(nil), p.requests...)
+}
+
+// Remaining returns the number of steps not consumed yet
+func (p *ScriptedProvider) Remaining() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.steps)
+}
@@ -0,0 +1,160 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+
+var maxRetries = 5
+
+// retryBackoff is the wait before the first retry, it doubles with every further retry
+var retryBackoff = time.Second
+
+// SetRetryBackoff changes the wait before the first retry, e.g. to run offline tests without delays
+func SetRetryBackoff(backoff time.Duration) {
+	retryBackoff = backoff
+}
+
+// SendJSON sends the request to the provider and decodes the JSON response into v.
+// The response must conform to the schema derived from v. When it doesn't, the request is retried
+// with the validation error, so the model can correct its answer. It gives up after maxRetries
+// attempts, as soon as ctx is done or when the API rejects the request with ErrRejected.
+// The error then wraps the last error. Valid responses to requests with a CacheKey are cached.
+func SendJSON(ctx context.Context, provider Provider, req Request, v interface{}) error {
+	schema := SchemaFor(v)
+	req.JSON = true
+	req.Schema = schema
+	req.SchemaName = SchemaName(v)
+	messages := req.Messages
+
+	usage := trackUsage(ctx, provider, req)
+
+	key := cacheKey(provider, req)
+	if key != "" && !cacheBypassed(ctx) {
+		content, found, err := cache.Get(key)
+		if err != nil {
+			log.Println("Failed to read cached response:", err)
+		}
+		// Responses cached for an older schema are ignored
+		if found && schema.Validate([]byte(content)) == nil {
+			log.Printf("Using cached %s response", req.Operation)
+			usage.finish(0, true, true)
+			return json.Unmarshal([]byte(content), v)
+		}
+	}
+
+	var lastErr error
+	attempts, succeeded := 0, false
+	defer func() {
+		usage.finish(attempts, false, succeeded)
+	}()
+
+	for i := 0; i < maxRetries; i++ {
+		attempts++
+		resp, err := provider.Complete(ctx, req)
+		if err == nil {
+			usage.add(resp)
+			content := extractJSON(resp.Content)
+			err = schema.Validate([]byte(content))
+			if err == nil {
+				if key != "" {
+					if err := cache.Set(key, req.Operation, content, cacheTTL(req.Operation)); err != nil {
+						log.Println("Failed to cache response:", err)
+					}
+				}
+				succeeded = true
+				return json.Unmarshal([]byte(content), v)
+			}
+			// The response is invalid, so tell the model what's wrong and retry right away
+			log.Printf("Received invalid response from %s: %v. Retrying... (Attempt %d of %d)", provider.Name(), err, i+1, maxRetries)
+			req.Messages = withCorrection(messages, resp.Content, err)
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+
+		lastErr = err
+		if errors.Is(err, ErrRejected) {
+			log.Printf("Request to %s rejected: %v", provider.Name(), err)
+			break
+		}
+
+		// Request failed for another reason, log and retry
+		log.Printf("Request to %s failed: %v. Retrying in %s... (Attempt %d of %d)", provider.Name(), err, backoff(i), i+1, maxRetries)
+
+		select {
+		case <-ctx.Done():
+			log.Println("Request cancelled:", ctx.Err())
+			return fmt.Errorf("request cancelled after attempt %d: %w, last error: %w", attempts, ctx.Err(), lastErr)
+		case <-time.After(backoff(i)):
+		}
+	}
+
+	return fmt.Errorf("no valid response, gave up after attempt %d of %d: %w", attempts, maxRetries, lastErr)
+}
+
+// withCorrection appends the invalid response and the validation error to the original messages
+func withCorrection(messages []Message, response string, err error) []Message {
+	corrected := append([]Message(nil), messages...)
+	return append(corrected,
+		Message{Role: RoleAssistant, Content: response},
+		Message{
+			Role:    RoleUser,
+			Content: fmt.Sprintf("Your response is invalid: %v. Respond again with the complete, corrected JSON object.", err),
+		})
+}
+
+func backoff(attempt int) time.Duration {
+	return time.Duration(math.Pow(2, float64(attempt))) * retryBackoff
+}
+
+// extractJSON returns the JSON object in a response, ignoring surrounding text like markdown code fences.
+// Literal line breaks and tabs inside strings, which models tend to put into code, are escaped.
+func extractJSON(s string) string {
+	startPos := strings.IndexRune(s, '{')
+	endPos := strings.LastIndex(s, "}")
+
+	if startPos == -1 || endPos == -1 || endPos < startPos {
+		return ""
+	}
+	s = s[startPos : endPos+1]
+	if json.Valid([]byte(s)) {
+		return s
+	}
+	return escapeControlCharacters(s)
+}
+
+// escapeControlCharacters escapes raw control characters within the strings of a JSON document
+func escapeControlCharacters(s string) string {
+	var result strings.Builder
+	inString, escaped := false, false
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case inString && r == '\\':
+			escaped = true
+		case r == '"':
+			inString = !inString
+		case inString && r == '\n':
+			result.WriteString(`\n`)
+			continue
+		case inString && r == '\r':
+			result.WriteString(`\r`)
+			continue
+		case inString && r == '\t':
+			result.WriteString(`\t`)
+			continue
+		}
+		result.WriteRune(r)
+	}
+	return result.String()
+}
@@ -0,0 +1,294 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testAnswer struct {
+	Status string `json:"status" enum:"ok,error"`
+	Answer string `json:"answer"`
+}
+
+func TestMain(m *testing.M) {
+	SetRetryBackoff(time.Millisecond)
+	os.Exit(m.Run())
+}
+
+// memoryCache is a Cache for tests that counts lookups and stores
+type memoryCache struct {
+	mutex   sync.Mutex
+	entries map[string]string
+	hits    int
+	misses  int
+	sets    int
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{entries: map[string]string{}}
+}
+
+func (c *memoryCache) Get(key string) (string, bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	content, found := c.entries[key]
+	if found {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	return content, found, nil
+}
+
+func (c *memoryCache) Set(key string, operation string, content string, ttl time.Duration) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[key] = content
+	c.sets++
+	return nil
+}
+
+// useCache enables the cache for the duration of a test
+func useCache(t *testing.T, c Cache) {
+	t.Cleanup(func() { SetCache(nil) })
+	SetCache(c)
+}
+
+func testRequest(cacheKey string) Request {
+	return Request{
+		Operation:     OperationOptions,
+		CacheKey:      cacheKey,
+		PromptVersion: "test@v1",
+		Messages:      []Message{{Role: RoleUser, Content: "Answer the question"}},
+	}
+}
+
+func TestSendJSONRetriesWithValidationError(t *testing.T) {
+	provider := NewScriptedProvider(
+		Reply(`{"status": "ok"}`),
+		Reply(`{"status": "maybe", "answer": "42"}`),
+		Reply("Here you go:\n```json\n{\"status\": \"ok\", \"answer\": \"42\"}\n```"),
+	)
+
+	var answer testAnswer
+	err := SendJSON(context.Background(), provider, testRequest(""), &answer)
+	if err != nil {
+		t.Fatalf("SendJSON failed: %v", err)
+	}
+	if answer.Answer != "42" {
+		t.Errorf("answer = %q, want 42", answer.Answer)
+	}
+
+	requests := provider.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("provider received %d requests, want 3", len(requests))
+	}
+	if !requests[0].JSON || requests[0].Schema == nil || requests[0].SchemaName != "testAnswer" {
+		t.Errorf("first request doesn't ask for the schema: %+v", requests[0])
+	}
+
+	// Each retry carries the invalid response and the validation error
+	corrections := []string{"$.answer is missing", "$.status must be one of ok, error"}
+	for i, correction := range corrections {
+		messages := requests[i+1].Messages
+		if len(messages) != 3 {
+			t.Fatalf("retry %d has %d messages, want 3", i+1, len(messages))
+		}
+		if messages[1].Role != RoleAssistant {
+			t.Errorf("retry %d doesn't repeat the invalid response: %+v", i+1, messages[1])
+		}
+		if !strings.Contains(messages[2].Content, correction) {
+			t.Errorf("retry %d message %q doesn't contain %q", i+1, messages[2].Content, correction)
+		}
+	}
+}
+
+func TestSendJSONGivesUpAfterMaxRetries(t *testing.T) {
+	steps := make([]Step, maxRetries+1)
+	for i := range steps {
+		steps[i] = Reply("not json")
+	}
+	provider := NewScriptedProvider(steps...)
+
+	var answer testAnswer
+	err := SendJSON(context.Background(), provider, testRequest(""), &answer)
+	if err == nil {
+		t.Fatal("SendJSON succeeded with invalid responses")
+	}
+	if got := len(provider.Requests()); got != maxRetries {
+		t.Errorf("provider received %d requests, want %d", got, maxRetries)
+	}
+}
+
+func TestSendJSONRetriesFailedRequests(t *testing.T) {
+	failure := errors.New("connection reset")
+	provider := NewScriptedProvider(Fail(failure), Fail(failure), Reply(`{"status": "ok", "answer": "42"}`))
+
+	var answer testAnswer
+	err := SendJSON(context.Background(), provider, testRequest(""), &answer)
+	if err != nil {
+		t.Fatalf("SendJSON failed: %v", err)
+	}
+	if provider.Remaining() != 0 {
+		t.Errorf("%d steps weren't used", provider.Remaining())
+	}
+	// Failed requests are retried unchanged
+	if got := len(provider.Requests()[2].Messages); got != 1 {
+		t.Errorf("retry has %d messages, want 1", got)
+	}
+}
+
+func TestSendJSONStopsOnRejectedRequests(t *testing.T) {
+	provider := NewScriptedProvider(Fail(fmt.Errorf("%w: invalid API key", ErrRejected)), Reply(`{"status": "ok", "answer": "42"}`))
+
+	var answer testAnswer
+	err := SendJSON(context.Background(), provider, testRequest(""), &answer)
+	if !errors.Is(err, ErrRejected) {
+		t.Fatalf("err = %v, want %v", err, ErrRejected)
+	}
+	if !strings.Contains(err.Error(), "invalid API key") || !strings.Contains(err.Error(), "attempt 1 of") {
+		t.Errorf("err = %v, want the rejection after the first attempt", err)
+	}
+	if provider.Remaining() != 1 {
+		t.Errorf("rejected request was retried")
+	}
+}
+
+func TestSendJSONStopsWhenContextIsDone(t *testing.T) {
+	provider := NewScriptedProvider(Hang(), Reply(`{"status": "ok", "answer": "42"}`))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	var answer testAnswer
+	err := SendJSON(ctx, provider, testRequest(""), &answer)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if provider.Remaining() != 1 {
+		t.Errorf("request was retried after the context was done")
+	}
+}
+
+func TestSendJSONCachesValidResponses(t *testing.T) {
+	cache := newMemoryCache()
+	useCache(t, cache)
+	provider := NewScriptedProvider(Reply(`{"answer": "42"}`), Reply(`{"status": "ok", "answer": "42"}`))
+
+	var first testAnswer
+	if err := SendJSON(context.Background(), provider, testRequest("data"), &first); err != nil {
+		t.Fatalf("first SendJSON failed: %v", err)
+	}
+	if cache.misses != 1 || cache.sets != 1 {
+		t.Fatalf("first call: %d misses and %d sets, want 1 and 1", cache.misses, cache.sets)
+	}
+
+	// The scripted provider has no steps left, so the second call must be answered from the cache
+	var second testAnswer
+	if err := SendJSON(context.Background(), provider, testRequest("data"), &second); err != nil {
+		t.Fatalf("second SendJSON failed: %v", err)
+	}
+	if cache.hits != 1 {
+		t.Errorf("second call: %d hits, want 1", cache.hits)
+	}
+	if second != first {
+		t.Errorf("cached answer = %+v, want %+v", second, first)
+	}
+	if got := len(provider.Requests()); got != 2 {
+		t.Errorf("provider received %d requests, want 2", got)
+	}
+}
+
+func TestSendJSONCacheMisses(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		req  Request
+	}{
+		{"other input", context.Background(), testRequest("other data")},
+		{"other prompt version", context.Background(), Request{
+			Operation:     OperationOptions,
+			CacheKey:      "data",
+			PromptVersion: "test@v2",
+			Messages:      testRequest("data").Messages,
+		}},
+		{"bypassed", WithoutCache(context.Background()), testRequest("data")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache := newMemoryCache()
+			useCache(t, cache)
+			provider := NewScriptedProvider(Reply(`{"status": "ok", "answer": "42"}`), Reply(`{"status": "ok", "answer": "43"}`))
+
+			var answer testAnswer
+			if err := SendJSON(context.Background(), provider, testRequest("data"), &answer); err != nil {
+				t.Fatalf("first SendJSON failed: %v", err)
+			}
+			if err := SendJSON(test.ctx, provider, test.req, &answer); err != nil {
+				t.Fatalf("second SendJSON failed: %v", err)
+			}
+			if answer.Answer != "43" {
+				t.Errorf("answer = %q, want the fresh answer 43", answer.Answer)
+			}
+			if cache.hits != 0 {
+				t.Errorf("%d cache hits, want 0", cache.hits)
+			}
+		})
+	}
+}
+
+func TestSendJSONIgnoresCachedResponsesOfOlderSchemas(t *testing.T) {
+	cache := newMemoryCache()
+	useCache(t, cache)
+	provider := NewScriptedProvider(Reply(`{"status": "ok", "answer": "42"}`))
+
+	req := testRequest("data")
+	req.SchemaName = "testAnswer"
+	cache.entries[cacheKey(provider, req)] = `{"result": "42"}`
+
+	var answer testAnswer
+	if err := SendJSON(context.Background(), provider, testRequest("data"), &answer); err != nil {
+		t.Fatalf("SendJSON failed: %v", err)
+	}
+	if provider.Remaining() != 0 {
+		t.Error("the outdated cached response was used")
+	}
+	if cache.entries[cacheKey(provider, req)] != `{"status": "ok", "answer": "42"}` {
+		t.Error("the outdated cached response wasn't replaced")
+	}
+}
+
+func TestSendJSONRecordsUsage(t *testing.T) {
+	var records []UsageRecord
+	SetUsageRecorder(func(record UsageRecord) { records = append(records, record) })
+	t.Cleanup(func() { SetUsageRecorder(nil) })
+	useCache(t, newMemoryCache())
+	provider := NewScriptedProvider(Reply(`{}`), Reply(`{"status": "ok", "answer": "42"}`))
+
+	ctx := WithAttribution(context.Background(), Attribution{UserID: 7, InsightID: 11})
+	var answer testAnswer
+	for i := 0; i < 2; i++ {
+		if err := SendJSON(ctx, provider, testRequest("data"), &answer); err != nil {
+			t.Fatalf("SendJSON %d failed: %v", i+1, err)
+		}
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("%d usage records, want 2", len(records))
+	}
+	if records[0].Retries != 1 || records[0].Cached || !records[0].Succeeded {
+		t.Errorf("first record = %+v, want 1 retry, not cached, succeeded", records[0])
+	}
+	if !records[1].Cached {
+		t.Errorf("second record = %+v, want cached", records[1])
+	}
+	if records[0].Attribution.UserID != 7 || records[0].Attribution.InsightID != 11 {
+		t.Errorf("attribution = %+v, want user 7 and insight 11", records[0].Attribution)
+	}
+}
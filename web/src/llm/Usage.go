@@ -0,0 +1,91 @@
+package llm
+
+import (
+	"context"
+	"time"
+)
+
+// Attribution identifies who an LLM call is made for
+type Attribution struct {
+	UserID    int64
+	InsightID int64
+}
+
+type attributionKey struct{}
+
+// WithAttribution returns a context attributing its LLM calls to the user and insight
+func WithAttribution(ctx context.Context, attribution Attribution) context.Context {
+	return context.WithValue(ctx, attributionKey{}, attribution)
+}
+
+// AttributionFrom returns the attribution of the context, if any
+func AttributionFrom(ctx context.Context) (Attribution, bool) {
+	attribution, ok := ctx.Value(attributionKey{}).(Attribution)
+	return attribution, ok
+}
+
+// UsageRecord summarizes a call of SendJSON including all its retries
+type UsageRecord struct {
+	Attribution      Attribution
+	Operation        string
+	Provider         string
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	Latency          time.Duration
+	Retries          int
+	Cached           bool
+	Succeeded        bool
+}
+
+// UsageRecorder stores usage records, it must not block for long as it's called synchronously
+type UsageRecorder func(record UsageRecord)
+
+var usageRecorder UsageRecorder
+
+// SetUsageRecorder registers the recorder receiving a record for every LLM call
+func SetUsageRecorder(recorder UsageRecorder) {
+	usageRecorder = recorder
+}
+
+// usageTracker accumulates the usage of all attempts of a request
+type usageTracker struct {
+	record UsageRecord
+	start  time.Time
+}
+
+func trackUsage(ctx context.Context, provider Provider, req Request) *usageTracker {
+	attribution, _ := AttributionFrom(ctx)
+	model := req.Model
+	if model == "" {
+		model = provider.Model()
+	}
+	return &usageTracker{
+		record: UsageRecord{
+			Attribution: attribution,
+			Operation:   req.Operation,
+			Provider:    provider.Name(),
+			Model:       model,
+		},
+		start: time.Now(),
+	}
+}
+
+func (t *usageTracker) add(resp Response) {
+	t.record.PromptTokens += resp.Usage.PromptTokens
+	t.record.CompletionTokens += resp.Usage.CompletionTokens
+	if resp.Model != "" {
+		t.record.Model = resp.Model
+	}
+}
+
+func (t *usageTracker) finish(attempts int, cached bool, succeeded bool) {
+	if usageRecorder == nil {
+		return
+	}
+	t.record.Latency = time.Since(t.start)
+	t.record.Retries = max(attempts-1, 0)
+	t.record.Cached = cached
+	t.record.Succeeded = succeeded
+	usageRecorder(t.record)
+}
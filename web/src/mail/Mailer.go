@@ -0,0 +1,100 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"web/src/util"
+)
+
+// Message is a plain text email to a single recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+var (
+	overrideMutex sync.RWMutex
+	override      Mailer
+)
+
+// SetMailer makes Send use the given mailer instead of the configured one. Passing nil restores the configured mailer.
+func SetMailer(mailer Mailer) {
+	overrideMutex.Lock()
+	defer overrideMutex.Unlock()
+	override = mailer
+}
+
+// Send delivers a message with the mailer configured by MAIL_PROVIDER. "smtp" sends it through SMTP_HOST,
+// "log" only logs it, login links included, and is accepted for development on a localhost BASE_URL only.
+func Send(ctx context.Context, message Message) error {
+	m, err := mailer()
+	if err != nil {
+		return err
+	}
+	return m.Send(ctx, message)
+}
+
+// CheckProvider returns an error if no mailer is configured, the server doesn't start without one
+func CheckProvider() error {
+	_, err := configuredMailer()
+	return err
+}
+
+func mailer() (Mailer, error) {
+	overrideMutex.RLock()
+	defer overrideMutex.RUnlock()
+	if override != nil {
+		return override, nil
+	}
+	return configuredMailer()
+}
+
+func configuredMailer() (Mailer, error) {
+	switch name := util.Env("MAIL_PROVIDER"); name {
+	case "smtp":
+		if util.Env("SMTP_HOST") == "" || util.Env("MAIL_FROM") == "" {
+			return nil, errors.New("MAIL_PROVIDER smtp requires SMTP_HOST and MAIL_FROM")
+		}
+		return NewSMTPMailer(util.Env("SMTP_HOST"), util.Env("SMTP_PORT"), util.Env("SMTP_USER"), util.Env("SMTP_PASSWORD"), util.Env("MAIL_FROM")), nil
+	case "log":
+		if !development() {
+			return nil, errors.New("MAIL_PROVIDER log writes login links to the log, it's only allowed with a localhost BASE_URL")
+		}
+		return LogMailer{}, nil
+	case "":
+		return nil, errors.New("MAIL_PROVIDER must be smtp, or log for development")
+	default:
+		return nil, fmt.Errorf("unknown MAIL_PROVIDER %q", name)
+	}
+}
+
+// development reports whether the app is served from localhost
+func development() bool {
+	baseURL, err := url.Parse(util.Env("BASE_URL"))
+	if err != nil {
+		return false
+	}
+	switch baseURL.Hostname() {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	return false
+}
+
+// LogMailer logs emails instead of sending them
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, message Message) error {
+	log.Printf("Email to %s: %s\n%s", message.To, message.Subject, message.Body)
+	return nil
+}
@@ -0,0 +1,69 @@
+package mail
+
+import (
+	"context"
+	"testing"
+)
+
+// outbox is a Mailer for tests that keeps the sent messages
+type outbox struct {
+	messages []Message
+}
+
+func (o *outbox) Send(ctx context.Context, message Message) error {
+	o.messages = append(o.messages, message)
+	return nil
+}
+
+func TestSendUsesMailerOverride(t *testing.T) {
+	sent := &outbox{}
+	SetMailer(sent)
+	t.Cleanup(func() { SetMailer(nil) })
+
+	message := Message{To: "ada@example.com", Subject: "Your login link", Body: "https://example.com/login"}
+	if err := Send(context.Background(), message); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if len(sent.messages) != 1 || sent.messages[0] != message {
+		t.Errorf("sent messages = %+v, want %+v", sent.messages, message)
+	}
+
+	SetMailer(nil)
+	t.Setenv("MAIL_PROVIDER", "smtp")
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+	t.Setenv("MAIL_FROM", "noreply@example.com")
+	if m, _ := mailer(); m == nil {
+		t.Error("removing the override doesn't restore the configured mailer")
+	} else if _, ok := m.(*SMTPMailer); !ok {
+		t.Errorf("mailer = %T, want *SMTPMailer", m)
+	}
+}
+
+func TestCheckProvider(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		smtpHost string
+		baseURL  string
+		valid    bool
+	}{
+		{"missing", "", "", "http://localhost:8080", false},
+		{"unknown", "sendmail", "", "http://localhost:8080", false},
+		{"smtp", "smtp", "smtp.example.com", "https://app.example.com", true},
+		{"smtp without host", "smtp", "", "https://app.example.com", false},
+		{"log in development", "log", "", "http://localhost:8080", true},
+		{"log in production", "log", "", "https://app.example.com", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("MAIL_PROVIDER", test.provider)
+			t.Setenv("SMTP_HOST", test.smtpHost)
+			t.Setenv("MAIL_FROM", "noreply@example.com")
+			t.Setenv("BASE_URL", test.baseURL)
+			if err := CheckProvider(); (err == nil) != test.valid {
+				t.Errorf("CheckProvider() = %v, want valid %t", err, test.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPMailer sends emails through an SMTP server, authenticating with PLAIN auth if a user is configured
+type SMTPMailer struct {
+	addr     string
+	host     string
+	user     string
+	password string
+	from     string
+}
+
+// NewSMTPMailer returns a mailer sending through host:port, the port defaults to 587
+func NewSMTPMailer(host string, port string, user string, password string, from string) *SMTPMailer {
+	if port == "" {
+		port = "587"
+	}
+	return &SMTPMailer{addr: net.JoinHostPort(host, port), host: host, user: user, password: password, from: from}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, message Message) error {
+	var auth smtp.Auth
+	if m.user != "" {
+		auth = smtp.PlainAuth("", m.user, m.password, m.host)
+	}
+
+	headers := []string{
+		"From: " + m.from,
+		"To: " + message.To,
+		"Subject: " + message.Subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+	}
+	body := strings.Join(headers, "\r\n") + "\r\n\r\n" + strings.ReplaceAll(message.Body, "\n", "\r\n")
+
+	if err := smtp.SendMail(m.addr, auth, m.from, []string{message.To}, []byte(body)); err != nil {
+		return fmt.Errorf("failed to send email to %s: %w", message.To, err)
+	}
+	return nil
+}
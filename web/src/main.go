@@ -1,25 +1,25 @@
 package main
 
 import (
-	"bytes"
-	"encoding/csv"
-	"fmt"
+	"context"
+	"errors"
 	"github.com/gin-gonic/gin"
-	"github.com/xuri/excelize/v2"
 	"html/template"
-	"io"
-	"io/ioutil"
 	"log"
-	"mime/multipart"
 	"net/http"
-	"path/filepath"
-	"regexp"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 	"web/src/db"
-	"web/src/model"
-	"web/src/ops"
+	"web/src/handler"
+	"web/src/ingest"
+	"web/src/jobs"
+	"web/src/llm"
+	"web/src/mail"
+	"web/src/progress"
 	"web/src/service"
 	"web/src/util"
 )
@@ -27,6 +27,30 @@ import (
 func main() {
 	util.LoadEnvVars()
 	db.Init()
+	if util.Env("LLM_CACHE") != "off" {
+		cache := llm.NewPostgresCache()
+		if err := cache.PurgeExpired(); err != nil {
+			log.Println(err)
+		}
+		llm.SetCache(cache)
+	}
+	llm.SetUsageRecorder(service.RecordUsage)
+	if err := mail.CheckProvider(); err != nil {
+		log.Fatal(err)
+	}
+	service.PurgeExpiredSessions()
+	jobTimeout, staleAfter, err := jobs.Timeouts()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// SIGTERM and interrupts stop the server, the workers and the progress relay gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	// Progress events reach the SSE clients of every process, also those of jobs run by workers of other processes
+	if err := progress.Start(ctx); err != nil {
+		log.Fatal(err)
+	}
+	workersStopped := jobs.StartWorkers(ctx, util.EnvInt("JOB_WORKERS", 4), jobTimeout, staleAfter, jobs.MaxAttempts())
 
 	baseURL := util.Env("BASE_URL")
 	if strings.HasSuffix(baseURL, "/") {
@@ -34,320 +58,194 @@ func main() {
 	}
 
 	r := gin.Default()
+	// Client IPs rate limit login emails, X-Forwarded-For is only trusted from the proxies in TRUSTED_PROXIES
+	if err := r.SetTrustedProxies(strings.Fields(util.Env("TRUSTED_PROXIES"))); err != nil {
+		log.Fatal(err)
+	}
 	r.LoadHTMLGlob("templates/*")
 	r.GET("/", index)
-	//r.POST("/uploadImage", handleImage)
-	r.POST("/uploadFile", handleFile)
+	r.GET("/static/plotly.min.js", handler.PlotlyJS)
+	r.POST("/uploadImage", handler.RequireUser, handleImage)
+	r.POST("/uploadFile", handler.RequireUser, handleFile)
+	r.GET("/insights/:id/status", handler.RequireUser, handler.GetInsightStatus)
+	r.GET("/share/:token", handler.ShowSharedChart)
+	r.GET("/embed/:token", handler.EmbedSharedChart)
+
+	auth := r.Group("/api/auth")
+	auth.POST("/signup", handler.SignUp)
+	auth.POST("/login", handler.Login)
+	auth.POST("/magic-link", handler.SendMagicLink)
+	auth.GET("/magic-link/verify", handler.ShowMagicLink)
+	auth.POST("/magic-link/verify", handler.VerifyMagicLink)
+	auth.POST("/logout", handler.Logout)
+	auth.GET("/me", handler.RequireUser, handler.GetCurrentUser)
+
+	api := r.Group("/api", handler.RequireUser)
+	api.GET("/insights", handler.ListInsights)
+	api.POST("/insights", handler.CreateInsight)
+	api.GET("/insights/:id", handler.GetInsight)
+	api.GET("/insights/:id/status", handler.GetInsightStatus)
+	api.GET("/insights/:id/events", handler.StreamEvents)
+	api.GET("/insights/:id/usage", handler.GetInsightUsage)
+	api.GET("/insights/:id/preview", handler.GetDataPreview)
+	api.GET("/insights/:id/sheets", handler.GetSheets)
+	api.PUT("/insights/:id/workspace", handler.MoveInsight)
+	api.GET("/insights/:id/shares", handler.ListInsightShares)
+	api.PUT("/insights/:id/shares", handler.ShareInsight)
+	api.DELETE("/insights/:id/shares/:user_id", handler.UnshareInsight)
+	api.GET("/insights/:id/links", handler.ListShareLinks)
+	api.POST("/insights/:id/links", handler.CreateShareLink)
+	api.DELETE("/insights/:id/links/:link_id", handler.RevokeShareLink)
+	api.GET("/workspaces", handler.ListWorkspaces)
+	api.POST("/workspaces", handler.CreateWorkspace)
+	api.GET("/workspaces/:workspace_id/members", handler.ListWorkspaceMembers)
+	api.PUT("/workspaces/:workspace_id/members", handler.SetWorkspaceMember)
+	api.DELETE("/workspaces/:workspace_id/members/:user_id", handler.RemoveWorkspaceMember)
+	api.PUT("/insights/:id/sheets", handler.SelectSheets)
+	api.GET("/usage", handler.GetUsage)
+	api.POST("/uploads", handler.CreateUpload)
+	api.GET("/uploads/:upload_id", handler.GetUpload)
+	api.PUT("/uploads/:upload_id/parts/:part", handler.UploadPart)
+	api.POST("/uploads/:upload_id/complete", handler.CompleteUpload)
+	api.DELETE("/uploads/:upload_id", handler.AbortUpload)
+	api.POST("/images", handler.UploadImage)
+	api.GET("/images/:extraction_id", handler.GetImageExtraction)
+	api.PUT("/images/:extraction_id", handler.UpdateImageExtraction)
+	api.POST("/images/:extraction_id/confirm", handler.ConfirmImageExtraction)
+	api.POST("/pdfs", handler.UploadPDF)
+	api.GET("/pdfs/:document_id", handler.GetPDF)
+	api.POST("/insights/:id/jobs", handler.EnqueueJob)
+	api.GET("/insights/:id/options", handler.ListOptions)
+	api.POST("/insights/:id/options", handler.GenerateOptions)
+	api.GET("/insights/:id/analysis", handler.GetAnalysis)
+	api.PUT("/insights/:id/analysis", handler.SelectOption)
+	api.GET("/insights/:id/code", handler.GetCode)
+	api.POST("/insights/:id/code", handler.GenerateCode)
+	api.GET("/insights/:id/code/attempts", handler.ListCodeAttempts)
+	api.GET("/insights/:id/chart", handler.GetChart)
+	api.POST("/insights/:id/chart", handler.GenerateChart)
+
+	admin := r.Group("/api/admin", handler.RequireAdmin)
+	admin.GET("/prompts", handler.ListPrompts)
+	admin.GET("/prompts/:name/versions/:version", handler.GetPrompt)
+	admin.PUT("/prompts/:name/versions/:version", handler.CreatePrompt)
+	admin.PUT("/prompts/:name/active", handler.ActivatePrompt)
+	admin.GET("/users/:user_id/budget", handler.GetUserBudget)
+	admin.PUT("/users/:user_id/budget", handler.SetUserBudget)
+
+	server := &http.Server{Addr: ":8080", Handler: r}
+	go func() {
+		<-ctx.Done()
+		log.Println("Shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Println("Failed to shut down server:", err)
+		}
+	}()
 
-	err := r.Run(":8080")
-	if err != nil {
+	err = server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Failed to start server: %v", err)
 		return
 	}
+	<-workersStopped
 }
 
+// index serves the app. The chart of the insight DEMO_INSIGHT_ID, if set, is shown as a demo.
+// The demo chart is stored beforehand, loading the page doesn't store files or call the LLM.
 func index(c *gin.Context) {
-	// Define the Python code as a string
-	pythonCode := `
-import plotly.express as px
-
-# Melt DataFrame to have subjects in one column and scores in another
-df_melted = df.melt(id_vars=["studentid", "first_name", "last_name", "attendance"],
-                    value_vars=["math", "phonics", "science"],
-                    var_name="subject", value_name="score")
-
-# Create a bar chart showing scores for each subject by student
-fig = px.bar(
-    df_melted,
-    x="first_name",
-    y="score",
-    color="subject",
-    title="Student Scores by Subject",
-    labels={"first_name": "Student", "score": "Score"},
-    template="simple_white"
-)
-
-output = fig
-`
-
-	data, _ := ioutil.ReadFile("/home/maxmc/Downloads/student_data.csv")
-
-	op := ops.NewChartGenerationOp(model.DataFile{
-		Headers:   []string{"studentid", "first_name", "last_name", "attendance", "math", "phonics", "science"},
-		FirstRows: [][]string{{"1", "John", "Doe", "Present", "90", "85", "88"}, {"2", "Jane", "Smith", "Absent", "85", "90", "92"}},
-		Data:      data,
-		Ext:       "csv",
-	})
-
-	result, err := op.Run(pythonCode)
-	if err != nil {
-		log.Println("Failed to generate chart:", err)
-		c.String(http.StatusInternalServerError, "Oops! Something went wrong.")
-		return
+	demoChart := template.JS("null")
+	if demoInsight := util.Env("DEMO_INSIGHT_ID"); demoInsight != "" {
+		insightID, err := strconv.ParseInt(demoInsight, 10, 64)
+		if err != nil {
+			log.Println("Invalid DEMO_INSIGHT_ID:", err)
+		} else if chart, err := service.GetInsightChart(insightID); err != nil {
+			log.Println("Failed to load demo chart:", err)
+		} else {
+			demoChart = template.JS(chart.ChartData)
+		}
 	}
 
 	c.HTML(http.StatusOK, "index.html", gin.H{
-		"PlotlyJSON": template.JS(result.(string)),
+		"PlotlyJSON": demoChart,
 	})
 }
 
-// handleFile handles Excel and CSV file uploads
+// handleFile handles data file uploads in any of the supported formats.
+// The analysis runs in the background, clients poll /insights/:id/status for its progress.
 func handleFile(c *gin.Context) {
 	now := time.Now()
 	log.Println("File upload received")
-	// Get file from the request
-	file, err := c.FormFile("file")
-	if err != nil {
-		c.String(http.StatusBadRequest, "File upload error: %v", err)
+	// Stream the file from the request to storage
+	upload, err := ingest.ReceiveMultipart(c.Request, "file")
+	if errors.Is(err, ingest.ErrFileTooLarge) {
+		c.String(http.StatusRequestEntityTooLarge, "%v", err)
 		return
 	}
-
-	// Process the uploaded file
-	dataFile, err := readFileData(file)
 	if err != nil {
 		c.String(http.StatusInternalServerError, "There is a problem with the file data: %v", err)
 		return
 	}
 
-	insightID, err := service.CreateInsight(1)
-	if err != nil {
-		log.Println("Failed to create insight:", err)
+	userID := handler.CurrentUserID(c)
+	if err := service.CheckBudget(userID); err != nil {
+		c.String(http.StatusPaymentRequired, "%v", err)
 		return
 	}
-	err = service.SaveInsightData(insightID, dataFile)
+
+	insightID, err := service.CreateInsight(userID, nil)
 	if err != nil {
-		log.Println("Failed to save insight data:", err)
+		log.Println("Failed to create insight:", err)
+		c.String(http.StatusInternalServerError, "Failed to create insight")
 		return
 	}
-
-	c.String(http.StatusOK, "File uploaded successfully")
-	return
-
-	//TODO testing options
-	op := ops.DataAnalysisOptionsOp{}
-	options, err := op.Run(dataFile)
+	err = service.SaveUploadedData(insightID, upload)
 	if err != nil {
-		log.Println("Failed to generate analysis options:", err)
+		log.Println("Failed to save insight data:", err)
+		c.String(http.StatusInternalServerError, "Failed to save insight data")
 		return
 	}
 
-	log.Println("option", options)
-
-	elapsed := time.Since(now)
-	log.Printf("%.2f %s File data processed", elapsed.Seconds(), dataFile.Ext)
-	pipeline := ops.NewPipeline(
-		&ops.DataAnalysisOp{},
-		ops.NewChartGenerationOp(dataFile),
-	)
-
-	chart, err := pipeline.Execute(dataFile)
+	jobID, err := jobs.Enqueue(userID, insightID, jobs.KindProcessInsight)
 	if err != nil {
-		log.Println("Pipeline execution failed:", err)
-		c.String(http.StatusInternalServerError, "Failed to process pipeline")
+		log.Println("Failed to enqueue insight processing:", err)
+		c.String(http.StatusInternalServerError, "Failed to process insight")
 		return
 	}
 
-	extractedData, _ := pipeline.GetResult(0)
-	analysisCode, _ := pipeline.GetResult(1)
-
-	c.HTML(http.StatusOK, "index.html", gin.H{
-		"PlotlyJSON": template.JS(chart.(string)),
-		"Data":       extractedData.(string),
-		"Code":       analysisCode.(string),
-	})
+	log.Printf("%.2f %s File data saved for insight %d", time.Since(now).Seconds(), upload.Ext, insightID)
+	c.JSON(http.StatusAccepted, gin.H{"insight_id": insightID, "job_id": jobID})
 }
 
-const maxRows = 100
-const maxFileSize = 10 * 1024 * 1024 // 10MB limit for file size
-
-func readFileData(file *multipart.FileHeader) (model.DataFile, error) {
-	allowedExtensions := map[string]bool{".csv": true, ".xls": true, ".xlsx": true}
-	ext := strings.ToLower(filepath.Ext(file.Filename))
-	if !allowedExtensions[ext] {
-		return model.DataFile{}, fmt.Errorf("invalid file type. Only CSV and Excel files are allowed")
-	}
-
-	// Open the file
-	multipartFile, err := file.Open()
-	if err != nil {
-		return model.DataFile{}, err
-	}
-	defer func() {
-		if closeErr := multipartFile.Close(); closeErr != nil {
-			log.Println("Failed to close file:", closeErr)
-		}
-	}()
-
-	if file.Size > maxFileSize {
-		return model.DataFile{}, fmt.Errorf("file size exceeds the limit of %d bytes", maxFileSize)
-	}
-	fileData := make([]byte, file.Size)
-	_, err = io.ReadFull(multipartFile, fileData)
-	if err != nil {
-		return model.DataFile{}, fmt.Errorf("failed to read file data: %v", err)
-	}
-
-	// Reset the file reader to use in parsing
-	reader := bytes.NewReader(fileData)
-
-	var headers []string
-	var rows [][]string
-	rowCount := 0
-
-	if ext == ".csv" {
-		// Process CSV file
-		reader := csv.NewReader(reader)
-		headers, err = reader.Read()
-		if err != nil {
-			return model.DataFile{}, fmt.Errorf("failed to read CSV headers: %v", err)
-		}
-
-		for {
-			row, err := reader.Read()
-			if err != nil {
-				break // end of file
-			}
-			rows = append(rows, row)
-			rowCount++
-			if rowCount >= maxRows {
-				break
-			}
-		}
-
-		if rowCount < 1 {
-			return model.DataFile{}, fmt.Errorf("file must contain at least two rows")
-		}
-
-	} else {
-		// Process Excel file
-		excelFile, err := excelize.OpenReader(reader)
-		if err != nil {
-			return model.DataFile{}, fmt.Errorf("failed to read Excel file: %v", err)
-		}
-
-		sheetName := excelFile.GetSheetName(1)
-		excelRows, err := excelFile.GetRows(sheetName)
-		if err != nil || len(excelRows) == 0 {
-			return model.DataFile{}, fmt.Errorf("failed to read rows from Excel sheet")
-		}
-
-		headers = excelRows[0]
-		rows = excelRows[1:min(maxRows+1, len(excelRows))]
-		rowCount = len(rows)
-	}
-
-	if len(headers) < 2 {
-		return model.DataFile{}, fmt.Errorf("file must contain at least two columns")
-	}
-
-	// Validate each header
-	for _, header := range headers {
-		header = strings.TrimSpace(header)
-		if header == "" {
-			return model.DataFile{}, fmt.Errorf("headers must not be empty")
-		}
-		if isNumeric(header) {
-			return model.DataFile{}, fmt.Errorf("header must be a non-numeric string: %s", header)
-		}
-		if !isMeaningfulHeader(header) {
-			return model.DataFile{}, fmt.Errorf("header must contain at least one letter: %s", header)
-		}
-		if !isValidLength(header) {
-			return model.DataFile{}, fmt.Errorf("header length must be between 2 and 250 characters: %s", header)
-		}
+// handleImage handles pasted screenshots of tables. The table is extracted through the vision model and
+// returned for review, clients confirm the reviewed table with /api/images/:extraction_id/confirm.
+func handleImage(c *gin.Context) {
+	log.Println("Image upload received")
+	imageData, ext, err := ingest.ReceiveImage(c.Request, "screenshot")
+	if errors.Is(err, ingest.ErrFileTooLarge) {
+		c.String(http.StatusRequestEntityTooLarge, "%v", err)
+		return
 	}
-
-	return model.DataFile{
-		Headers:   headers,
-		FirstRows: rows,
-		Ext:       ext,
-		Data:      fileData,
-	}, nil
-}
-
-// Helper function to check if a string is numeric
-func isNumeric(s string) bool {
-	_, err := strconv.ParseFloat(s, 64)
-	return err == nil
-}
-
-// Helper function to check if the header has alphabetic characters
-func isMeaningfulHeader(header string) bool {
-	match, _ := regexp.MatchString(`[a-zA-Z]`, header)
-	return match
-}
-
-// Helper function to check the length of the header
-func isValidLength(header string) bool {
-	return len(header) > 1 && len(header) <= 250
-}
-
-// readFileData reads the uploaded file and returns its contents as a byte slice
-func readFileData2(file *multipart.FileHeader) ([]byte, error) {
-	uploadedFile, err := file.Open()
 	if err != nil {
-		return nil, err
+		c.String(http.StatusUnprocessableEntity, "There is a problem with the image: %v", err)
+		return
 	}
-	defer func(uploadedFile multipart.File) {
-		err := uploadedFile.Close()
-		if err != nil {
-			log.Println("Failed to close file", err)
-		}
-	}(uploadedFile)
 
-	fileData := make([]byte, file.Size)
-	_, err = uploadedFile.Read(fileData)
-	if err != nil {
-		return nil, err
+	extraction, err := service.ExtractImage(c.Request.Context(), handler.CurrentUserID(c), imageData, ext)
+	if errors.Is(err, ingest.ErrInvalidFile) {
+		c.String(http.StatusUnprocessableEntity, "No table could be extracted from the image: %v", err)
+		return
 	}
-
-	return fileData, nil
-}
-
-/*
-func handleImage(c *gin.Context) {
-	imageData, err := readImage(c)
-	if err != nil {
-		log.Println("Failed to read image data:", err)
-		c.String(http.StatusInternalServerError, "Failed to read image data")
+	if errors.Is(err, service.ErrBudgetExceeded) {
+		c.String(http.StatusPaymentRequired, "%v", err)
 		return
 	}
-
-	pipeline := ops.NewPipeline(
-		&ops.ImageDataExtractionOp{},
-		&ops.DataAnalysisOp{},
-		&ops.ChartGenerationOp{},
-	)
-
-	chart, err := pipeline.Execute(imageData)
 	if err != nil {
-		log.Println("Pipeline execution failed:", err)
+		log.Println("Failed to extract data from image:", err)
 		c.String(http.StatusInternalServerError, "Failed to process image data")
 		return
 	}
 
-	extractedData, _ := pipeline.GetResult(0)
-	analysisCode, _ := pipeline.GetResult(1)
-
-	c.HTML(http.StatusOK, "index.html", gin.H{
-		"PlotlyJSON": template.JS(chart.(string)),
-		"Data":       extractedData.(string),
-		"Code":       analysisCode.(string),
-	})
-}
-
-func readImage(c *gin.Context) ([]byte, error) {
-	file, _, err := c.Request.FormFile("screenshot")
-	if err != nil {
-		return nil, err
-	}
-	defer func(file multipart.File) {
-		err := file.Close()
-		if err != nil {
-			log.Println("Failed to close file")
-		}
-	}(file)
-
-	return ioutil.ReadAll(file)
+	c.JSON(http.StatusCreated, extraction)
 }
-*/
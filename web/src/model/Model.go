@@ -1,15 +1,51 @@
 package model
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"strings"
 )
 
+// DataFile is the stored sample of a data file. Key is the S3 key of the file, which is
+// streamed to the python environment when code runs.
 type DataFile struct {
 	Headers   []string
 	FirstRows [][]string
-	Data      []byte
+	Key       string
 	Ext       string
+	Format    FileFormat
+	Sheets    []SheetInfo
+	Profile   DataProfile
+}
+
+// FileFormat is the detected format of a data file together with the dialect of delimited files.
+// It's passed to the python environment, which loads the file with the reader matching Name.
+// SkipLines is the number of lines above the header row, e.g. report titles.
+// Sheets are the selected sheets of a workbook, the first one is the data the analysis is based on.
+type FileFormat struct {
+	Name      string   `json:"name"`
+	Delimiter string   `json:"delimiter,omitempty"`
+	Quote     string   `json:"quote,omitempty"`
+	Encoding  string   `json:"encoding,omitempty"`
+	BOM       bool     `json:"bom,omitempty"`
+	SkipLines int      `json:"skip_lines,omitempty"`
+	Sheets    []string `json:"sheets,omitempty"`
+}
+
+// SheetInfo describes a sheet of a workbook. Rows doesn't count the header row.
+type SheetInfo struct {
+	Name    string   `json:"name"`
+	Rows    int      `json:"rows"`
+	Columns int      `json:"columns"`
+	Headers []string `json:"headers"`
+}
+
+// Table is a grid of cells with a header row, e.g. extracted from an image of a table
+type Table struct {
+	Headers []string   `json:"headers"`
+	Rows    [][]string `json:"rows"`
 }
 
 type AnalysisOption struct {
@@ -19,8 +55,24 @@ type AnalysisOption struct {
 	Columns     []string `json:"columns"`
 }
 
+// Fingerprint identifies the option by a hash of its fields
+func (o *AnalysisOption) Fingerprint() string {
+	encoded, _ := json.Marshal(o)
+	hash := sha256.Sum256(encoded)
+	return hex.EncodeToString(hash[:])
+}
+
+// ColumnsString returns the normalized column names of the option as a comma separated list
+func (o *AnalysisOption) ColumnsString() string {
+	columns := make([]string, len(o.Columns))
+	for i, column := range o.Columns {
+		columns[i] = NormalizeColumnName(column)
+	}
+	return strings.Join(columns, ", ")
+}
+
 type CodeResponse struct {
-	Status string `json:"status"`
+	Status string `json:"status" enum:"ok,error"`
 	Code   string `json:"code,omitempty"`
 }
 
@@ -32,14 +84,50 @@ type AnalysisOptions struct {
 func (df *DataFile) HeadersString() string {
 	var formattedHeaders []string
 	for _, header := range df.Headers {
-		// Strip whitespace, convert to lowercase, and replace spaces with underscores
-		formattedHeader := strings.ToLower(strings.TrimSpace(header))
-		formattedHeader = strings.ReplaceAll(formattedHeader, " ", "_")
-		formattedHeaders = append(formattedHeaders, formattedHeader)
+		formattedHeaders = append(formattedHeaders, NormalizeColumnName(header))
 	}
 	return fmt.Sprintf("Headers: %s", strings.Join(formattedHeaders, ", "))
 }
 
+// Fingerprint identifies the shape of the data by a hash of its headers, first rows, profile and selected sheets
+func (df *DataFile) Fingerprint() string {
+	// Encoding string slices and the profile can't fail
+	encoded, _ := json.Marshal([]interface{}{df.Headers, df.FirstRows, df.Profile, df.Format.Sheets})
+	hash := sha256.Sum256(encoded)
+	return hex.EncodeToString(hash[:])
+}
+
+// SheetsString returns a plain text representation of the selected sheets of a workbook.
+// It's empty unless several sheets are selected.
+func (df *DataFile) SheetsString() string {
+	if len(df.Format.Sheets) < 2 {
+		return ""
+	}
+
+	lines := []string{fmt.Sprintf("The data is a workbook with %d selected sheets. df holds the sheet %q, "+
+		"each selected sheet is also available as a DataFrame in the dict dfs, keyed by the sheet name:", len(df.Format.Sheets), df.Format.Sheets[0])}
+	for _, name := range df.Format.Sheets {
+		for _, sheet := range df.Sheets {
+			if sheet.Name != name {
+				continue
+			}
+			columns := make([]string, len(sheet.Headers))
+			for i, header := range sheet.Headers {
+				columns[i] = NormalizeColumnName(header)
+			}
+			lines = append(lines, fmt.Sprintf("- dfs[%q]: %d rows, columns %s", name, sheet.Rows, strings.Join(columns, ", ")))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// NormalizeColumnName returns the column name as it appears in the DataFrame of the analysis service
+func NormalizeColumnName(name string) string {
+	// Strip whitespace, convert to lowercase, and replace spaces with underscores
+	normalized := strings.ToLower(strings.TrimSpace(name))
+	return strings.ReplaceAll(normalized, " ", "_")
+}
+
 // FirstRowsString returns a plain text representation of the first 5 rows
 func (df *DataFile) FirstRowsString() string {
 	var formattedRows []string
@@ -55,7 +143,7 @@ func (df *DataFile) FirstRowsString() string {
 
 // ChatGPTResponse represents the JSON structure returned by the ChatGPT API.
 type ChatGPTResponse struct {
-	Status  string `json:"status"`
+	Status  string `json:"status" enum:"ok,error"`
 	Message string `json:"message,omitempty"`
 }
 
@@ -70,3 +158,24 @@ type PythonCodeRequest struct {
 type PythonCodeResponse struct {
 	Chart string `json:"chart"`
 }
+
+// CodeAttempt is a single execution of generated code in the python environment
+type CodeAttempt struct {
+	Round int
+	Code  string
+	Error string
+}
+
+// GeneratedCode is analysis code together with the prompt version that produced it
+type GeneratedCode struct {
+	Code          string
+	PromptVersion string
+}
+
+// ChartResult holds a rendered chart together with the code that produced it.
+// PromptVersion is set when the code was repaired.
+type ChartResult struct {
+	Code          string
+	Chart         string
+	PromptVersion string
+}
@@ -0,0 +1,28 @@
+package model
+
+// PreviewQuery selects a page of the rows of a data file. Filters map column names to a value that
+// the cells must contain, or to a comparison like ">=10" for numeric and date columns.
+type PreviewQuery struct {
+	Page       int
+	PageSize   int
+	Sort       string
+	Descending bool
+	Filters    map[string]string
+}
+
+// DataPreview is a page of the rows of a data file together with the inferred column types
+type DataPreview struct {
+	Columns      []PreviewColumn `json:"columns"`
+	Rows         [][]string      `json:"rows"`
+	Page         int             `json:"page"`
+	PageSize     int             `json:"page_size"`
+	TotalRows    int             `json:"total_rows"`
+	MatchingRows int             `json:"matching_rows"`
+	// Truncated is set when filtering or sorting stopped at the scan limit, the counts cover the scanned rows
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+type PreviewColumn struct {
+	Name string     `json:"name"`
+	Type ColumnType `json:"type"`
+}
@@ -0,0 +1,75 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ColumnType is the type of the values of a column as inferred by the profiler
+type ColumnType string
+
+const (
+	ColumnInteger     ColumnType = "integer"
+	ColumnFloat       ColumnType = "float"
+	ColumnDate        ColumnType = "date"
+	ColumnCategorical ColumnType = "categorical"
+	ColumnText        ColumnType = "text"
+	ColumnID          ColumnType = "id"
+	ColumnEmpty       ColumnType = "empty"
+)
+
+// DataProfile holds statistics over all rows of a data file.
+// SkippedRows counts the rows left out of the analysis because they have more fields than the header row.
+type DataProfile struct {
+	Rows        int             `json:"rows"`
+	SkippedRows int             `json:"skipped_rows,omitempty"`
+	Columns     []ColumnProfile `json:"columns"`
+}
+
+// ColumnProfile holds the statistics of a single column.
+// DistinctCapped is set when the column has more distinct values than the profiler counts.
+type ColumnProfile struct {
+	Name           string       `json:"name"`
+	Type           ColumnType   `json:"type"`
+	NullRatio      float64      `json:"null_ratio"`
+	Distinct       int          `json:"distinct"`
+	DistinctCapped bool         `json:"distinct_capped,omitempty"`
+	Min            string       `json:"min,omitempty"`
+	Max            string       `json:"max,omitempty"`
+	TopValues      []ValueCount `json:"top_values,omitempty"`
+}
+
+// ValueCount is a value of a column together with the number of rows holding it
+type ValueCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// String returns a plain text representation of the profile for prompts
+func (p *DataProfile) String() string {
+	if len(p.Columns) == 0 {
+		return ""
+	}
+
+	lines := []string{fmt.Sprintf("Column profile (%d rows):", p.Rows)}
+	for _, column := range p.Columns {
+		distinct := fmt.Sprintf("%d", column.Distinct)
+		if column.DistinctCapped {
+			distinct = "over " + distinct
+		}
+		line := fmt.Sprintf("- %s: %s, %.1f%% null, %s distinct",
+			NormalizeColumnName(column.Name), column.Type, column.NullRatio*100, distinct)
+		if column.Min != "" || column.Max != "" {
+			line += fmt.Sprintf(", min %s, max %s", column.Min, column.Max)
+		}
+		if len(column.TopValues) > 0 {
+			values := make([]string, len(column.TopValues))
+			for i, value := range column.TopValues {
+				values[i] = fmt.Sprintf("%s (%d)", value.Value, value.Count)
+			}
+			line += ", top values: " + strings.Join(values, ", ")
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -1,15 +1,17 @@
 package ops
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
+	"strconv"
+	"time"
 	"web/src/model"
+	"web/src/util"
 )
 
 type ChartGenerationOp struct {
@@ -20,70 +22,85 @@ func NewChartGenerationOp(dataFile model.DataFile) *ChartGenerationOp {
 	return &ChartGenerationOp{dataFile}
 }
 
+func (op *ChartGenerationOp) Name() string {
+	return "rendering chart"
+}
+
 func (op *ChartGenerationOp) Retries() int {
 	return 2
 }
 
-func (op *ChartGenerationOp) Run(input interface{}) (interface{}, error) {
-	code, ok := input.(string)
-	if !ok {
-		return nil, errors.New("invalid input type for ChartGenerationOp")
-	}
+func (op *ChartGenerationOp) Timeout() time.Duration {
+	return pythonTimeout
+}
 
+func (op *ChartGenerationOp) Run(ctx context.Context, code string) (string, error) {
 	log.Println("Python code:\n", code)
 
 	// Run the python code to generate a chart
-	chart, err := executePythonCode(code, op.dataFile)
+	chart, err := executePythonCode(ctx, code, op.dataFile)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 	return chart.Chart, nil
 }
 
-const pythonAPIURL = "http://localhost:7000/generate-chart/"
+// PythonExecutionError is returned when the python environment rejects the code, e.g. because it raised an exception.
+// Traceback holds the error message and traceback reported by the python environment.
+type PythonExecutionError struct {
+	Traceback string
+}
 
-func executePythonCode(code string, dataFile model.DataFile) (model.PythonCodeResponse, error) {
-	// Create a buffer to hold the multipart form data
-	var requestBody bytes.Buffer
-	writer := multipart.NewWriter(&requestBody)
+func (e *PythonExecutionError) Error() string {
+	return fmt.Sprintf("error from the python environment: %s", e.Traceback)
+}
 
-	// Add the code field to the form
-	codePart, err := writer.CreateFormField("code")
-	if err != nil {
-		return model.PythonCodeResponse{}, fmt.Errorf("error creating form field for code: %v", err)
+func newPythonExecutionError(body []byte) *PythonExecutionError {
+	var errorResponse struct {
+		Detail string `json:"detail"`
 	}
-	_, err = codePart.Write([]byte(code))
-	if err != nil {
-		return model.PythonCodeResponse{}, fmt.Errorf("error writing code to form field: %v", err)
+	if err := json.Unmarshal(body, &errorResponse); err != nil || errorResponse.Detail == "" {
+		return &PythonExecutionError{Traceback: string(body)}
 	}
+	return &PythonExecutionError{Traceback: errorResponse.Detail}
+}
 
-	// Add the file field using the file content in memory
-	filePart, err := writer.CreateFormFile("file", "data."+dataFile.Ext)
-	if err != nil {
-		return model.PythonCodeResponse{}, fmt.Errorf("error creating form file for upload: %v", err)
-	}
-	_, err = io.Copy(filePart, bytes.NewReader(dataFile.Data))
-	if err != nil {
-		return model.PythonCodeResponse{}, fmt.Errorf("error copying file data: %v", err)
-	}
+const pythonAPIURL = "http://localhost:7000/generate-chart/"
 
-	// Close the writer to finalize the form
-	err = writer.Close()
-	if err != nil {
-		return model.PythonCodeResponse{}, fmt.Errorf("error closing writer: %v", err)
-	}
+// pythonClient bounds requests to the python environment even when the caller sets no deadline
+var pythonClient = &http.Client{Timeout: pythonTimeout}
+
+// executePythonCode runs code in the python environment against the data file, which is streamed
+// from S3 into the request body so the file is never held in memory
+func executePythonCode(ctx context.Context, code string, dataFile model.DataFile) (model.PythonCodeResponse, error) {
+	pipeReader, pipeWriter := io.Pipe()
+	writer := multipart.NewWriter(pipeWriter)
+	written := make(chan error, 1)
+	go func() {
+		err := writePythonForm(writer, code, dataFile)
+		written <- err
+		pipeWriter.CloseWithError(err)
+	}()
 
 	// Create the HTTP request with the multipart form data
-	req, err := http.NewRequest("POST", pythonAPIURL, &requestBody)
+	req, err := http.NewRequestWithContext(ctx, "POST", pythonAPIURL, pipeReader)
 	if err != nil {
+		pipeReader.Close()
 		return model.PythonCodeResponse{}, fmt.Errorf("error creating HTTP request: %v", err)
 	}
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 
 	// Execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := pythonClient.Do(req)
 	if err != nil {
+		// Report why the file couldn't be sent, e.g. because it can't be read from S3
+		select {
+		case writeErr := <-written:
+			if writeErr != nil {
+				return model.PythonCodeResponse{}, writeErr
+			}
+		default:
+		}
 		return model.PythonCodeResponse{}, fmt.Errorf("error making request to Python API: %v", err)
 	}
 	defer func(Body io.ReadCloser) {
@@ -98,9 +115,12 @@ func executePythonCode(code string, dataFile model.DataFile) (model.PythonCodeRe
 	if err != nil {
 		return model.PythonCodeResponse{}, fmt.Errorf("error reading response body: %v", err)
 	}
-	if resp.StatusCode > 399 {
+	if resp.StatusCode >= 500 {
 		return model.PythonCodeResponse{}, fmt.Errorf("error from the python environment: %s", body)
 	}
+	if resp.StatusCode > 399 {
+		return model.PythonCodeResponse{}, newPythonExecutionError(body)
+	}
 
 	// Parse the response
 	var pythonResponse model.PythonCodeResponse
@@ -110,3 +130,50 @@ func executePythonCode(code string, dataFile model.DataFile) (model.PythonCodeRe
 
 	return pythonResponse, nil
 }
+
+// writePythonForm writes the code, the format of the data file and the file streamed from S3 as multipart form
+func writePythonForm(writer *multipart.Writer, code string, dataFile model.DataFile) error {
+	if err := writer.WriteField("code", code); err != nil {
+		return fmt.Errorf("error writing code to form field: %v", err)
+	}
+
+	// Add the format hint and dialect so the python environment loads the file with the matching reader
+	format := dataFile.Format
+	formatFields := map[string]string{
+		"format":     format.Name,
+		"delimiter":  format.Delimiter,
+		"quote":      format.Quote,
+		"encoding":   format.Encoding,
+		"bom":        strconv.FormatBool(format.BOM),
+		"skip_lines": strconv.Itoa(format.SkipLines),
+	}
+	for name, value := range formatFields {
+		if err := writer.WriteField(name, value); err != nil {
+			return fmt.Errorf("error writing %s to form field: %v", name, err)
+		}
+	}
+	for _, sheet := range format.Sheets {
+		if err := writer.WriteField("sheets", sheet); err != nil {
+			return fmt.Errorf("error writing sheets to form field: %v", err)
+		}
+	}
+
+	body, err := util.OpenFromS3(dataFile.Key)
+	if err != nil {
+		return err
+	}
+	defer func(body io.ReadCloser) {
+		if err := body.Close(); err != nil {
+			log.Println("Failed to close S3 object body", err)
+		}
+	}(body)
+
+	filePart, err := writer.CreateFormFile("file", "data"+dataFile.Ext)
+	if err != nil {
+		return fmt.Errorf("error creating form file for upload: %v", err)
+	}
+	if _, err := io.Copy(filePart, body); err != nil {
+		return fmt.Errorf("error copying file data: %v", err)
+	}
+	return writer.Close()
+}
@@ -0,0 +1,121 @@
+package ops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"web/src/llm"
+	"web/src/model"
+	"web/src/prompts"
+)
+
+// AttemptRecorder is called for every execution of code in the python environment
+type AttemptRecorder func(attempt model.CodeAttempt)
+
+// ChartRepairOp runs code in the python environment and, when the code fails, sends the failing code
+// together with the python traceback back to the LLM to produce a corrected version.
+// It gives up after maxRounds repairs and returns the chart together with the code that produced it.
+type ChartRepairOp struct {
+	dataFile  model.DataFile
+	maxRounds int
+	record    AttemptRecorder
+}
+
+func NewChartRepairOp(dataFile model.DataFile, maxRounds int, record AttemptRecorder) *ChartRepairOp {
+	return &ChartRepairOp{dataFile, maxRounds, record}
+}
+
+func (op *ChartRepairOp) Name() string {
+	return "rendering chart"
+}
+
+// Retries is 1 as failing code is already retried by the repair rounds
+func (op *ChartRepairOp) Retries() int {
+	return 1
+}
+
+func (op *ChartRepairOp) Timeout() time.Duration {
+	return RepairTimeout(op.maxRounds)
+}
+
+// RepairTimeout is the deadline of a chart repair with maxRounds rounds. It covers the first execution
+// of the code and a repair and an execution of the repaired code for each round.
+func RepairTimeout(maxRounds int) time.Duration {
+	return time.Duration(maxRounds+1)*pythonTimeout + time.Duration(maxRounds)*llmTimeout
+}
+
+func (op *ChartRepairOp) Run(ctx context.Context, code string) (model.ChartResult, error) {
+	promptVersion := ""
+	for round := 0; ; round++ {
+		chart, err := executePythonCode(ctx, code, op.dataFile)
+
+		var pythonErr *PythonExecutionError
+		if err != nil && !errors.As(err, &pythonErr) {
+			// The python environment is not reachable, repairing the code won't help
+			return model.ChartResult{}, err
+		}
+
+		attempt := model.CodeAttempt{Round: round, Code: code}
+		if pythonErr != nil {
+			attempt.Error = pythonErr.Traceback
+		}
+		if op.record != nil {
+			op.record(attempt)
+		}
+
+		if pythonErr == nil {
+			return model.ChartResult{Code: code, Chart: chart.Chart, PromptVersion: promptVersion}, nil
+		}
+		if round >= op.maxRounds {
+			return model.ChartResult{}, fmt.Errorf("code still failing after %d repair rounds: %w", op.maxRounds, pythonErr)
+		}
+
+		log.Printf("Repair round %d/%d for failing code", round+1, op.maxRounds)
+		repaired, err := repairCode(ctx, code, pythonErr.Traceback, op.dataFile)
+		if err != nil {
+			return model.ChartResult{}, err
+		}
+		code, promptVersion = repaired.Code, repaired.PromptVersion
+	}
+}
+
+// repairCode asks the LLM to fix code that failed with the given traceback
+func repairCode(ctx context.Context, code string, traceback string, data model.DataFile) (model.GeneratedCode, error) {
+	request, prompt, err := createCodeRepairRequest(code, traceback, data)
+	if err != nil {
+		return model.GeneratedCode{}, err
+	}
+
+	var codeResponse model.CodeResponse
+	err = llm.SendJSON(ctx, llm.ProviderFor(llm.OperationRepair), request, &codeResponse)
+	if err != nil {
+		log.Println("Failed to repair code:", err)
+		return model.GeneratedCode{}, fmt.Errorf("failed to repair code: %w", err)
+	}
+	if codeResponse.Status != "ok" || codeResponse.Code == "" {
+		return model.GeneratedCode{}, errors.New("no repaired code returned")
+	}
+
+	return model.GeneratedCode{Code: codeResponse.Code, PromptVersion: prompt.ID()}, nil
+}
+
+// createCodeRepairRequest constructs a request payload for fixing Python code that raised an error.
+// Repairs aren't cached, a cached repair that failed before would fail again.
+func createCodeRepairRequest(code string, traceback string, data model.DataFile) (llm.Request, prompts.Prompt, error) {
+	vars := dataVars(data)
+	vars.Code = code
+	vars.Traceback = traceback
+
+	prompt, err := prompts.Render(prompts.CodeRepair, vars)
+	if err != nil {
+		return llm.Request{}, prompts.Prompt{}, err
+	}
+
+	return llm.Request{
+		Operation:     llm.OperationRepair,
+		PromptVersion: prompt.ID(),
+		Messages:      promptMessages(prompt),
+	}, prompt, nil
+}
@@ -1,66 +1,72 @@
 package ops
 
 import (
-	"encoding/base64"
-	"errors"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"github.com/sashabaranov/go-openai"
 	"log"
+	"net/http"
+	"time"
+	"web/src/ingest"
 	"web/src/llm"
+	"web/src/model"
+	"web/src/prompts"
 )
 
 type ImageDataExtractionOp struct{}
 
+func (op *ImageDataExtractionOp) Name() string {
+	return "extracting data"
+}
+
 func (op *ImageDataExtractionOp) Retries() int {
 	return 3
 }
 
-func (op *ImageDataExtractionOp) Run(input interface{}) (interface{}, error) {
-	imageData, ok := input.([]byte)
-	if !ok {
-		return nil, errors.New("invalid input type for ImageDataExtractionOp")
-	}
+func (op *ImageDataExtractionOp) Timeout() time.Duration {
+	return llmTimeout
+}
 
-	base64Image := base64.StdEncoding.EncodeToString(imageData)
+// Run extracts the table in an image through the vision model and parses the CSV it responds with
+func (op *ImageDataExtractionOp) Run(ctx context.Context, imageData []byte) (model.Table, error) {
+	request, err := createImageExtractionRequest(imageData)
+	if err != nil {
+		return model.Table{}, err
+	}
 
-	request := createImageExtractionRequest(base64Image)
-	response, success := llm.SendToGPTWithRetry(request)
-	if !success {
-		log.Println("Failed to extract data from image")
-		return nil, errors.New("failed to extract data from image")
+	var response model.ChatGPTResponse
+	err = llm.SendJSON(ctx, llm.ProviderFor(llm.OperationImage), request, &response)
+	if err != nil {
+		log.Println("Failed to extract data from image:", err)
+		return model.Table{}, fmt.Errorf("failed to extract data from image: %w", err)
+	}
+	if response.Status != "ok" || response.Message == "" {
+		return model.Table{}, fmt.Errorf("%w: no table found in the image: %s", ingest.ErrInvalidFile, response.Message)
 	}
 
-	return response, nil
+	return ingest.ParseTable(response.Message)
 }
 
-func createImageExtractionRequest(base64Image string) openai.ChatCompletionRequest {
-	imagePart := openai.ChatMessagePart{
-		Type: openai.ChatMessagePartType("image_url"),
-		ImageURL: &openai.ChatMessageImageURL{
-			URL:    fmt.Sprintf("data:image/png;base64,%s", base64Image),
-			Detail: "high",
-		},
+func createImageExtractionRequest(imageData []byte) (llm.Request, error) {
+	prompt, err := prompts.Render(prompts.ImageExtraction, prompts.Vars{})
+	if err != nil {
+		return llm.Request{}, err
 	}
 
-	textPart := openai.ChatMessagePart{
-		Type: openai.ChatMessagePartType("text"),
-		Text: "Create a CSV representation of the data in the image",
+	imageHash := sha256.Sum256(imageData)
+	image := llm.Image{
+		MimeType: http.DetectContentType(imageData),
+		Data:     imageData,
+		Detail:   "high",
 	}
+	messages := promptMessages(prompt)
+	messages[1].Images = []llm.Image{image}
 
-	return openai.ChatCompletionRequest{
-		Model: openai.GPT4oMini,
-		Messages: []openai.ChatCompletionMessage{
-			{
-				Role: "system",
-				Content: `You are a data extraction tool specialized in reading structured tables from images. 
-Respond with a valid json document following this structure:
-{"status": "ok", "message": "message"} 
-Set the status to either "ok" or "error" and provide result in the message field.`,
-			},
-			{
-				Role:         "user",
-				MultiContent: []openai.ChatMessagePart{textPart, imagePart},
-			},
-		},
-	}
+	return llm.Request{
+		Operation:     llm.OperationImage,
+		CacheKey:      hex.EncodeToString(imageHash[:]),
+		PromptVersion: prompt.ID(),
+		Messages:      messages,
+	}, nil
 }
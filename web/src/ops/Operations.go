@@ -1,61 +1,217 @@
 package ops
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"time"
 )
 
-type Operation interface {
+// Operation is a pipeline step turning an input of type In into an output of type Out.
+// Each attempt to run it gets a context with a deadline of Timeout, zero means no deadline.
+type Operation[In, Out any] interface {
 	Retries() int
-	Run(input interface{}) (output interface{}, err error)
+	Timeout() time.Duration
+	Run(ctx context.Context, input In) (output Out, err error)
 }
 
+// Deadlines of a single attempt of the operations
+const (
+	llmTimeout    = 3 * time.Minute
+	pythonTimeout = 2 * time.Minute
+)
+
+// ProcessingTimeout is the time processing an insight takes at most if generating the options and the code
+// succeeds in the first attempt and the chart repair runs all of its rounds
+func ProcessingTimeout(repairRounds int) time.Duration {
+	return 2*llmTimeout + RepairTimeout(repairRounds)
+}
+
+// Named is implemented by operations that provide a human-readable name for progress reporting
+type Named interface {
+	Name() string
+}
+
+type EventType string
+
+const (
+	EventStarted   EventType = "started"
+	EventRetrying  EventType = "retrying"
+	EventSucceeded EventType = "succeeded"
+	EventFailed    EventType = "failed"
+)
+
+// Event describes the progress of a single pipeline step
+type Event struct {
+	Type    EventType
+	Index   int
+	Step    string
+	Attempt int
+	Retries int
+	Err     error
+}
+
+// Listener is notified about the progress of the pipeline steps
+type Listener func(event Event)
+
+// Pipeline stores the results of its steps and reports their progress.
+// Steps are added with Start and Then, which check at compile time that the output of a step
+// matches the input of the next one.
 type Pipeline struct {
-	steps   []Operation
-	results map[int]interface{}
+	steps    int
+	results  map[int]interface{}
+	listener Listener
 }
 
-func NewPipeline(steps ...Operation) *Pipeline {
-	return &Pipeline{steps: steps, results: make(map[int]interface{})}
+func NewPipeline() *Pipeline {
+	return &Pipeline{results: make(map[int]interface{})}
 }
 
-func (p *Pipeline) Execute(initialInput interface{}) (interface{}, error) {
-	var err error
-	output := initialInput
+// WithListener registers a listener for the step events of the pipeline
+func (p *Pipeline) WithListener(listener Listener) *Pipeline {
+	p.listener = listener
+	return p
+}
 
-	now := time.Now()
-	for index, step := range p.steps {
-		log.Println("Execute operation", index)
-		output, err = p.runWithRetries(step, output, step.Retries())
-		if err != nil {
-			log.Println("Operation", index, "failed in", time.Since(now))
-			return nil, err // Terminate pipeline if a step fails after retries
-		}
-		// Store the successful output for this operation
-		p.results[index] = output
-		log.Println("Operation", index, "completed in", time.Since(now))
+// Handle identifies the result of a pipeline step of type T
+type Handle[T any] struct {
+	index int
+}
+
+// Chain is a sequence of pipeline steps turning an input of type In into an output of type Out
+type Chain[In, Out any] struct {
+	pipeline *Pipeline
+	handle   Handle[Out]
+	run      func(ctx context.Context, input In) (Out, error)
+}
+
+// Start begins a chain of steps in the pipeline with op as its first step
+func Start[In, Out any](p *Pipeline, op Operation[In, Out]) Chain[In, Out] {
+	index := p.addStep()
+	return Chain[In, Out]{
+		pipeline: p,
+		handle:   Handle[Out]{index},
+		run: func(ctx context.Context, input In) (Out, error) {
+			return runStep(ctx, p, index, op, input)
+		},
+	}
+}
+
+// Then appends op to the chain, op receives the output of the previous step as its input
+func Then[In, Mid, Out any](chain Chain[In, Mid], op Operation[Mid, Out]) Chain[In, Out] {
+	p := chain.pipeline
+	index := p.addStep()
+	return Chain[In, Out]{
+		pipeline: p,
+		handle:   Handle[Out]{index},
+		run: func(ctx context.Context, input In) (Out, error) {
+			mid, err := chain.run(ctx, input)
+			if err != nil {
+				var zero Out
+				return zero, err
+			}
+			return runStep(ctx, p, index, op, mid)
+		},
 	}
-	return output, nil
 }
 
-// GetResult returns the output of a specific operation by its index
-func (p *Pipeline) GetResult(stepIndex int) (interface{}, bool) {
-	result, exists := p.results[stepIndex]
-	return result, exists
+// Execute runs all steps of the chain and returns the output of the last one.
+// It stops as soon as ctx is cancelled.
+func (c Chain[In, Out]) Execute(ctx context.Context, input In) (Out, error) {
+	return c.run(ctx, input)
 }
 
-func (p *Pipeline) runWithRetries(op Operation, input interface{}, retries int) (interface{}, error) {
-	var output interface{}
+// Handle returns the handle of the last step of the chain
+func (c Chain[In, Out]) Handle() Handle[Out] {
+	return c.handle
+}
+
+// Result returns the output of the step identified by the handle, if the step succeeded
+func Result[T any](p *Pipeline, handle Handle[T]) (T, bool) {
+	result, exists := p.results[handle.index]
+	if !exists {
+		var zero T
+		return zero, false
+	}
+	return result.(T), true
+}
+
+func (p *Pipeline) addStep() int {
+	index := p.steps
+	p.steps++
+	return index
+}
+
+// runStep runs a single step with retries and stores its output
+func runStep[In, Out any](ctx context.Context, p *Pipeline, index int, op Operation[In, Out], input In) (Out, error) {
+	now := time.Now()
+	log.Println("Execute operation", index)
+	output, err := runWithRetries(ctx, p, index, op, input)
+	if err != nil {
+		log.Println("Operation", index, "failed in", time.Since(now))
+		return output, err
+	}
+	// Store the successful output for this operation
+	p.results[index] = output
+	log.Println("Operation", index, "completed in", time.Since(now))
+	return output, nil
+}
+
+func runWithRetries[In, Out any](ctx context.Context, p *Pipeline, index int, op Operation[In, Out], input In) (Out, error) {
+	var output Out
 	var err error
 
+	retries := op.Retries()
+	event := Event{Index: index, Step: stepName(op), Retries: retries}
 	for attempt := 1; attempt <= retries; attempt++ {
-		output, err = op.Run(input)
+		if ctx.Err() != nil {
+			// Retrying is pointless once the caller is gone
+			err = ctx.Err()
+			break
+		}
+
+		event.Attempt = attempt
+		if attempt == 1 {
+			event.Type = EventStarted
+		} else {
+			event.Type, event.Err = EventRetrying, err
+		}
+		p.notify(event)
+
+		output, err = runAttempt(ctx, op, input)
 		if err == nil {
+			event.Type, event.Err = EventSucceeded, nil
+			p.notify(event)
 			return output, nil
 		}
 		log.Printf("Attempt %d/%d for operation %T failed: %v", attempt, retries, op, err)
 	}
 
-	return nil, fmt.Errorf("operation %T failed after %d retries: %w", op, retries, err)
+	event.Type, event.Err = EventFailed, err
+	p.notify(event)
+	var zero Out
+	return zero, fmt.Errorf("operation %T failed after %d retries: %w", op, retries, err)
+}
+
+// runAttempt runs a single attempt of the operation within its timeout
+func runAttempt[In, Out any](ctx context.Context, op Operation[In, Out], input In) (Out, error) {
+	if timeout := op.Timeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return op.Run(ctx, input)
+}
+
+func (p *Pipeline) notify(event Event) {
+	if p.listener != nil {
+		p.listener(event)
+	}
+}
+
+func stepName(op interface{}) string {
+	if named, ok := op.(Named); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", op)
 }
@@ -0,0 +1,145 @@
+package ops
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+	"web/src/llm"
+	"web/src/model"
+)
+
+func TestMain(m *testing.M) {
+	llm.SetRetryBackoff(time.Millisecond)
+	os.Exit(m.Run())
+}
+
+// scriptedOp asks the LLM for code like AnalysisCodeOp, with a short timeout per attempt
+type scriptedOp struct {
+	retries int
+	timeout time.Duration
+}
+
+func (op *scriptedOp) Name() string {
+	return "scripted"
+}
+
+func (op *scriptedOp) Retries() int {
+	return op.retries
+}
+
+func (op *scriptedOp) Timeout() time.Duration {
+	return op.timeout
+}
+
+func (op *scriptedOp) Run(ctx context.Context, prompt string) (model.CodeResponse, error) {
+	request := llm.Request{Operation: llm.OperationCode, Messages: []llm.Message{{Role: llm.RoleUser, Content: prompt}}}
+	var response model.CodeResponse
+	err := llm.SendJSON(ctx, llm.ProviderFor(llm.OperationCode), request, &response)
+	return response, err
+}
+
+// lengthOp is a step without LLM calls that counts the characters of the code
+type lengthOp struct{}
+
+func (op *lengthOp) Retries() int {
+	return 1
+}
+
+func (op *lengthOp) Timeout() time.Duration {
+	return 0
+}
+
+func (op *lengthOp) Run(ctx context.Context, response model.CodeResponse) (int, error) {
+	return len(response.Code), nil
+}
+
+// useProvider answers the requests of the operation with a scripted provider for the duration of a test
+func useProvider(t *testing.T, operation string, provider llm.Provider) {
+	t.Cleanup(func() { llm.SetProvider(operation, nil) })
+	llm.SetProvider(operation, provider)
+}
+
+func recordEvents(p *Pipeline) *[]EventType {
+	var events []EventType
+	p.WithListener(func(event Event) { events = append(events, event.Type) })
+	return &events
+}
+
+func TestPipelineRetriesAttemptsThatTimeOut(t *testing.T) {
+	provider := llm.NewScriptedProvider(llm.Hang(), llm.Reply(`{"status": "ok", "code": "output = df"}`))
+	useProvider(t, llm.OperationCode, provider)
+
+	pipeline := NewPipeline()
+	events := recordEvents(pipeline)
+	chain := Then(Start(pipeline, &scriptedOp{retries: 2, timeout: 20 * time.Millisecond}), &lengthOp{})
+
+	length, err := chain.Execute(context.Background(), "generate code")
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if length != len("output = df") {
+		t.Errorf("length = %d, want %d", length, len("output = df"))
+	}
+
+	want := []EventType{EventStarted, EventRetrying, EventSucceeded, EventStarted, EventSucceeded}
+	if !reflect.DeepEqual(*events, want) {
+		t.Errorf("events = %v, want %v", *events, want)
+	}
+	if _, ok := Result(pipeline, chain.Handle()); !ok {
+		t.Error("the result of the last step isn't stored")
+	}
+}
+
+func TestPipelineFailsWhenEveryAttemptTimesOut(t *testing.T) {
+	provider := llm.NewScriptedProvider(llm.Hang(), llm.Hang(), llm.Reply(`{"status": "ok", "code": "output = df"}`))
+	useProvider(t, llm.OperationCode, provider)
+
+	pipeline := NewPipeline()
+	events := recordEvents(pipeline)
+	first := Start(pipeline, &scriptedOp{retries: 2, timeout: 20 * time.Millisecond})
+	chain := Then(first, &lengthOp{})
+
+	_, err := chain.Execute(context.Background(), "generate code")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	want := []EventType{EventStarted, EventRetrying, EventFailed}
+	if !reflect.DeepEqual(*events, want) {
+		t.Errorf("events = %v, want %v", *events, want)
+	}
+	if provider.Remaining() != 1 {
+		t.Errorf("%d requests sent, want 2", 3-provider.Remaining())
+	}
+	if _, ok := Result(pipeline, first.Handle()); ok {
+		t.Error("a result is stored for the failed step")
+	}
+}
+
+func TestPipelineStopsRetryingWhenContextIsDone(t *testing.T) {
+	provider := llm.NewScriptedProvider(llm.Hang(), llm.Reply(`{"status": "ok", "code": "output = df"}`))
+	useProvider(t, llm.OperationCode, provider)
+
+	// The job deadline ends before the deadline of the attempt
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := Start(NewPipeline(), &scriptedOp{retries: 3, timeout: time.Minute}).Execute(ctx, "generate code")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if provider.Remaining() != 1 {
+		t.Error("the step was retried after the context was done")
+	}
+}
+
+func TestRepairTimeoutFitsProcessingTimeout(t *testing.T) {
+	for rounds := 0; rounds <= 5; rounds++ {
+		op := NewChartRepairOp(model.DataFile{}, rounds, nil)
+		if op.Timeout() > ProcessingTimeout(rounds) {
+			t.Errorf("%d rounds: repair timeout %s exceeds the processing timeout %s", rounds, op.Timeout(), ProcessingTimeout(rounds))
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package ops
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// pythonPlotlyURL serves the plotly.js bundled with the plotly package of the python environment
+const pythonPlotlyURL = "http://localhost:7000/plotly.min.js"
+
+var plotlyJS struct {
+	sync.Mutex
+	script []byte
+}
+
+// PlotlyJS returns the plotly.js of the python environment. It is the version matching the figures the python
+// environment generates, so charts only change when the pinned plotly package is updated.
+// The script is fetched once and kept in memory.
+func PlotlyJS(ctx context.Context) ([]byte, error) {
+	plotlyJS.Lock()
+	defer plotlyJS.Unlock()
+	if plotlyJS.script != nil {
+		return plotlyJS.script, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pythonPlotlyURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating HTTP request: %v", err)
+	}
+	resp, err := pythonClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making request to Python API: %v", err)
+	}
+	defer func(Body io.ReadCloser) {
+		if err := Body.Close(); err != nil {
+			log.Println("Failed to close response body", err)
+		}
+	}(resp.Body)
+
+	script, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error from the python environment: %s", script)
+	}
+
+	plotlyJS.script = script
+	return script, nil
+}
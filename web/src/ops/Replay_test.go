@@ -0,0 +1,85 @@
+package ops
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"web/src/model"
+	"web/src/profile"
+	"web/src/util"
+)
+
+// The replay tests answer the prompts from the fixtures recorded in testdata/llm, so they run offline.
+// Changing a prompt or the sample data changes the request hash, record the fixtures again with
+//
+//	LLM_PROVIDER=record LLM_RECORD_PROVIDER=openai OPENAI_API_KEY=... go test ./src/ops -run Replay
+func useFixtures(t *testing.T) {
+	if util.Env("LLM_PROVIDER") == "" {
+		t.Setenv("LLM_PROVIDER", "replay")
+	}
+	// New template versions must not invalidate the fixtures
+	t.Setenv("PROMPT_ANALYSIS_OPTIONS_VERSION", "v3")
+	t.Setenv("PROMPT_ANALYSIS_CODE_VERSION", "v3")
+}
+
+func salesData() model.DataFile {
+	headers := []string{"Region", "Month", "Revenue", "Units"}
+	rows := [][]string{
+		{"North", "2024-01", "12500.50", "120"},
+		{"South", "2024-01", "9800.00", "95"},
+		{"North", "2024-02", "13100.25", "126"},
+		{"South", "2024-02", "10250.75", "101"},
+		{"West", "2024-02", "7600.00", "70"},
+	}
+	return model.DataFile{
+		Headers:   headers,
+		FirstRows: rows,
+		Ext:       ".csv",
+		Format:    model.FileFormat{Name: "csv", Delimiter: ","},
+		Profile:   profile.Build(headers, rows),
+	}
+}
+
+func TestDataAnalysisOptionsOpReplay(t *testing.T) {
+	useFixtures(t)
+
+	options, err := Start(NewPipeline(), &DataAnalysisOptionsOp{}).Execute(context.Background(), salesData())
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if len(options.AnalysisOptions) == 0 {
+		t.Fatal("no analysis options")
+	}
+	headers := map[string]bool{"region": true, "month": true, "revenue": true, "units": true}
+	for _, option := range options.AnalysisOptions {
+		if option.Name == "" || option.ChartType == "" || len(option.Columns) == 0 {
+			t.Errorf("incomplete option %+v", option)
+		}
+		for _, column := range option.Columns {
+			if !headers[model.NormalizeColumnName(column)] {
+				t.Errorf("option %s uses unknown column %s", option.Name, column)
+			}
+		}
+	}
+}
+
+func TestAnalysisCodeOpReplay(t *testing.T) {
+	useFixtures(t)
+	option := model.AnalysisOption{
+		Name:        "Revenue by region",
+		ChartType:   "bar",
+		Description: "Compare the total revenue of the regions",
+		Columns:     []string{"Region", "Revenue"},
+	}
+
+	code, err := Start(NewPipeline(), NewAnalysisCodeOp(option)).Execute(context.Background(), salesData())
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if !strings.Contains(code.Code, "output") {
+		t.Errorf("code doesn't assign the chart to output:\n%s", code.Code)
+	}
+	if code.PromptVersion != "analysis_code@v3" {
+		t.Errorf("prompt version = %s, want analysis_code@v3", code.PromptVersion)
+	}
+}
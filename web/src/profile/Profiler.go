@@ -0,0 +1,268 @@
+package profile
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"web/src/model"
+)
+
+// typeThreshold is the share of non-null values that must parse as a type for the column to get the type
+const typeThreshold = 0.95
+
+// maxCategories is the number of distinct values up to which a string column is categorical
+const maxCategories = 20
+
+// topValues is the number of most frequent values reported per column
+const topValues = 5
+
+// maxTrackedValues limits the distinct values counted per column, so large files are profiled in bounded memory
+const maxTrackedValues = 10000
+
+// nullValues are the cell values treated as missing
+var nullValues = map[string]bool{"": true, "na": true, "n/a": true, "nan": true, "null": true, "none": true, "-": true}
+
+// dateLayouts are the date formats recognized by the profiler
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+	"2006/01/02",
+	"01/02/2006",
+	"1/2/2006",
+	"02.01.2006",
+	"2006-01",
+	"Jan 2, 2006",
+	"2 Jan 2006",
+}
+
+// Profiler collects column statistics row by row, so files can be profiled while they are streamed
+type Profiler struct {
+	rows    int
+	columns []*columnStats
+}
+
+// columnStats holds the running statistics of a column
+type columnStats struct {
+	name     string
+	nulls    int
+	nonNull  int
+	counts   map[string]int
+	capped   bool
+	integers int
+	floats   int
+	dates    int
+	hasNum   bool
+	minNum   float64
+	maxNum   float64
+	minDate  time.Time
+	maxDate  time.Time
+	runes    int
+	minLen   int
+	maxLen   int
+	hasSpace bool
+}
+
+func NewProfiler(headers []string) *Profiler {
+	p := &Profiler{columns: make([]*columnStats, len(headers))}
+	for i, header := range headers {
+		p.columns[i] = &columnStats{name: header, counts: map[string]int{}}
+	}
+	return p
+}
+
+// Add adds a row to the statistics, missing cells count as null
+func (p *Profiler) Add(row []string) {
+	p.rows++
+	for i, column := range p.columns {
+		value := ""
+		if i < len(row) {
+			value = strings.TrimSpace(row[i])
+		}
+		column.add(value)
+	}
+}
+
+// Profile infers the type and statistics of every column from the rows added so far
+func (p *Profiler) Profile() model.DataProfile {
+	profile := model.DataProfile{Rows: p.rows, Columns: make([]model.ColumnProfile, len(p.columns))}
+	for i, column := range p.columns {
+		profile.Columns[i] = column.profile(p.rows)
+	}
+	return profile
+}
+
+// Build infers the type and statistics of every column
+func Build(headers []string, rows [][]string) model.DataProfile {
+	profiler := NewProfiler(headers)
+	for _, row := range rows {
+		profiler.Add(row)
+	}
+	return profiler.Profile()
+}
+
+func (c *columnStats) add(value string) {
+	if IsNull(value) {
+		c.nulls++
+		return
+	}
+	c.nonNull++
+
+	if _, seen := c.counts[value]; seen || len(c.counts) < maxTrackedValues {
+		c.counts[value]++
+	} else {
+		c.capped = true
+	}
+
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		c.floats++
+		if isInteger(value) {
+			c.integers++
+		}
+		if !c.hasNum || number < c.minNum {
+			c.minNum = number
+		}
+		if !c.hasNum || number > c.maxNum {
+			c.maxNum = number
+		}
+		c.hasNum = true
+	} else if t, ok := ParseDate(value); ok {
+		c.dates++
+		if c.minDate.IsZero() || t.Before(c.minDate) {
+			c.minDate = t
+		}
+		if c.maxDate.IsZero() || t.After(c.maxDate) {
+			c.maxDate = t
+		}
+	}
+
+	length := len([]rune(value))
+	c.runes += length
+	if c.nonNull == 1 || length < c.minLen {
+		c.minLen = length
+	}
+	c.maxLen = max(c.maxLen, length)
+	if strings.ContainsAny(value, " \t") {
+		c.hasSpace = true
+	}
+}
+
+func (c *columnStats) profile(rows int) model.ColumnProfile {
+	column := model.ColumnProfile{Name: c.name, Distinct: len(c.counts), DistinctCapped: c.capped}
+	if rows > 0 {
+		column.NullRatio = float64(c.nulls) / float64(rows)
+	}
+	if c.nonNull == 0 {
+		column.Type = model.ColumnEmpty
+		return column
+	}
+
+	share := func(n int) float64 { return float64(n) / float64(c.nonNull) }
+	switch {
+	case share(c.integers) >= typeThreshold:
+		column.Type = model.ColumnInteger
+		if c.unique() && isIDName(c.name) {
+			column.Type = model.ColumnID
+		}
+		column.Min, column.Max = formatNumber(c.minNum), formatNumber(c.maxNum)
+	case share(c.floats) >= typeThreshold:
+		column.Type = model.ColumnFloat
+		column.Min, column.Max = formatNumber(c.minNum), formatNumber(c.maxNum)
+	case share(c.dates) >= typeThreshold:
+		column.Type = model.ColumnDate
+		column.Min, column.Max = c.minDate.Format("2006-01-02"), c.maxDate.Format("2006-01-02")
+	case c.unique() && (isIDName(c.name) || c.looksLikeCode()):
+		column.Type = model.ColumnID
+	case c.isFreeText():
+		column.Type = model.ColumnText
+		column.TopValues = mostFrequent(c.counts)
+	case !c.capped && (column.Distinct <= maxCategories || column.Distinct <= c.nonNull/2):
+		column.Type = model.ColumnCategorical
+		column.TopValues = mostFrequent(c.counts)
+	default:
+		column.Type = model.ColumnText
+		column.TopValues = mostFrequent(c.counts)
+	}
+	return column
+}
+
+// unique reports whether every value occurs once. Once more values occurred than are tracked,
+// a column counts as unique if all tracked values occurred once.
+func (c *columnStats) unique() bool {
+	if c.nonNull < 2 {
+		return false
+	}
+	if !c.capped {
+		return len(c.counts) == c.nonNull
+	}
+	for _, count := range c.counts {
+		if count > 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// looksLikeCode reports whether the values look like generated keys: single words of similar length
+func (c *columnStats) looksLikeCode() bool {
+	return !c.hasSpace && c.minLen >= 6 && c.maxLen-c.minLen <= 2
+}
+
+// isFreeText reports whether the values are long and mostly distinct, like comments or descriptions
+func (c *columnStats) isFreeText() bool {
+	return c.runes/c.nonNull >= 20 && (c.capped || len(c.counts) > c.nonNull/2)
+}
+
+func isInteger(value string) bool {
+	_, err := strconv.ParseInt(value, 10, 64)
+	return err == nil
+}
+
+// IsNull reports whether a cell value is treated as missing
+func IsNull(value string) bool {
+	return nullValues[strings.ToLower(strings.TrimSpace(value))]
+}
+
+// ParseDate parses a value in one of the recognized date formats
+func ParseDate(value string) (time.Time, bool) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// isIDName reports whether a column name suggests an identifier, e.g. "id", "customer_id" or "StudentID"
+func isIDName(name string) bool {
+	normalized := model.NormalizeColumnName(name)
+	return normalized == "id" || strings.HasSuffix(normalized, "_id") || (strings.HasSuffix(normalized, "id") && len(normalized) > 4)
+}
+
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// mostFrequent returns the most frequent values, truncated to keep prompts short
+func mostFrequent(counts map[string]int) []model.ValueCount {
+	values := make([]model.ValueCount, 0, len(counts))
+	for value, count := range counts {
+		values = append(values, model.ValueCount{Value: value, Count: count})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Count != values[j].Count {
+			return values[i].Count > values[j].Count
+		}
+		return values[i].Value < values[j].Value
+	})
+
+	values = values[:min(topValues, len(values))]
+	for i := range values {
+		if runes := []rune(values[i].Value); len(runes) > 50 {
+			values[i].Value = string(runes[:50]) + "..."
+		}
+	}
+	return values
+}
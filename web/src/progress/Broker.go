@@ -0,0 +1,184 @@
+package progress
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+	"web/src/db"
+)
+
+// Event is a progress update of the processing of an insight
+type Event struct {
+	Type    string    `json:"type"`
+	Step    string    `json:"step,omitempty"`
+	Attempt int       `json:"attempt,omitempty"`
+	Retries int       `json:"retries,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// historySize is the number of recent events replayed to new subscribers
+const historySize = 50
+
+// Retention is how long the recent events of an insight are replayed after its last event
+const Retention = 10 * time.Minute
+
+// channel is the Postgres notification channel relaying events between the processes
+const channel = "insight_progress"
+
+// maxErrorLength keeps notifications below the 8000 bytes Postgres allows for a payload
+const maxErrorLength = 2000
+
+var (
+	mutex       sync.Mutex
+	subscribers = make(map[int64]map[chan Event]struct{})
+	history     = make(map[int64][]Event)
+	// relaying is set once Start relays events through Postgres
+	relaying atomic.Bool
+)
+
+// message is the payload of a notification, an event or the request to clear the recent events of an insight
+type message struct {
+	InsightID int64  `json:"insight_id"`
+	Event     *Event `json:"event,omitempty"`
+	Clear     bool   `json:"clear,omitempty"`
+}
+
+// Start relays the events published in any process to the subscribers of this process through Postgres
+// notifications, so jobs run by workers of other processes reach the clients connected to this one.
+// It also drops the recent events of insights without events for Retention. It stops when ctx is done.
+func Start(ctx context.Context) error {
+	listener, err := db.Listen(channel)
+	if err != nil {
+		return err
+	}
+	relaying.Store(true)
+
+	go func() {
+		defer listener.Close()
+		sweep := time.NewTicker(time.Minute)
+		defer sweep.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				relaying.Store(false)
+				return
+			case <-sweep.C:
+				expire(time.Now().Add(-Retention))
+			case notification := <-listener.Notify:
+				// A nil notification reports a reconnect, events sent while disconnected are lost
+				if notification == nil {
+					continue
+				}
+				var received message
+				if err := json.Unmarshal([]byte(notification.Extra), &received); err != nil {
+					log.Println("Invalid progress notification:", err)
+					continue
+				}
+				deliver(received)
+			}
+		}
+	}()
+	return nil
+}
+
+// Publish sends an event to all subscribers of the insight, in all processes once Start was called.
+// Slow subscribers miss events instead of blocking the publisher.
+func Publish(insightID int64, event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if len(event.Error) > maxErrorLength {
+		event.Error = event.Error[:maxErrorLength]
+	}
+	send(message{InsightID: insightID, Event: &event})
+}
+
+// Clear drops the recent events of an insight, e.g. before its processing starts again
+func Clear(insightID int64) {
+	send(message{InsightID: insightID, Clear: true})
+}
+
+// send notifies all processes of the message, or only this one if events aren't relayed
+func send(sent message) {
+	if relaying.Load() {
+		payload, err := json.Marshal(sent)
+		if err == nil {
+			_, err = db.DB().Exec(`SELECT pg_notify($1, $2);`, channel, string(payload))
+		}
+		if err == nil {
+			return
+		}
+		log.Println("Failed to relay progress, delivering it to this process only:", err)
+	}
+	deliver(sent)
+}
+
+// deliver applies a message to the recent events and the subscribers of this process
+func deliver(received message) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	insightID := received.InsightID
+	if received.Clear {
+		delete(history, insightID)
+		return
+	}
+	if received.Event == nil {
+		return
+	}
+	event := *received.Event
+
+	events := append(history[insightID], event)
+	if len(events) > historySize {
+		events = events[len(events)-historySize:]
+	}
+	history[insightID] = events
+
+	for ch := range subscribers[insightID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns the recent events of the insight and a channel receiving its future events.
+// The returned function must be called to unsubscribe.
+func Subscribe(insightID int64) ([]Event, <-chan Event, func()) {
+	ch := make(chan Event, historySize)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if subscribers[insightID] == nil {
+		subscribers[insightID] = make(map[chan Event]struct{})
+	}
+	subscribers[insightID][ch] = struct{}{}
+	recent := append([]Event(nil), history[insightID]...)
+
+	unsubscribe := func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		delete(subscribers[insightID], ch)
+		if len(subscribers[insightID]) == 0 {
+			delete(subscribers, insightID)
+		}
+	}
+	return recent, ch, unsubscribe
+}
+
+// expire drops the recent events of insights whose last event is older than deadline
+func expire(deadline time.Time) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for insightID, events := range history {
+		if len(events) == 0 || events[len(events)-1].Time.Before(deadline) {
+			delete(history, insightID)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package progress
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPublishKeepsRecentEvents(t *testing.T) {
+	const insightID = 1
+	defer Clear(insightID)
+
+	for i := 0; i < historySize+10; i++ {
+		Publish(insightID, Event{Type: "step_started", Attempt: i})
+	}
+
+	recent, _, unsubscribe := Subscribe(insightID)
+	unsubscribe()
+	if len(recent) != historySize {
+		t.Fatalf("%d recent events, want %d", len(recent), historySize)
+	}
+	if recent[0].Attempt != 10 || recent[len(recent)-1].Attempt != historySize+9 {
+		t.Errorf("recent events from attempt %d to %d, want 10 to %d", recent[0].Attempt, recent[len(recent)-1].Attempt, historySize+9)
+	}
+}
+
+func TestExpireDropsIdleInsights(t *testing.T) {
+	now := time.Now()
+	Publish(2, Event{Type: "step_succeeded", Time: now.Add(-2 * Retention)})
+	Publish(3, Event{Type: "step_started", Time: now})
+	defer Clear(3)
+
+	expire(now.Add(-Retention))
+
+	for insightID, want := range map[int64]int{2: 0, 3: 1} {
+		recent, _, unsubscribe := Subscribe(insightID)
+		unsubscribe()
+		if len(recent) != want {
+			t.Errorf("insight %d keeps %d events, want %d", insightID, len(recent), want)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package prompts
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"web/src/util"
+)
+
+// Names of the prompt templates
+const (
+	AnalysisOptions = "analysis_options"
+	AnalysisCode    = "analysis_code"
+	CodeRepair      = "code_repair"
+	ImageExtraction = "image_extraction"
+)
+
+// defaultLibraries are the python libraries installed in the analysis environment, see analysis/Dockerfile
+const defaultLibraries = `numpy==1.23.5
+pandas==1.5.3
+plotly==5.11.0
+scikit-learn==1.2.2`
+
+//go:embed templates
+var templateFiles embed.FS
+
+// Vars are the variables available in the prompt templates
+type Vars struct {
+	Headers           string
+	SampleRows        string
+	Profile           string
+	Sheets            string
+	Libraries         string
+	OptionName        string
+	OptionDescription string
+	ChartType         string
+	Columns           string
+	Code              string
+	Traceback         string
+}
+
+// Prompt is a rendered prompt template
+type Prompt struct {
+	Name    string
+	Version string
+	System  string
+	User    string
+}
+
+// ID identifies the template version that rendered the prompt, e.g. "analysis_code@v1"
+func (p Prompt) ID() string {
+	return p.Name + "@" + p.Version
+}
+
+// partials holds the shared templates that every prompt template can include
+var partials = template.Must(template.New("partials").ParseFS(templateFiles, "templates/partials/*.tmpl"))
+
+// embedded holds the templates compiled into the binary by name and version
+var embedded = loadEmbedded()
+
+func loadEmbedded() map[string]map[string]*template.Template {
+	files, err := fs.Glob(templateFiles, "templates/*.tmpl")
+	if err != nil {
+		panic(err)
+	}
+
+	templates := map[string]map[string]*template.Template{}
+	for _, file := range files {
+		name, version, ok := strings.Cut(strings.TrimSuffix(path.Base(file), ".tmpl"), ".")
+		if !ok {
+			panic(fmt.Sprintf("prompt template %s is not named <name>.<version>.tmpl", file))
+		}
+		body, err := fs.ReadFile(templateFiles, file)
+		if err != nil {
+			panic(err)
+		}
+		tmpl, err := parse(string(body))
+		if err != nil {
+			panic(fmt.Sprintf("invalid prompt template %s: %v", file, err))
+		}
+		if templates[name] == nil {
+			templates[name] = map[string]*template.Template{}
+		}
+		templates[name][version] = tmpl
+	}
+	return templates
+}
+
+// parse parses a template body, which must define a "system" and a "user" template
+func parse(body string) (*template.Template, error) {
+	tmpl, err := template.Must(partials.Clone()).Option("missingkey=error").Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range []string{"system", "user"} {
+		if tmpl.Lookup(part) == nil {
+			return nil, fmt.Errorf("template does not define %q", part)
+		}
+	}
+	return tmpl, nil
+}
+
+// Libraries returns the python libraries of the analysis environment, configured by PYTHON_LIBRARIES as a comma separated list
+func Libraries() string {
+	libraries := util.Env("PYTHON_LIBRARIES")
+	if libraries == "" {
+		return defaultLibraries
+	}
+	return strings.ReplaceAll(libraries, ",", "\n")
+}
+
+// Render renders the active version of the named prompt template
+func Render(name string, vars Vars) (Prompt, error) {
+	version, err := activeVersion(name)
+	if err != nil {
+		return Prompt{}, err
+	}
+	return RenderVersion(name, version, vars)
+}
+
+// RenderVersion renders a specific version of the named prompt template
+func RenderVersion(name string, version string, vars Vars) (Prompt, error) {
+	tmpl, err := lookup(name, version)
+	if err != nil {
+		return Prompt{}, err
+	}
+
+	prompt := Prompt{Name: name, Version: version}
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "system", vars); err != nil {
+		return Prompt{}, fmt.Errorf("failed to render system prompt %s@%s: %w", name, version, err)
+	}
+	prompt.System = buf.String()
+
+	buf.Reset()
+	if err := tmpl.ExecuteTemplate(&buf, "user", vars); err != nil {
+		return Prompt{}, fmt.Errorf("failed to render user prompt %s@%s: %w", name, version, err)
+	}
+	prompt.User = buf.String()
+
+	return prompt, nil
+}
+
+// lookup returns a template version stored in the database, or the embedded one
+func lookup(name string, version string) (*template.Template, error) {
+	body, found, err := storedBody(name, version)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return parse(body)
+	}
+
+	tmpl, ok := embedded[name][version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s@%s", ErrUnknownVersion, name, version)
+	}
+	return tmpl, nil
+}
+
+// activeVersion picks the version of a template to render. PROMPT_<NAME>_VERSION pins a version,
+// otherwise one of the versions activated in the database is picked by weight, so several
+// versions can be compared. Without active versions the latest embedded version is used.
+func activeVersion(name string) (string, error) {
+	if version := util.Env("PROMPT_" + strings.ToUpper(name) + "_VERSION"); version != "" {
+		return version, nil
+	}
+
+	active, err := activeVersions(name)
+	if err != nil {
+		return "", err
+	}
+	if version, ok := pickWeighted(active); ok {
+		return version, nil
+	}
+
+	versions := embeddedVersions(name)
+	if len(versions) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrUnknownVersion, name)
+	}
+	return versions[len(versions)-1], nil
+}
+
+// pickWeighted picks a version with a probability proportional to its weight
+func pickWeighted(versions []Version) (string, bool) {
+	total := 0
+	for _, v := range versions {
+		total += v.Weight
+	}
+	if total <= 0 {
+		return "", false
+	}
+
+	n := rand.Intn(total)
+	for _, v := range versions {
+		if n < v.Weight {
+			return v.Version, true
+		}
+		n -= v.Weight
+	}
+	return "", false
+}
+
+// embeddedVersions returns the embedded versions of a template, oldest first
+func embeddedVersions(name string) []string {
+	var versions []string
+	for version := range embedded[name] {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versionNumber(versions[i]) < versionNumber(versions[j])
+	})
+	return versions
+}
+
+// versionNumber returns the number of a version like "v12", or 0 if it has none
+func versionNumber(version string) int {
+	n, _ := strconv.Atoi(strings.TrimPrefix(version, "v"))
+	return n
+}
@@ -0,0 +1,217 @@
+package prompts
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"time"
+	"web/src/db"
+	"web/src/dbmodel"
+)
+
+var (
+	// ErrUnknownVersion is returned for a template version that is neither embedded nor stored
+	ErrUnknownVersion = errors.New("unknown prompt template version")
+	// ErrVersionExists is returned when storing a version that already exists, versions are immutable
+	ErrVersionExists = errors.New("prompt template version already exists")
+	// ErrInvalidTemplate is returned for templates that can't be parsed and for invalid weights
+	ErrInvalidTemplate = errors.New("invalid prompt template")
+)
+
+// Version describes a version of a prompt template
+type Version struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Source  string `json:"source"`
+	Active  bool   `json:"active"`
+	Weight  int    `json:"weight"`
+}
+
+// storedBody returns the body of a template version stored in the prompt_template table
+func storedBody(name string, version string) (string, bool, error) {
+	if db.DB() == nil {
+		return "", false, nil
+	}
+
+	query := `
+		SELECT body FROM prompt_template
+		WHERE name = $1 AND version = $2 AND body IS NOT NULL;
+	`
+
+	var body string
+	err := db.DB().QueryRow(query, name, version).Scan(&body)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read prompt_template: %w", err)
+	}
+	return body, true, nil
+}
+
+// activeVersions returns the versions of a template that are activated in the prompt_template table
+func activeVersions(name string) ([]Version, error) {
+	if db.DB() == nil {
+		return nil, nil
+	}
+
+	query := `
+		SELECT name, version, body, active, weight, created_at
+		FROM prompt_template
+		WHERE name = $1 AND active;
+	`
+
+	var rows []dbmodel.PromptTemplate
+	err := db.DB().Select(&rows, query, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read active prompt templates: %w", err)
+	}
+
+	versions := make([]Version, 0, len(rows))
+	for _, row := range rows {
+		versions = append(versions, toVersion(row))
+	}
+	return versions, nil
+}
+
+// ListVersions returns the embedded and stored versions of all prompt templates
+func ListVersions() ([]Version, error) {
+	query := `
+		SELECT name, version, body, active, weight, created_at
+		FROM prompt_template;
+	`
+
+	var rows []dbmodel.PromptTemplate
+	err := db.DB().Select(&rows, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list prompt templates: %w", err)
+	}
+
+	versions := map[string]Version{}
+	for name := range embedded {
+		for _, version := range embeddedVersions(name) {
+			versions[name+"@"+version] = Version{Name: name, Version: version, Source: "embedded"}
+		}
+	}
+	for _, row := range rows {
+		versions[row.Name+"@"+row.Version] = toVersion(row)
+	}
+
+	list := make([]Version, 0, len(versions))
+	for _, version := range versions {
+		list = append(list, version)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return versionNumber(list[i].Version) < versionNumber(list[j].Version)
+	})
+	return list, nil
+}
+
+// GetBody returns the template source of a version
+func GetBody(name string, version string) (string, error) {
+	body, found, err := storedBody(name, version)
+	if err != nil {
+		return "", err
+	}
+	if found {
+		return body, nil
+	}
+
+	if _, ok := embedded[name][version]; !ok {
+		return "", fmt.Errorf("%w: %s@%s", ErrUnknownVersion, name, version)
+	}
+	embeddedBody, err := fs.ReadFile(templateFiles, "templates/"+name+"."+version+".tmpl")
+	if err != nil {
+		return "", err
+	}
+	return string(embeddedBody), nil
+}
+
+// SaveVersion stores a new version of a prompt template. The version is not used until it's activated.
+func SaveVersion(name string, version string, body string) error {
+	if _, err := parse(body); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	if _, ok := embedded[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownVersion, name)
+	}
+	if _, ok := embedded[name][version]; ok {
+		return fmt.Errorf("%w: %s@%s", ErrVersionExists, name, version)
+	}
+
+	query := `
+		INSERT INTO prompt_template (name, version, body, active, weight, created_at)
+		VALUES ($1, $2, $3, FALSE, 1, $4)
+		ON CONFLICT (name, version) DO NOTHING;
+	`
+
+	result, err := db.DB().Exec(query, name, version, body, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to insert prompt_template: %w", err)
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to insert prompt_template: %w", err)
+	}
+	if inserted == 0 {
+		return fmt.Errorf("%w: %s@%s", ErrVersionExists, name, version)
+	}
+	return nil
+}
+
+// Activate replaces the active versions of a template by the given versions and their weights.
+// Renders pick one of the active versions with a probability proportional to its weight, an empty
+// map falls back to the latest embedded version.
+func Activate(name string, weights map[string]int) error {
+	for version, weight := range weights {
+		if weight <= 0 {
+			return fmt.Errorf("%w: weight of %s@%s must be positive", ErrInvalidTemplate, name, version)
+		}
+		if _, err := GetBody(name, version); err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.DB().Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE prompt_template SET active = FALSE WHERE name = $1;`, name)
+	if err != nil {
+		return fmt.Errorf("failed to deactivate prompt templates: %w", err)
+	}
+
+	query := `
+		INSERT INTO prompt_template (name, version, active, weight, created_at)
+		VALUES ($1, $2, TRUE, $3, $4)
+		ON CONFLICT (name, version) DO UPDATE SET
+			active = TRUE,
+			weight = EXCLUDED.weight;
+	`
+	for version, weight := range weights {
+		_, err = tx.Exec(query, name, version, weight, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to activate prompt template: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func toVersion(row dbmodel.PromptTemplate) Version {
+	source := "database"
+	if row.Body == nil {
+		source = "embedded"
+	}
+	return Version{Name: row.Name, Version: row.Version, Source: source, Active: row.Active, Weight: row.Weight}
+}
@@ -0,0 +1,327 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/crypto/bcrypt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+	"web/src/db"
+	"web/src/dbmodel"
+	mailer "web/src/mail"
+	"web/src/util"
+)
+
+const userColumns = `user_id, email, COALESCE(name, '') AS name, password_hash, email_verified_at, monthly_token_budget, created_at`
+
+// minPasswordLength is the length of the shortest password accepted on signup
+const minPasswordLength = 8
+
+// loginTokenWindow is the period the login emails per email and per IP are limited in
+const loginTokenWindow = time.Hour
+
+// Purposes of login tokens. Both log in the user, a verification token also confirms the password set on signup.
+const (
+	tokenLogin  = "login"
+	tokenVerify = "verify"
+)
+
+var (
+	// ErrInvalidCredentials is returned for logins with an unknown email or a wrong password
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	// ErrInvalidSignup is returned for signups with an invalid email, a weak password or an email that's taken
+	ErrInvalidSignup = errors.New("invalid signup")
+	// ErrInvalidToken is returned for unknown, expired and used magic link tokens
+	ErrInvalidToken = errors.New("invalid or expired token")
+	// ErrTooManyRequests is returned when too many login emails were requested for an email or from an IP
+	ErrTooManyRequests = errors.New("too many login emails requested, please try again later")
+	// ErrEmailNotVerified is returned for password logins of users who haven't confirmed their email yet
+	ErrEmailNotVerified = errors.New("email not verified, follow the link we sent to confirm it")
+)
+
+// dummyHash is compared against on logins with unknown emails, so they take as long as logins with a wrong password
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// SignUp creates a user who logs in with email and password once the email is verified.
+// The verification email logs in the user, password logins work from then on. The user and the token of the
+// verification email are created in one transaction, a signup exceeding the rate limit creates no user.
+func SignUp(ctx context.Context, email string, password string, name string, ip string) (dbmodel.AppUser, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return dbmodel.AppUser{}, err
+	}
+	if len(password) < minPasswordLength {
+		return dbmodel.AppUser{}, fmt.Errorf("%w: password must have at least %d characters", ErrInvalidSignup, minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return dbmodel.AppUser{}, fmt.Errorf("%w: %v", ErrInvalidSignup, err)
+	}
+
+	tx, err := db.DB().Beginx()
+	if err != nil {
+		return dbmodel.AppUser{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO app_user (email, name, password_hash, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (email) DO NOTHING
+		RETURNING ` + userColumns + `;
+	`
+
+	var user dbmodel.AppUser
+	err = tx.Get(&user, query, email, name, string(hash), time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		return dbmodel.AppUser{}, fmt.Errorf("%w: email is already registered", ErrInvalidSignup)
+	}
+	if err != nil {
+		return dbmodel.AppUser{}, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	token, err := createLoginToken(tx, email, ip, tokenVerify)
+	if err != nil {
+		return dbmodel.AppUser{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return dbmodel.AppUser{}, fmt.Errorf("failed to commit signup: %w", err)
+	}
+
+	// Users whose verification email is lost get it again when they log in
+	if err := sendLoginEmail(ctx, email, token, tokenVerify); err != nil {
+		return dbmodel.AppUser{}, err
+	}
+	return user, nil
+}
+
+// Login returns the user with the email if the password matches. Users who haven't verified their email
+// get the verification email again and ErrEmailNotVerified.
+func Login(ctx context.Context, email string, password string, ip string) (dbmodel.AppUser, error) {
+	user, err := getUserByEmail(strings.ToLower(strings.TrimSpace(email)))
+	if errors.Is(err, sql.ErrNoRows) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return dbmodel.AppUser{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return dbmodel.AppUser{}, err
+	}
+	// Users who signed up with a magic link have no password
+	if user.PasswordHash == nil {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return dbmodel.AppUser{}, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(*user.PasswordHash), []byte(password)) != nil {
+		return dbmodel.AppUser{}, ErrInvalidCredentials
+	}
+	if user.EmailVerifiedAt == nil {
+		if err := sendLoginToken(ctx, user.Email, ip, tokenVerify); err != nil {
+			return dbmodel.AppUser{}, err
+		}
+		return dbmodel.AppUser{}, ErrEmailNotVerified
+	}
+	return user, nil
+}
+
+// SendMagicLink emails a link that logs in the user with the email, users who don't exist yet are
+// created when they follow the link. The link expires after MAGIC_LINK_MINUTES, 15 minutes by default.
+func SendMagicLink(ctx context.Context, email string, ip string) error {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	return sendLoginToken(ctx, email, ip, tokenLogin)
+}
+
+// sendLoginToken emails a link with a new login token for the purpose
+func sendLoginToken(ctx context.Context, email string, ip string, purpose string) error {
+	token, err := createLoginToken(db.DB(), email, ip, purpose)
+	if err != nil {
+		return err
+	}
+	return sendLoginEmail(ctx, email, token, purpose)
+}
+
+// createLoginToken stores a new login token for the purpose and returns it. Within an hour MAGIC_LINK_EMAIL_LIMIT
+// tokens, 5 by default, are created for an email and MAGIC_LINK_IP_LIMIT, 20 by default, are requested from an IP.
+func createLoginToken(ext sqlx.Ext, email string, ip string, purpose string) (string, error) {
+	query := `
+		SELECT COUNT(*) FILTER (WHERE email = $1) AS per_email, COUNT(*) FILTER (WHERE requested_ip = $2) AS per_ip
+		FROM login_token
+		WHERE created_at > $3 AND (email = $1 OR requested_ip = $2);
+	`
+
+	var sent struct {
+		PerEmail int `db:"per_email"`
+		PerIP    int `db:"per_ip"`
+	}
+	err := sqlx.Get(ext, &sent, query, email, ip, time.Now().Add(-loginTokenWindow))
+	if err != nil {
+		return "", fmt.Errorf("failed to count login tokens: %w", err)
+	}
+	if sent.PerEmail >= util.EnvInt("MAGIC_LINK_EMAIL_LIMIT", 5) || sent.PerIP >= util.EnvInt("MAGIC_LINK_IP_LIMIT", 20) {
+		return "", ErrTooManyRequests
+	}
+
+	token, tokenHash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	validity := time.Duration(util.EnvInt("MAGIC_LINK_MINUTES", 15)) * time.Minute
+	now := time.Now()
+
+	query = `
+		INSERT INTO login_token (token_hash, email, purpose, requested_ip, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6);
+	`
+
+	_, err = ext.Exec(query, tokenHash, email, purpose, ip, now, now.Add(validity))
+	if err != nil {
+		return "", fmt.Errorf("failed to create login token: %w", err)
+	}
+	return token, nil
+}
+
+// sendLoginEmail emails the link with a login token for the purpose
+func sendLoginEmail(ctx context.Context, email string, token string, purpose string) error {
+	validity := time.Duration(util.EnvInt("MAGIC_LINK_MINUTES", 15)) * time.Minute
+	link := strings.TrimSuffix(util.Env("BASE_URL"), "/") + "/api/auth/magic-link/verify?token=" + url.QueryEscape(token)
+	message := mailer.Message{
+		To:      email,
+		Subject: "Your login link",
+		Body:    fmt.Sprintf("Follow this link to log in:\n\n%s\n\nThe link expires in %d minutes and can only be used once.", link, int(validity.Minutes())),
+	}
+	if purpose == tokenVerify {
+		message.Subject = "Confirm your email"
+		message.Body = fmt.Sprintf("Follow this link to confirm your email and log in:\n\n%s\n\nThe link expires in %d minutes and can only be used once. "+
+			"If you didn't sign up, ignore this email.", link, int(validity.Minutes()))
+	}
+	return mailer.Send(ctx, message)
+}
+
+// VerifyMagicLink uses up the token of a magic link and returns the user it logs in, creating the user if needed.
+// The link proves that the user owns the email. A login link for a user whose email isn't verified yet removes
+// the password and the sessions of the user, since whoever set the password at signup hasn't proven to own it.
+func VerifyMagicLink(token string) (dbmodel.AppUser, error) {
+	tx, err := db.DB().Beginx()
+	if err != nil {
+		return dbmodel.AppUser{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE login_token
+		SET used_at = $2
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+		RETURNING email, purpose;
+	`
+
+	var loginToken struct {
+		Email   string `db:"email"`
+		Purpose string `db:"purpose"`
+	}
+	now := time.Now()
+	err = tx.Get(&loginToken, query, hashToken(token), now)
+	if errors.Is(err, sql.ErrNoRows) {
+		return dbmodel.AppUser{}, ErrInvalidToken
+	}
+	if err != nil {
+		return dbmodel.AppUser{}, fmt.Errorf("failed to use login token: %w", err)
+	}
+
+	var user dbmodel.AppUser
+	err = tx.Get(&user, `SELECT `+userColumns+` FROM app_user WHERE email = $1 FOR UPDATE;`, loginToken.Email)
+	switch {
+	case errors.Is(err, sql.ErrNoRows) && loginToken.Purpose == tokenVerify:
+		return dbmodel.AppUser{}, ErrInvalidToken
+	case errors.Is(err, sql.ErrNoRows):
+		query = `
+			INSERT INTO app_user (email, email_verified_at, created_at)
+			VALUES ($1, $2, $2)
+			ON CONFLICT (email) DO UPDATE SET email = EXCLUDED.email
+			RETURNING ` + userColumns + `;
+		`
+		err = tx.Get(&user, query, loginToken.Email, now)
+		if err != nil {
+			return dbmodel.AppUser{}, fmt.Errorf("failed to create user: %w", err)
+		}
+	case err != nil:
+		return dbmodel.AppUser{}, fmt.Errorf("failed to get user: %w", err)
+	case user.EmailVerifiedAt == nil && loginToken.Purpose == tokenVerify:
+		err = tx.Get(&user, `UPDATE app_user SET email_verified_at = $2 WHERE user_id = $1 RETURNING `+userColumns+`;`, user.UserID, now)
+		if err != nil {
+			return dbmodel.AppUser{}, fmt.Errorf("failed to verify email: %w", err)
+		}
+	case user.EmailVerifiedAt == nil:
+		query = `
+			UPDATE app_user SET email_verified_at = $2, password_hash = NULL
+			WHERE user_id = $1
+			RETURNING ` + userColumns + `;
+		`
+		err = tx.Get(&user, query, user.UserID, now)
+		if err != nil {
+			return dbmodel.AppUser{}, fmt.Errorf("failed to verify email: %w", err)
+		}
+		_, err = tx.Exec(`DELETE FROM user_session WHERE user_id = $1;`, user.UserID)
+		if err != nil {
+			return dbmodel.AppUser{}, fmt.Errorf("failed to delete sessions: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return dbmodel.AppUser{}, fmt.Errorf("failed to commit login: %w", err)
+	}
+	return user, nil
+}
+
+// GetUser returns the user with the given id
+func GetUser(userID int64) (dbmodel.AppUser, error) {
+	var user dbmodel.AppUser
+	err := db.DB().Get(&user, `SELECT `+userColumns+` FROM app_user WHERE user_id = $1;`, userID)
+	if err != nil {
+		return dbmodel.AppUser{}, fmt.Errorf("failed to get user %d: %w", userID, err)
+	}
+	return user, nil
+}
+
+func getUserByEmail(email string) (dbmodel.AppUser, error) {
+	var user dbmodel.AppUser
+	err := db.DB().Get(&user, `SELECT `+userColumns+` FROM app_user WHERE email = $1;`, email)
+	if err != nil {
+		return dbmodel.AppUser{}, fmt.Errorf("failed to get user: %w", err)
+	}
+	return user, nil
+}
+
+// normalizeEmail checks that the email is a plain address and lower cases it
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return "", fmt.Errorf("%w: invalid email address", ErrInvalidSignup)
+	}
+	return email, nil
+}
+
+// newToken returns a random token for a session or magic link together with the hash it's stored as
+func newToken() (string, string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := hex.EncodeToString(random)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
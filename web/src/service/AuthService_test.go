@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+	"web/src/db/dbtest"
+	"web/src/mail"
+)
+
+func TestThrottledSignUpCreatesNoUser(t *testing.T) {
+	t.Setenv("MAGIC_LINK_EMAIL_LIMIT", "5")
+	conn := &dbtest.Conn{Results: map[string]dbtest.Result{
+		"INSERT INTO app_user": {
+			Columns: []string{"user_id", "email", "name", "password_hash", "email_verified_at", "monthly_token_budget", "created_at"},
+			Rows:    [][]driver.Value{{int64(1), "ada@example.com", "Ada", "hash", nil, nil, time.Now()}},
+		},
+		"FROM login_token": {
+			Columns: []string{"per_email", "per_ip"},
+			Rows:    [][]driver.Value{{int64(5), int64(0)}},
+		},
+	}}
+	dbtest.Use(t, conn)
+
+	var sent []mail.Message
+	mail.SetMailer(mailerFunc(func(ctx context.Context, message mail.Message) error {
+		sent = append(sent, message)
+		return nil
+	}))
+	t.Cleanup(func() { mail.SetMailer(nil) })
+
+	_, err := SignUp(context.Background(), "ada@example.com", "correct horse", "Ada", "192.0.2.1")
+	if !errors.Is(err, ErrTooManyRequests) {
+		t.Fatalf("err = %v, want ErrTooManyRequests", err)
+	}
+	if conn.Committed() || !conn.RolledBack() {
+		t.Errorf("committed %v, rolled back %v, want the signup rolled back", conn.Committed(), conn.RolledBack())
+	}
+	if conn.Ran("INSERT INTO login_token") {
+		t.Errorf("login token created for a throttled signup")
+	}
+	if len(sent) > 0 {
+		t.Errorf("%d emails sent for a throttled signup", len(sent))
+	}
+}
+
+type mailerFunc func(ctx context.Context, message mail.Message) error
+
+func (f mailerFunc) Send(ctx context.Context, message mail.Message) error {
+	return f(ctx, message)
+}
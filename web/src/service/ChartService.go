@@ -0,0 +1,43 @@
+package service
+
+import (
+	"fmt"
+	"time"
+	"web/src/db"
+	"web/src/dbmodel"
+)
+
+// SaveInsightChart stores the rendered Plotly chart of an insight
+func SaveInsightChart(insightID int64, chartData string) error {
+	query := `
+		INSERT INTO insight_chart (insight_id, chart_data, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (insight_id) DO UPDATE SET
+			chart_data = EXCLUDED.chart_data,
+			updated_at = EXCLUDED.updated_at;
+	`
+
+	_, err := db.DB().Exec(query, insightID, chartData, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to insert or update insight_chart: %w", err)
+	}
+
+	return nil
+}
+
+// GetInsightChart returns the rendered Plotly chart of an insight
+func GetInsightChart(insightID int64) (dbmodel.InsightChart, error) {
+	query := `
+		SELECT insight_id, chart_data, updated_at
+		FROM insight_chart
+		WHERE insight_id = $1;
+	`
+
+	var chart dbmodel.InsightChart
+	err := db.DB().Get(&chart, query, insightID)
+	if err != nil {
+		return dbmodel.InsightChart{}, fmt.Errorf("failed to get insight_chart: %w", err)
+	}
+
+	return chart, nil
+}
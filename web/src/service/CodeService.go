@@ -0,0 +1,78 @@
+package service
+
+import (
+	"fmt"
+	"time"
+	"web/src/db"
+	"web/src/dbmodel"
+	"web/src/model"
+)
+
+// SaveInsightCode stores the generated analysis code of an insight together with the prompt version that produced it
+func SaveInsightCode(insightID int64, code model.GeneratedCode) error {
+	query := `
+		INSERT INTO insight_code (insight_id, code, prompt_version, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (insight_id) DO UPDATE SET
+			code = EXCLUDED.code,
+			prompt_version = EXCLUDED.prompt_version,
+			updated_at = EXCLUDED.updated_at;
+	`
+
+	_, err := db.DB().Exec(query, insightID, code.Code, code.PromptVersion, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to insert or update insight_code: %w", err)
+	}
+
+	return nil
+}
+
+// GetInsightCode returns the analysis code of an insight
+func GetInsightCode(insightID int64) (dbmodel.InsightCode, error) {
+	query := `
+		SELECT insight_id, code, prompt_version, updated_at
+		FROM insight_code
+		WHERE insight_id = $1;
+	`
+
+	var code dbmodel.InsightCode
+	err := db.DB().Get(&code, query, insightID)
+	if err != nil {
+		return dbmodel.InsightCode{}, fmt.Errorf("failed to get insight_code: %w", err)
+	}
+
+	return code, nil
+}
+
+// SaveCodeAttempt records an execution of generated code for later inspection
+func SaveCodeAttempt(insightID int64, attempt model.CodeAttempt) error {
+	query := `
+		INSERT INTO insight_code_attempt (insight_id, round, code, error, succeeded, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6);
+	`
+
+	_, err := db.DB().Exec(query, insightID, attempt.Round, attempt.Code, attempt.Error, attempt.Error == "", time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to insert insight_code_attempt: %w", err)
+	}
+
+	return nil
+}
+
+// GetCodeAttempts returns all recorded code executions of an insight, oldest first
+func GetCodeAttempts(insightID int64) ([]dbmodel.InsightCodeAttempt, error) {
+	query := `
+		SELECT attempt_id, insight_id, round, code, error, succeeded, created_at
+		FROM insight_code_attempt
+		WHERE insight_id = $1
+		ORDER BY attempt_id;
+	`
+
+	attempts := []dbmodel.InsightCodeAttempt{}
+	err := db.DB().Select(&attempts, query, insightID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get insight_code_attempt: %w", err)
+	}
+
+	return attempts, nil
+}
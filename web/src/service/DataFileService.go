@@ -1,57 +1,196 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"github.com/lib/pq"
+	"github.com/jmoiron/sqlx"
 	"log"
-	"strings"
 	"time"
 	"web/src/db"
+	"web/src/dbmodel"
+	"web/src/ingest"
 	"web/src/model"
-	"web/src/util"
 )
 
-func SaveInsightData(insightID int64, dataFile model.DataFile) error {
-	// Generate S3 key (unique identifier) based on insightID and current timestamp
-	s3key := fmt.Sprintf("%d%d%s", insightID, time.Now().Unix(), dataFile.Ext)
+// SaveUploadedData stores a file that was streamed to S3 as the data of an insight
+func SaveUploadedData(insightID int64, upload ingest.Upload) error {
+	return saveInsightData(db.DB(), insightID, upload)
+}
+
+// saveInsightData stores the metadata and profile of a stored file
+func saveInsightData(execer sqlx.Execer, insightID int64, upload ingest.Upload) error {
+	// Headers and rows are stored as JSON, fields may contain commas
+	headersJSON, err := json.Marshal(upload.Headers)
+	if err != nil {
+		return fmt.Errorf("failed to encode headers: %w", err)
+	}
+
+	firstRowsJSON, err := json.Marshal(upload.FirstRows)
+	if err != nil {
+		return fmt.Errorf("failed to encode first rows: %w", err)
+	}
+
+	profileJSON, err := json.Marshal(upload.Profile)
+	if err != nil {
+		return fmt.Errorf("failed to encode profile: %w", err)
+	}
 
-	filePath, err := util.UploadToS3(s3key, dataFile.Data)
+	dialectJSON, err := json.Marshal(upload.Format)
 	if err != nil {
-		log.Println("Failed to upload file to S3:", err)
-		return err
+		return fmt.Errorf("failed to encode dialect: %w", err)
 	}
 
-	// Convert Headers and FirstRows to database-friendly formats
-	headers := strings.Join(dataFile.Headers, ",")
-	firstRows := make([]string, len(dataFile.FirstRows))
-	for i, row := range dataFile.FirstRows {
-		firstRows[i] = strings.Join(row, ",")
+	var sheetsJSON interface{}
+	if len(upload.Sheets) > 0 {
+		encoded, err := json.Marshal(upload.Sheets)
+		if err != nil {
+			return fmt.Errorf("failed to encode sheets: %w", err)
+		}
+		sheetsJSON = encoded
 	}
 
 	query := `
-		INSERT INTO insight_data (insight_id, s3key, file_size, file_extension, uploaded_at, headers, first_rows)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO insight_data (insight_id, s3key, file_size, file_extension, uploaded_at, headers, first_rows, profile, dialect, sheets)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 		ON CONFLICT (insight_id) DO UPDATE SET
 			s3key = EXCLUDED.s3key,
 			file_size = EXCLUDED.file_size,
 			file_extension = EXCLUDED.file_extension,
 			uploaded_at = EXCLUDED.uploaded_at,
 			headers = EXCLUDED.headers,
-			first_rows = EXCLUDED.first_rows;
+			first_rows = EXCLUDED.first_rows,
+			profile = EXCLUDED.profile,
+			dialect = EXCLUDED.dialect,
+			sheets = EXCLUDED.sheets;
 	`
 
-	_, err = db.DB().Exec(query,
+	_, err = execer.Exec(query,
 		insightID,
-		s3key,
-		len(dataFile.Data),
-		dataFile.Ext,
+		upload.Key,
+		upload.Size,
+		upload.Ext,
 		time.Now(),
-		headers,
-		pq.Array(firstRows))
+		headersJSON,
+		firstRowsJSON,
+		profileJSON,
+		dialectJSON,
+		sheetsJSON)
 	if err != nil {
 		return fmt.Errorf("failed to insert or update insight_data: %w", err)
 	}
 
-	log.Printf("Data saved to S3 at %s and database updated for insight_id %d", filePath, insightID)
+	return nil
+}
+
+// GetInsightData returns the stored metadata of the data file of an insight
+func GetInsightData(insightID int64) (dbmodel.InsightData, error) {
+	query := `
+		SELECT insight_id, s3key, file_size, file_extension, uploaded_at, headers, first_rows, profile, dialect, sheets
+		FROM insight_data
+		WHERE insight_id = $1;
+	`
+
+	var data dbmodel.InsightData
+	var headersJSON, firstRowsJSON []byte
+	err := db.DB().QueryRow(query, insightID).Scan(
+		&data.InsightID,
+		&data.S3key,
+		&data.FileSize,
+		&data.FileExtension,
+		&data.UploadedAt,
+		&headersJSON,
+		&firstRowsJSON,
+		&data.Profile,
+		&data.Dialect,
+		&data.Sheets)
+	if err != nil {
+		return dbmodel.InsightData{}, fmt.Errorf("failed to get insight_data for insight %d: %w", insightID, err)
+	}
+
+	if headersJSON != nil {
+		err = json.Unmarshal(headersJSON, &data.Headers)
+		if err != nil {
+			return dbmodel.InsightData{}, fmt.Errorf("failed to decode headers of insight %d: %w", insightID, err)
+		}
+	}
+	if firstRowsJSON != nil {
+		err = json.Unmarshal(firstRowsJSON, &data.FirstRows)
+		if err != nil {
+			return dbmodel.InsightData{}, fmt.Errorf("failed to decode first rows of insight %d: %w", insightID, err)
+		}
+	}
+
+	return data, nil
+}
+
+// LoadDataFile restores the DataFile of an insight from the database. The file itself stays in S3,
+// only the stored sample is sent to the LLM and the python environment streams the file from S3.
+func LoadDataFile(ctx context.Context, insightID int64) (model.DataFile, error) {
+	data, err := GetInsightData(insightID)
+	if err != nil {
+		return model.DataFile{}, err
+	}
+
+	dataFile := model.DataFile{
+		Headers:   data.Headers,
+		FirstRows: data.FirstRows,
+		Key:       data.S3key,
+		Ext:       data.FileExtension,
+	}
+
+	if data.Dialect != nil {
+		err = json.Unmarshal(data.Dialect, &dataFile.Format)
+		if err != nil {
+			return model.DataFile{}, fmt.Errorf("failed to decode dialect of insight %d: %w", insightID, err)
+		}
+	}
+	if data.Sheets != nil {
+		err = json.Unmarshal(data.Sheets, &dataFile.Sheets)
+		if err != nil {
+			return model.DataFile{}, fmt.Errorf("failed to decode sheets of insight %d: %w", insightID, err)
+		}
+	}
+	if data.Profile != nil {
+		err = json.Unmarshal(data.Profile, &dataFile.Profile)
+		if err != nil {
+			return model.DataFile{}, fmt.Errorf("failed to decode profile of insight %d: %w", insightID, err)
+		}
+	}
+	if data.Dialect != nil && data.Profile != nil {
+		return dataFile, nil
+	}
+
+	// Data stored before dialects were sniffed is sniffed on every use, data stored before profiling
+	// existed is profiled on first use. Both stream the file from S3.
+	upload, err := ingest.SampleStored(ctx, data.S3key, "data"+data.FileExtension)
+	if err != nil {
+		return model.DataFile{}, fmt.Errorf("failed to sample data of insight %d: %w", insightID, err)
+	}
+	if data.Dialect == nil {
+		dataFile.Format = upload.Format
+		dataFile.Sheets = upload.Sheets
+	}
+	if data.Profile == nil {
+		dataFile.Profile = upload.Profile
+		if err = saveProfile(insightID, dataFile.Profile); err != nil {
+			log.Println(err)
+		}
+	}
+
+	return dataFile, nil
+}
+
+// saveProfile stores the profile of the data file of an insight
+func saveProfile(insightID int64, dataProfile model.DataProfile) error {
+	profileJSON, err := json.Marshal(dataProfile)
+	if err != nil {
+		return fmt.Errorf("failed to encode profile: %w", err)
+	}
+
+	_, err = db.DB().Exec(`UPDATE insight_data SET profile = $2 WHERE insight_id = $1;`, insightID, profileJSON)
+	if err != nil {
+		return fmt.Errorf("failed to update profile of insight_data: %w", err)
+	}
 	return nil
 }
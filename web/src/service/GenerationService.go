@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"web/src/dbmodel"
+	"web/src/llm"
+	"web/src/model"
+	"web/src/ops"
+	"web/src/progress"
+	"web/src/util"
+)
+
+// ErrOperationFailed is returned when an LLM or python operation fails after all retries
+var ErrOperationFailed = errors.New("operation failed")
+
+// GenerateOptions asks the LLM for analysis options for the data of an insight and stores them.
+// The LLM calls of the generation functions are attributed to the user requesting them.
+func GenerateOptions(ctx context.Context, userID int64, insightID int64) ([]dbmodel.AnalysisOption, error) {
+	ctx, err := attributedContext(ctx, userID, insightID)
+	if err != nil {
+		return nil, err
+	}
+
+	dataFile, err := LoadDataFile(ctx, insightID)
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline := ops.NewPipeline().WithListener(progressListener(insightID))
+	options, err := ops.Start(pipeline, &ops.DataAnalysisOptionsOp{}).Execute(ctx, dataFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to generate analysis options: %v", ErrOperationFailed, err)
+	}
+
+	return SaveAnalysisOptions(insightID, options)
+}
+
+// GenerateCode generates and stores the code for the selected analysis option of an insight
+func GenerateCode(ctx context.Context, userID int64, insightID int64) (dbmodel.InsightCode, error) {
+	ctx, err := attributedContext(ctx, userID, insightID)
+	if err != nil {
+		return dbmodel.InsightCode{}, err
+	}
+
+	option, err := GetSelectedOption(insightID)
+	if err != nil {
+		return dbmodel.InsightCode{}, err
+	}
+
+	dataFile, err := LoadDataFile(ctx, insightID)
+	if err != nil {
+		return dbmodel.InsightCode{}, err
+	}
+
+	pipeline := ops.NewPipeline().WithListener(progressListener(insightID))
+	code, err := ops.Start(pipeline, ops.NewAnalysisCodeOp(ToModelOption(option))).Execute(ctx, dataFile)
+	if err != nil {
+		return dbmodel.InsightCode{}, fmt.Errorf("%w: failed to generate analysis code: %v", ErrOperationFailed, err)
+	}
+
+	err = SaveInsightCode(insightID, code)
+	if err != nil {
+		return dbmodel.InsightCode{}, err
+	}
+
+	return GetInsightCode(insightID)
+}
+
+// GenerateChart runs the stored code of an insight against its data file and stores the chart.
+// Failing code is repaired by the LLM and the repaired code replaces the stored one.
+func GenerateChart(ctx context.Context, userID int64, insightID int64) (dbmodel.InsightChart, error) {
+	ctx, err := attributedContext(ctx, userID, insightID)
+	if err != nil {
+		return dbmodel.InsightChart{}, err
+	}
+
+	code, err := GetInsightCode(insightID)
+	if err != nil {
+		return dbmodel.InsightChart{}, err
+	}
+
+	dataFile, err := LoadDataFile(ctx, insightID)
+	if err != nil {
+		return dbmodel.InsightChart{}, err
+	}
+
+	recordAttempt := func(attempt model.CodeAttempt) {
+		if err := SaveCodeAttempt(insightID, attempt); err != nil {
+			log.Println("Failed to record code attempt:", err)
+		}
+	}
+
+	pipeline := ops.NewPipeline().WithListener(progressListener(insightID))
+	result, err := ops.Start(pipeline, ops.NewChartRepairOp(dataFile, RepairRounds(), recordAttempt)).Execute(ctx, code.Code)
+	if err != nil {
+		return dbmodel.InsightChart{}, fmt.Errorf("%w: failed to generate chart: %v", ErrOperationFailed, err)
+	}
+
+	if result.Code != code.Code {
+		// Store the repaired code before the chart, updating the code deletes the chart
+		err = SaveInsightCode(insightID, model.GeneratedCode{Code: result.Code, PromptVersion: result.PromptVersion})
+		if err != nil {
+			return dbmodel.InsightChart{}, err
+		}
+	}
+
+	err = SaveInsightChart(insightID, result.Chart)
+	if err != nil {
+		return dbmodel.InsightChart{}, err
+	}
+
+	return GetInsightChart(insightID)
+}
+
+// RepairRounds returns how often failing chart code is repaired, configured by CODE_REPAIR_ROUNDS, 3 by default
+func RepairRounds() int {
+	return util.EnvInt("CODE_REPAIR_ROUNDS", 3)
+}
+
+// ProcessInsight runs the whole analysis of an insight: it generates the analysis options,
+// selects the first one, and generates its code and chart
+func ProcessInsight(ctx context.Context, userID int64, insightID int64) error {
+	options, err := GenerateOptions(ctx, userID, insightID)
+	if err != nil {
+		return err
+	}
+	if len(options) == 0 {
+		return fmt.Errorf("%w: no analysis options generated", ErrOperationFailed)
+	}
+
+	err = SelectAnalysisOption(insightID, options[0].OptionID)
+	if err != nil {
+		return err
+	}
+
+	_, err = GenerateCode(ctx, userID, insightID)
+	if err != nil {
+		return err
+	}
+
+	_, err = GenerateChart(ctx, userID, insightID)
+	return err
+}
+
+// attributedContext attributes the LLM calls made with the returned context to the insight and the user requesting them.
+// It returns ErrBudgetExceeded if the user used up the monthly token budget.
+func attributedContext(ctx context.Context, userID int64, insightID int64) (context.Context, error) {
+	if err := CheckBudget(userID); err != nil {
+		return ctx, err
+	}
+	return llm.WithAttribution(ctx, llm.Attribution{UserID: userID, InsightID: insightID}), nil
+}
+
+// progressListener publishes the step events of a pipeline as progress events of the insight
+func progressListener(insightID int64) ops.Listener {
+	return func(event ops.Event) {
+		progressEvent := progress.Event{
+			Type:    "step_" + string(event.Type),
+			Step:    event.Step,
+			Attempt: event.Attempt,
+			Retries: event.Retries,
+		}
+		if event.Err != nil {
+			progressEvent.Error = event.Err.Error()
+		}
+		progress.Publish(insightID, progressEvent)
+	}
+}
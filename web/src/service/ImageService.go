@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"log"
+	"time"
+	"web/src/db"
+	"web/src/dbmodel"
+	"web/src/ingest"
+	"web/src/llm"
+	"web/src/model"
+	"web/src/ops"
+	"web/src/util"
+)
+
+const (
+	ExtractionReview    = "review"
+	ExtractionConfirmed = "confirmed"
+)
+
+const extractionColumns = `extraction_id, user_id, s3key, state, extracted_table, document_id, page, insight_id, created_at, updated_at`
+
+// ErrInvalidExtraction is returned for changes to image extractions that were already confirmed
+var ErrInvalidExtraction = errors.New("invalid image extraction")
+
+// ExtractImage extracts the table in an image through the vision model and stores it for review by the user.
+// The image is kept in S3 next to the extracted table.
+func ExtractImage(ctx context.Context, userID int64, image []byte, ext string) (dbmodel.ImageExtraction, error) {
+	if err := CheckBudget(userID); err != nil {
+		return dbmodel.ImageExtraction{}, err
+	}
+	ctx = llm.WithAttribution(ctx, llm.Attribution{UserID: userID})
+
+	table, err := ops.Start(ops.NewPipeline(), &ops.ImageDataExtractionOp{}).Execute(ctx, image)
+	if errors.Is(err, ingest.ErrInvalidFile) {
+		return dbmodel.ImageExtraction{}, err
+	}
+	if err != nil {
+		return dbmodel.ImageExtraction{}, fmt.Errorf("%w: failed to extract data from image: %v", ErrOperationFailed, err)
+	}
+
+	key, err := ingest.NewKey(ext)
+	if err != nil {
+		return dbmodel.ImageExtraction{}, err
+	}
+	if _, err := util.UploadToS3(key, image); err != nil {
+		return dbmodel.ImageExtraction{}, fmt.Errorf("%w: %v", ingest.ErrStorageFailed, err)
+	}
+
+	return insertExtraction(db.DB(), userID, key, table, nil, nil)
+}
+
+// insertExtraction stores an extracted table for review, tables of PDF files are stored with their document and page
+func insertExtraction(queryer sqlx.Queryer, userID int64, key string, table model.Table, documentID *int64, page *int) (dbmodel.ImageExtraction, error) {
+	tableJSON, err := json.Marshal(table)
+	if err != nil {
+		return dbmodel.ImageExtraction{}, fmt.Errorf("failed to encode table: %w", err)
+	}
+
+	query := `
+		INSERT INTO image_extraction (user_id, s3key, state, extracted_table, document_id, page, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+		RETURNING ` + extractionColumns + `;
+	`
+
+	var extraction dbmodel.ImageExtraction
+	err = sqlx.Get(queryer, &extraction, query, userID, key, ExtractionReview, tableJSON, documentID, page, time.Now())
+	if err != nil {
+		return dbmodel.ImageExtraction{}, fmt.Errorf("failed to create image extraction: %w", err)
+	}
+	return extraction, nil
+}
+
+// GetImageExtraction returns an image extraction of the user
+func GetImageExtraction(userID int64, extractionID int64) (dbmodel.ImageExtraction, error) {
+	query := `
+		SELECT ` + extractionColumns + `
+		FROM image_extraction
+		WHERE extraction_id = $1 AND user_id = $2;
+	`
+
+	var extraction dbmodel.ImageExtraction
+	err := db.DB().Get(&extraction, query, extractionID, userID)
+	if err != nil {
+		return dbmodel.ImageExtraction{}, fmt.Errorf("failed to get image extraction %d: %w", extractionID, err)
+	}
+	return extraction, nil
+}
+
+// UpdateImageExtraction replaces the extracted table with the table corrected by the user
+func UpdateImageExtraction(userID int64, extractionID int64, table model.Table) (dbmodel.ImageExtraction, error) {
+	if err := ingest.ValidateTable(table); err != nil {
+		return dbmodel.ImageExtraction{}, err
+	}
+
+	extraction, err := GetImageExtraction(userID, extractionID)
+	if err != nil {
+		return dbmodel.ImageExtraction{}, err
+	}
+	if extraction.State != ExtractionReview {
+		return dbmodel.ImageExtraction{}, fmt.Errorf("%w: extraction is %s", ErrInvalidExtraction, extraction.State)
+	}
+
+	tableJSON, err := json.Marshal(table)
+	if err != nil {
+		return dbmodel.ImageExtraction{}, fmt.Errorf("failed to encode table: %w", err)
+	}
+
+	query := `
+		UPDATE image_extraction
+		SET extracted_table = $1, updated_at = $2
+		WHERE extraction_id = $3 AND state = $4
+		RETURNING ` + extractionColumns + `;
+	`
+
+	err = db.DB().Get(&extraction, query, tableJSON, time.Now(), extractionID, ExtractionReview)
+	if errors.Is(err, sql.ErrNoRows) {
+		return dbmodel.ImageExtraction{}, fmt.Errorf("%w: extraction is already confirmed", ErrInvalidExtraction)
+	}
+	if err != nil {
+		return dbmodel.ImageExtraction{}, fmt.Errorf("failed to update image extraction %d: %w", extractionID, err)
+	}
+	return extraction, nil
+}
+
+// ConfirmImageExtraction stores the reviewed table of an image extraction as CSV data of a new insight.
+// The insight is created in the transaction that confirms the extraction, so an extraction is confirmed once.
+func ConfirmImageExtraction(userID int64, extractionID int64) (int64, error) {
+	extraction, err := GetImageExtraction(userID, extractionID)
+	if err != nil {
+		return 0, err
+	}
+	if extraction.State != ExtractionReview {
+		return 0, fmt.Errorf("%w: extraction is %s", ErrInvalidExtraction, extraction.State)
+	}
+
+	var table model.Table
+	if err := json.Unmarshal(extraction.Table, &table); err != nil {
+		return 0, fmt.Errorf("failed to decode table of image extraction %d: %w", extractionID, err)
+	}
+	upload, err := ingest.StoreTable(table)
+	if err != nil {
+		return 0, err
+	}
+
+	insightID, err := confirmExtraction(userID, extraction, upload)
+	if err != nil {
+		if err := util.DeleteFromS3(upload.Key); err != nil {
+			log.Println("Failed to remove table from S3:", err)
+		}
+		return 0, err
+	}
+	return insightID, nil
+}
+
+// confirmExtraction creates the insight of a stored table and confirms the extraction if it's still in review
+func confirmExtraction(userID int64, extraction dbmodel.ImageExtraction, upload ingest.Upload) (int64, error) {
+	tx, err := db.DB().Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	insightID, err := insertInsight(tx, userID, nil)
+	if err != nil {
+		return 0, err
+	}
+	err = saveInsightData(tx, insightID, upload)
+	if err != nil {
+		return 0, err
+	}
+
+	// A concurrent confirmation waits for this one and then finds the extraction confirmed
+	query := `
+		UPDATE image_extraction
+		SET state = $1, insight_id = $2, updated_at = $3
+		WHERE extraction_id = $4 AND state = $5
+		RETURNING ` + extractionColumns + `;
+	`
+
+	var confirmed dbmodel.ImageExtraction
+	err = tx.Get(&confirmed, query, ExtractionConfirmed, insightID, time.Now(), extraction.ExtractionID, ExtractionReview)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%w: extraction is already confirmed", ErrInvalidExtraction)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to set state of image extraction %d: %w", extraction.ExtractionID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit confirmation of image extraction %d: %w", extraction.ExtractionID, err)
+	}
+	return insightID, nil
+}
@@ -2,26 +2,144 @@ package service
 
 import (
 	"fmt"
+	"github.com/jmoiron/sqlx"
+	"log"
 	"time"
 	"web/src/db"
+	"web/src/dbmodel"
 )
 
-func CreateInsight(userID int64) (int64, error) {
+// insightAccess joins the insights with the roles the user $1 has on them through ownership,
+// workspace membership and sharing. The role is the highest of them, insights without one aren't accessible.
+// Workspace owners get the owner role on the insights of the workspace, the insight still belongs to i.user_id.
+const insightAccess = `
+		SELECT i.insight_id, i.user_id, i.workspace_id, i.created_at, i.updated_at, i.is_deleted,
+			CASE
+				WHEN i.user_id = $1 OR m.role = 'owner' THEN 'owner'
+				WHEN m.role = 'editor' OR s.role = 'editor' THEN 'editor'
+				ELSE 'viewer'
+			END AS role
+		FROM insights i
+		LEFT JOIN workspace_member m ON m.workspace_id = i.workspace_id AND m.user_id = $1
+		LEFT JOIN insight_share s ON s.insight_id = i.insight_id AND s.user_id = $1
+		WHERE i.is_deleted = FALSE AND (i.user_id = $1 OR m.user_id IS NOT NULL OR s.user_id IS NOT NULL)`
+
+// CreateInsight creates an insight owned by the user. Insights created in a workspace are visible to its members,
+// creating them requires the editor role in the workspace.
+func CreateInsight(userID int64, workspaceID *int64) (int64, error) {
+	if workspaceID != nil {
+		if _, err := RequireWorkspaceRole(userID, *workspaceID, RoleEditor); err != nil {
+			return 0, err
+		}
+	}
+
+	return insertInsight(db.DB(), userID, workspaceID)
+}
+
+// insertInsight creates an insight without checking the role of the user on the workspace
+func insertInsight(queryer sqlx.Queryer, userID int64, workspaceID *int64) (int64, error) {
 	createdAt := time.Now()
 	updatedAt := createdAt
 	isDeleted := false
 
 	query := `
-		INSERT INTO insights (user_id, created_at, updated_at, is_deleted)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO insights (user_id, workspace_id, created_at, updated_at, is_deleted)
+		VALUES ($1, $2, $3, $4, $5)
 		RETURNING insight_id;
 	`
 
 	var insightID int64
-	err := db.DB().QueryRow(query, userID, createdAt, updatedAt, isDeleted).Scan(&insightID)
+	err := queryer.QueryRowx(query, userID, workspaceID, createdAt, updatedAt, isDeleted).Scan(&insightID)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create insight: %w", err)
 	}
 
 	return insightID, nil
 }
+
+// discardInsight deletes an insight whose data couldn't be stored
+func discardInsight(insightID int64) {
+	_, err := db.DB().Exec(`UPDATE insights SET is_deleted = TRUE, updated_at = $1 WHERE insight_id = $2;`, time.Now(), insightID)
+	if err != nil {
+		log.Println("Failed to delete insight:", err)
+	}
+}
+
+// GetInsight returns the insight with the given id together with the role of the user on it.
+// Insights the user has no role on aren't found.
+func GetInsight(userID int64, insightID int64) (dbmodel.Insight, error) {
+	query := insightAccess + ` AND i.insight_id = $2;`
+
+	var insight dbmodel.Insight
+	err := db.DB().Get(&insight, query, userID, insightID)
+	if err != nil {
+		return dbmodel.Insight{}, fmt.Errorf("failed to get insight %d: %w", insightID, err)
+	}
+
+	return insight, nil
+}
+
+// ListInsights returns all insights the user has a role on, newest first
+func ListInsights(userID int64) ([]dbmodel.Insight, error) {
+	query := insightAccess + ` ORDER BY i.created_at DESC;`
+
+	insights := []dbmodel.Insight{}
+	err := db.DB().Select(&insights, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list insights: %w", err)
+	}
+
+	return insights, nil
+}
+
+// ListWorkspaceInsights returns the insights of a workspace, newest first
+func ListWorkspaceInsights(userID int64, workspaceID int64) ([]dbmodel.Insight, error) {
+	query := insightAccess + ` AND i.workspace_id = $2 ORDER BY i.created_at DESC;`
+
+	insights := []dbmodel.Insight{}
+	err := db.DB().Select(&insights, query, userID, workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list insights of workspace %d: %w", workspaceID, err)
+	}
+
+	return insights, nil
+}
+
+// MoveInsight moves an insight into a workspace, or back to its owner only if workspaceID is nil.
+// Only the user owning the insight moves it, moving into a workspace requires the editor role in it.
+func MoveInsight(userID int64, insightID int64, workspaceID *int64) error {
+	if workspaceID != nil {
+		if _, err := RequireWorkspaceRole(userID, *workspaceID, RoleEditor); err != nil {
+			return err
+		}
+	}
+
+	query := `
+		UPDATE insights
+		SET workspace_id = $1, updated_at = $2
+		WHERE insight_id = $3 AND user_id = $4 AND is_deleted = FALSE;
+	`
+
+	result, err := db.DB().Exec(query, workspaceID, time.Now(), insightID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to move insight %d: %w", insightID, err)
+	}
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to move insight %d: %w", insightID, err)
+	}
+	if moved == 0 {
+		return fmt.Errorf("%w: only the owner of insight %d moves it", ErrForbidden, insightID)
+	}
+	return nil
+}
+
+// GetInsightUserID returns the id of the user owning an insight
+func GetInsightUserID(insightID int64) (int64, error) {
+	var userID int64
+	err := db.DB().Get(&userID, `SELECT user_id FROM insights WHERE insight_id = $1;`, insightID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get owner of insight %d: %w", insightID, err)
+	}
+	return userID, nil
+}
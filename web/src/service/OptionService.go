@@ -0,0 +1,158 @@
+package service
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+	"web/src/db"
+	"web/src/dbmodel"
+	"web/src/model"
+)
+
+const optionColumns = "option_id, insight_id, name, chart_type, description, columns, created_at"
+
+// SaveAnalysisOptions replaces the analysis options of an insight with newly generated ones.
+// The currently selected option is kept so the selected analysis and its code stay valid.
+func SaveAnalysisOptions(insightID int64, options model.AnalysisOptions) ([]dbmodel.AnalysisOption, error) {
+	deleteQuery := `
+		DELETE FROM analysis_options
+		WHERE insight_id = $1
+		  AND option_id NOT IN (
+			SELECT selected_option_id FROM insight_analysis
+			WHERE insight_id = $1 AND selected_option_id IS NOT NULL
+		  );
+	`
+	insertQuery := `
+		INSERT INTO analysis_options (insight_id, name, chart_type, description, columns, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + optionColumns + `;
+	`
+
+	tx, err := db.DB().Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(deleteQuery, insightID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete previous analysis options: %w", err)
+	}
+
+	createdAt := time.Now()
+	saved := make([]dbmodel.AnalysisOption, 0, len(options.AnalysisOptions))
+	for _, option := range options.AnalysisOptions {
+		var row dbmodel.AnalysisOption
+		err = tx.QueryRowx(insertQuery,
+			insightID,
+			option.Name,
+			option.ChartType,
+			option.Description,
+			pq.Array(option.Columns),
+			createdAt).StructScan(&row)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert analysis option: %w", err)
+		}
+		saved = append(saved, row)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit analysis options: %w", err)
+	}
+
+	return saved, nil
+}
+
+// GetAnalysisOptions returns all analysis options of an insight
+func GetAnalysisOptions(insightID int64) ([]dbmodel.AnalysisOption, error) {
+	query := `
+		SELECT ` + optionColumns + `
+		FROM analysis_options
+		WHERE insight_id = $1
+		ORDER BY option_id;
+	`
+
+	options := []dbmodel.AnalysisOption{}
+	err := db.DB().Select(&options, query, insightID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get analysis options: %w", err)
+	}
+
+	return options, nil
+}
+
+// SelectAnalysisOption marks an option of the insight as its selected analysis.
+// Changing the selection invalidates the insight's code and chart through the trg_analysis_update trigger.
+// It returns sql.ErrNoRows if the option doesn't belong to the insight.
+func SelectAnalysisOption(insightID int64, optionID int64) error {
+	query := `
+		INSERT INTO insight_analysis (insight_id, selected_option_id, updated_at)
+		SELECT insight_id, option_id, $3
+		FROM analysis_options
+		WHERE insight_id = $1 AND option_id = $2
+		ON CONFLICT (insight_id) DO UPDATE SET
+			selected_option_id = EXCLUDED.selected_option_id,
+			updated_at = EXCLUDED.updated_at;
+	`
+
+	result, err := db.DB().Exec(query, insightID, optionID, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to select analysis option: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to select analysis option: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("analysis option %d of insight %d: %w", optionID, insightID, sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+// GetInsightAnalysis returns the selected analysis of an insight
+func GetInsightAnalysis(insightID int64) (dbmodel.InsightAnalysis, error) {
+	query := `
+		SELECT insight_id, selected_option_id, updated_at
+		FROM insight_analysis
+		WHERE insight_id = $1;
+	`
+
+	var analysis dbmodel.InsightAnalysis
+	err := db.DB().Get(&analysis, query, insightID)
+	if err != nil {
+		return dbmodel.InsightAnalysis{}, fmt.Errorf("failed to get insight_analysis: %w", err)
+	}
+
+	return analysis, nil
+}
+
+// GetSelectedOption returns the analysis option selected for an insight
+func GetSelectedOption(insightID int64) (dbmodel.AnalysisOption, error) {
+	query := `
+		SELECT o.option_id, o.insight_id, o.name, o.chart_type, o.description, o.columns, o.created_at
+		FROM insight_analysis a
+		JOIN analysis_options o ON o.option_id = a.selected_option_id
+		WHERE a.insight_id = $1;
+	`
+
+	var option dbmodel.AnalysisOption
+	err := db.DB().Get(&option, query, insightID)
+	if err != nil {
+		return dbmodel.AnalysisOption{}, fmt.Errorf("failed to get selected analysis option: %w", err)
+	}
+
+	return option, nil
+}
+
+// ToModelOption converts a stored analysis option into the representation used by the ops
+func ToModelOption(option dbmodel.AnalysisOption) model.AnalysisOption {
+	return model.AnalysisOption{
+		Name:        option.Name,
+		ChartType:   option.ChartType,
+		Description: option.Description,
+		Columns:     option.Columns,
+	}
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+	"web/src/db"
+	"web/src/dbmodel"
+	"web/src/ingest"
+	"web/src/llm"
+	"web/src/ops"
+	"web/src/util"
+)
+
+const (
+	PDFExtracting = "extracting"
+	PDFReview     = "review"
+	PDFFailed     = "failed"
+)
+
+const pdfColumns = `document_id, user_id, file_name, s3key, pages, state, error, processed_pages, created_at`
+
+// CreatePDFDocument creates the document of a PDF file stored in S3 under key for the extraction of its tables by a job.
+// The stored file is deleted if the document can't be created.
+func CreatePDFDocument(userID int64, fileName string, key string) (dbmodel.PDFDocument, error) {
+	query := `
+		INSERT INTO pdf_document (user_id, file_name, s3key, pages, state, created_at)
+		VALUES ($1, $2, $3, 0, $4, $5)
+		RETURNING ` + pdfColumns + `;
+	`
+
+	var document dbmodel.PDFDocument
+	err := db.DB().Get(&document, query, userID, fileName, key, PDFExtracting, time.Now())
+	if err != nil {
+		if err := util.DeleteFromS3(key); err != nil {
+			log.Println("Failed to delete pdf file:", err)
+		}
+		return dbmodel.PDFDocument{}, fmt.Errorf("failed to create pdf document: %w", err)
+	}
+	return document, nil
+}
+
+// ExtractPDF extracts the tables of a PDF document and stores each of them for review, the user chooses the tables
+// that become insights. Ruled tables are read from the text of the pages, the tables of pages without one are
+// extracted from images of the pages by the vision model. PDF_VISION_PAGES limits the pages sent to the vision model.
+// The document counts the processed pages while the vision model reads them, a failed extraction fails the document.
+func ExtractPDF(ctx context.Context, documentID int64) error {
+	document, err := getPDFDocument(documentID)
+	if err != nil {
+		return err
+	}
+	if document.State != PDFExtracting {
+		// A requeued job of a finished extraction
+		return nil
+	}
+
+	err = extractPDF(ctx, document)
+	if err != nil {
+		FailPDFDocument(documentID, err)
+	}
+	return err
+}
+
+func extractPDF(ctx context.Context, document dbmodel.PDFDocument) error {
+	ctx = llm.WithAttribution(ctx, llm.Attribution{UserID: document.UserID})
+
+	body, err := util.OpenFromS3(document.S3key)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ingest.ErrStorageFailed, err)
+	}
+	extracted, err := ingest.ExtractPDFTables(ctx, body, util.EnvInt("PDF_VISION_PAGES", 10))
+	if err := body.Close(); err != nil {
+		log.Println("Failed to close S3 object body", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Pages with a ruled table and pages beyond PDF_VISION_PAGES are processed already
+	processed := extracted.Pages - len(extracted.Images)
+	setPDFProgress(document.DocumentID, extracted.Pages, processed)
+
+	tables := extracted.Tables
+	for _, page := range extracted.Images {
+		table, err := ops.Start(ops.NewPipeline(), &ops.ImageDataExtractionOp{}).Execute(ctx, page.Image)
+		if errors.Is(err, ingest.ErrInvalidFile) {
+			log.Printf("No table extracted from page %d: %v", page.Page, err)
+		} else if err != nil {
+			return fmt.Errorf("%w: failed to extract data from page %d: %v", ErrOperationFailed, page.Page, err)
+		} else {
+			tables = append(tables, ingest.PDFTable{Page: page.Page, Table: table})
+		}
+		processed++
+		setPDFProgress(document.DocumentID, extracted.Pages, processed)
+	}
+	if len(tables) == 0 {
+		return fmt.Errorf("%w: no tables found in the PDF file", ingest.ErrInvalidFile)
+	}
+	sort.SliceStable(tables, func(i, j int) bool { return tables[i].Page < tables[j].Page })
+
+	tx, err := db.DB().Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, table := range tables {
+		_, err := insertExtraction(tx, document.UserID, document.S3key, table.Table, &document.DocumentID, &table.Page)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `
+		UPDATE pdf_document SET state = $1
+		WHERE document_id = $2 AND state = $3;
+	`
+
+	_, err = tx.Exec(query, PDFReview, document.DocumentID, PDFExtracting)
+	if err != nil {
+		return fmt.Errorf("failed to set state of pdf document %d: %w", document.DocumentID, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit pdf document: %w", err)
+	}
+	return nil
+}
+
+// setPDFProgress stores the number of pages of a document and how many of them are processed
+func setPDFProgress(documentID int64, pages int, processed int) {
+	query := `
+		UPDATE pdf_document SET pages = $1, processed_pages = $2
+		WHERE document_id = $3;
+	`
+
+	_, err := db.DB().Exec(query, pages, processed, documentID)
+	if err != nil {
+		log.Printf("Failed to update progress of pdf document %d: %v", documentID, err)
+	}
+}
+
+// FailPDFDocument marks the extraction of the tables of a document as failed
+func FailPDFDocument(documentID int64, extractErr error) {
+	query := `
+		UPDATE pdf_document SET state = $1, error = $2
+		WHERE document_id = $3 AND state = $4;
+	`
+
+	_, err := db.DB().Exec(query, PDFFailed, extractErr.Error(), documentID, PDFExtracting)
+	if err != nil {
+		log.Printf("Failed to set state of pdf document %d: %v", documentID, err)
+	}
+}
+
+func getPDFDocument(documentID int64) (dbmodel.PDFDocument, error) {
+	query := `
+		SELECT ` + pdfColumns + `
+		FROM pdf_document
+		WHERE document_id = $1;
+	`
+
+	var document dbmodel.PDFDocument
+	err := db.DB().Get(&document, query, documentID)
+	if err != nil {
+		return dbmodel.PDFDocument{}, fmt.Errorf("failed to get pdf document %d: %w", documentID, err)
+	}
+	return document, nil
+}
+
+// GetPDFDocument returns a PDF document of the user together with the tables extracted from it.
+// The tables are stored once the extraction is done, until then the document reports the processed pages.
+func GetPDFDocument(userID int64, documentID int64) (dbmodel.PDFDocument, []dbmodel.ImageExtraction, error) {
+	query := `
+		SELECT ` + pdfColumns + `
+		FROM pdf_document
+		WHERE document_id = $1 AND user_id = $2;
+	`
+
+	var document dbmodel.PDFDocument
+	err := db.DB().Get(&document, query, documentID, userID)
+	if err != nil {
+		return dbmodel.PDFDocument{}, nil, fmt.Errorf("failed to get pdf document %d: %w", documentID, err)
+	}
+
+	query = `
+		SELECT ` + extractionColumns + `
+		FROM image_extraction
+		WHERE document_id = $1
+		ORDER BY page, extraction_id;
+	`
+
+	extractions := []dbmodel.ImageExtraction{}
+	err = db.DB().Select(&extractions, query, documentID)
+	if err != nil {
+		return dbmodel.PDFDocument{}, nil, fmt.Errorf("failed to get tables of pdf document %d: %w", documentID, err)
+	}
+	return document, extractions, nil
+}
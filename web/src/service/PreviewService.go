@@ -0,0 +1,404 @@
+package service
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"web/src/ingest"
+	"web/src/model"
+	"web/src/profile"
+	"web/src/util"
+)
+
+// ErrInvalidPreviewQuery is returned for preview queries on unknown columns or with invalid filters
+var ErrInvalidPreviewQuery = errors.New("invalid preview query")
+
+const (
+	defaultPreviewPageSize = 50
+	maxPreviewPageSize     = 500
+	// maxSortedRows limits how deep sorted previews can be paged, as the rows up to the page are kept in memory
+	maxSortedRows = 10000
+)
+
+// rowFilter reports whether a row matches a filter
+type rowFilter func(row []string) bool
+
+// GetDataPreview streams the stored data file of an insight from S3 and returns a page of its
+// filtered and sorted rows. Only the rows of the requested page are kept in memory, sorted pages
+// keep the rows up to the end of the page. Without filters and sorting reading stops after the page,
+// filtered and sorted previews scan up to previewScanRows rows.
+func GetDataPreview(ctx context.Context, insightID int64, query model.PreviewQuery) (model.DataPreview, error) {
+	data, err := GetInsightData(insightID)
+	if err != nil {
+		return model.DataPreview{}, err
+	}
+
+	dataProfile, err := previewProfile(ctx, insightID, data.Profile)
+	if err != nil {
+		return model.DataPreview{}, err
+	}
+	columns := previewColumns(dataProfile, data.Headers)
+
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.PageSize < 1 {
+		query.PageSize = defaultPreviewPageSize
+	}
+	query.PageSize = min(query.PageSize, maxPreviewPageSize)
+	offset := (query.Page - 1) * query.PageSize
+
+	filters, err := compileFilters(columns, query.Filters)
+	if err != nil {
+		return model.DataPreview{}, err
+	}
+	var page *pageHeap
+	if query.Sort != "" {
+		sortColumn := columnIndex(columns, query.Sort)
+		if sortColumn < 0 {
+			return model.DataPreview{}, fmt.Errorf("%w: unknown sort column %s", ErrInvalidPreviewQuery, query.Sort)
+		}
+		if offset+query.PageSize > maxSortedRows {
+			return model.DataPreview{}, fmt.Errorf("%w: sorted previews show the first %d rows", ErrInvalidPreviewQuery, maxSortedRows)
+		}
+		page = newPageHeap(offset+query.PageSize, rowOrder(columns[sortColumn].Type, sortColumn, query.Descending))
+	}
+	// The profile counts the rows, so unfiltered pages don't need to read the rest of the file
+	unfiltered := page == nil && len(filters) == 0 && dataProfile.Rows > 0
+	scanLimit := previewScanRows()
+
+	body, err := util.OpenFromS3(data.S3key)
+	if err != nil {
+		return model.DataPreview{}, err
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Println("Failed to close S3 object body", err)
+		}
+	}(body)
+
+	format, err := storedFormat(data.FileExtension, data.Dialect)
+	if err != nil {
+		return model.DataPreview{}, err
+	}
+	rows, err := ingest.OpenRows(ctx, body, format)
+	if err != nil {
+		return model.DataPreview{}, err
+	}
+	defer rows.Close()
+
+	preview := model.DataPreview{Columns: columns, Rows: [][]string{}, Page: query.Page, PageSize: query.PageSize}
+	for {
+		if unfiltered && len(preview.Rows) == query.PageSize {
+			break
+		}
+		if !unfiltered && preview.TotalRows == scanLimit {
+			preview.Truncated = true
+			break
+		}
+		row, err := rows.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return model.DataPreview{}, fmt.Errorf("failed to read rows of insight %d: %w", insightID, err)
+		}
+		preview.TotalRows++
+		row = padRow(row, len(columns))
+		if !matchesAll(filters, row) {
+			continue
+		}
+
+		if page != nil {
+			page.add(rankedRow{row: row, position: preview.MatchingRows})
+		} else if preview.MatchingRows >= offset && len(preview.Rows) < query.PageSize {
+			preview.Rows = append(preview.Rows, row)
+		}
+		preview.MatchingRows++
+	}
+
+	if unfiltered {
+		preview.TotalRows, preview.MatchingRows = dataProfile.Rows, dataProfile.Rows
+	}
+	if page != nil {
+		preview.Rows = page.sorted(offset)
+	}
+	return preview, nil
+}
+
+// previewScanRows returns the number of rows filtered and sorted previews scan at most,
+// configured by PREVIEW_SCAN_ROWS, a million by default
+func previewScanRows() int {
+	return util.EnvInt("PREVIEW_SCAN_ROWS", 1000000)
+}
+
+// previewProfile returns the stored profile of a data file. Data stored before profiling existed is profiled first.
+func previewProfile(ctx context.Context, insightID int64, profileJSON json.RawMessage) (model.DataProfile, error) {
+	var dataProfile model.DataProfile
+	if profileJSON != nil {
+		if err := json.Unmarshal(profileJSON, &dataProfile); err != nil {
+			return model.DataProfile{}, fmt.Errorf("failed to decode profile of insight %d: %w", insightID, err)
+		}
+		return dataProfile, nil
+	}
+
+	dataFile, err := LoadDataFile(ctx, insightID)
+	if err != nil {
+		return model.DataProfile{}, err
+	}
+	return dataFile.Profile, nil
+}
+
+// previewColumns returns the columns of a data file with the types inferred by the profiler
+func previewColumns(dataProfile model.DataProfile, headers []string) []model.PreviewColumn {
+	var columns []model.PreviewColumn
+	for _, column := range dataProfile.Columns {
+		columns = append(columns, model.PreviewColumn{Name: column.Name, Type: column.Type})
+	}
+	if len(columns) == 0 {
+		// The file couldn't be profiled, show all columns as text
+		for _, header := range headers {
+			columns = append(columns, model.PreviewColumn{Name: header, Type: model.ColumnText})
+		}
+	}
+	return columns
+}
+
+// rankedRow is a row together with its position among the matching rows
+type rankedRow struct {
+	row      []string
+	position int
+}
+
+// rowOrder returns the sort order of rows by a column. Missing values go last in both directions,
+// rows with equal values keep their order in the file.
+func rowOrder(columnType model.ColumnType, column int, descending bool) func(a, b rankedRow) bool {
+	return func(a, b rankedRow) bool {
+		x, y := a.row[column], b.row[column]
+		if profile.IsNull(x) || profile.IsNull(y) {
+			if profile.IsNull(x) != profile.IsNull(y) {
+				return profile.IsNull(y)
+			}
+			return a.position < b.position
+		}
+		cmp := compareValues(columnType, x, y)
+		if descending {
+			cmp = -cmp
+		}
+		if cmp == 0 {
+			return a.position < b.position
+		}
+		return cmp < 0
+	}
+}
+
+// pageHeap keeps the first size rows in sort order. It's a max-heap, so the root is the row
+// that drops out when an earlier row is added.
+type pageHeap struct {
+	rows   []rankedRow
+	size   int
+	before func(a, b rankedRow) bool
+}
+
+func newPageHeap(size int, before func(a, b rankedRow) bool) *pageHeap {
+	return &pageHeap{size: size, before: before}
+}
+
+func (h *pageHeap) Len() int {
+	return len(h.rows)
+}
+
+func (h *pageHeap) Less(i, j int) bool {
+	return h.before(h.rows[j], h.rows[i])
+}
+
+func (h *pageHeap) Swap(i, j int) {
+	h.rows[i], h.rows[j] = h.rows[j], h.rows[i]
+}
+
+func (h *pageHeap) Push(x any) {
+	h.rows = append(h.rows, x.(rankedRow))
+}
+
+func (h *pageHeap) Pop() any {
+	last := h.rows[len(h.rows)-1]
+	h.rows = h.rows[:len(h.rows)-1]
+	return last
+}
+
+// add keeps the row if it's among the first size rows seen so far
+func (h *pageHeap) add(row rankedRow) {
+	if len(h.rows) < h.size {
+		heap.Push(h, row)
+		return
+	}
+	if h.before(row, h.rows[0]) {
+		h.rows[0] = row
+		heap.Fix(h, 0)
+	}
+}
+
+// sorted returns the kept rows in sort order, starting at offset
+func (h *pageHeap) sorted(offset int) [][]string {
+	sort.Slice(h.rows, func(i, j int) bool { return h.before(h.rows[i], h.rows[j]) })
+	rows := [][]string{}
+	for _, ranked := range h.rows[min(offset, len(h.rows)):] {
+		rows = append(rows, ranked.row)
+	}
+	return rows
+}
+
+// columnIndex finds a column by its name as uploaded or by its normalized name
+func columnIndex(columns []model.PreviewColumn, name string) int {
+	for i, column := range columns {
+		if column.Name == name || model.NormalizeColumnName(column.Name) == model.NormalizeColumnName(name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// compileFilters turns the filters of a query into row filters. A value prefixed with one of
+// =, !=, <, <=, > or >= compares the cells, any other value matches cells containing it.
+func compileFilters(columns []model.PreviewColumn, filters map[string]string) ([]rowFilter, error) {
+	var compiled []rowFilter
+	for name, value := range filters {
+		index := columnIndex(columns, name)
+		if index < 0 {
+			return nil, fmt.Errorf("%w: unknown filter column %s", ErrInvalidPreviewQuery, name)
+		}
+		columnType := columns[index].Type
+
+		operator, operand := splitOperator(value)
+		switch operator {
+		case "":
+			needle := strings.ToLower(value)
+			compiled = append(compiled, func(row []string) bool {
+				return strings.Contains(strings.ToLower(row[index]), needle)
+			})
+		case "=", "!=":
+			equal := operator == "="
+			compiled = append(compiled, func(row []string) bool {
+				return (compareValues(columnType, row[index], operand) == 0) == equal
+			})
+		default:
+			if !isOrdered(columnType) {
+				return nil, fmt.Errorf("%w: %s can't be compared with %s", ErrInvalidPreviewQuery, name, operator)
+			}
+			if !parsesAs(columnType, operand) {
+				return nil, fmt.Errorf("%w: %s is not a valid %s value", ErrInvalidPreviewQuery, operand, columnType)
+			}
+			compiled = append(compiled, func(row []string) bool {
+				if !parsesAs(columnType, row[index]) {
+					return false
+				}
+				cmp := compareValues(columnType, row[index], operand)
+				switch operator {
+				case "<":
+					return cmp < 0
+				case "<=":
+					return cmp <= 0
+				case ">":
+					return cmp > 0
+				default:
+					return cmp >= 0
+				}
+			})
+		}
+	}
+	return compiled, nil
+}
+
+func matchesAll(filters []rowFilter, row []string) bool {
+	for _, filter := range filters {
+		if !filter(row) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitOperator splits a comparison operator from the start of a filter value
+func splitOperator(value string) (string, string) {
+	for _, operator := range []string{"!=", "<=", ">=", "=", "<", ">"} {
+		if strings.HasPrefix(value, operator) {
+			return operator, strings.TrimSpace(strings.TrimPrefix(value, operator))
+		}
+	}
+	return "", value
+}
+
+// isOrdered reports whether values of the type are compared by their value instead of their text
+func isOrdered(columnType model.ColumnType) bool {
+	return columnType == model.ColumnInteger || columnType == model.ColumnFloat || columnType == model.ColumnDate
+}
+
+func parsesAs(columnType model.ColumnType, value string) bool {
+	switch columnType {
+	case model.ColumnInteger, model.ColumnFloat:
+		_, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		return err == nil
+	case model.ColumnDate:
+		_, ok := profile.ParseDate(strings.TrimSpace(value))
+		return ok
+	default:
+		return true
+	}
+}
+
+// compareValues compares two cells by the type of their column, cells that don't parse
+// as the type are compared by their text after the ones that do
+func compareValues(columnType model.ColumnType, a string, b string) int {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	switch columnType {
+	case model.ColumnInteger, model.ColumnFloat:
+		x, errA := strconv.ParseFloat(a, 64)
+		y, errB := strconv.ParseFloat(b, 64)
+		if errA == nil && errB == nil {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+		if errA == nil || errB == nil {
+			return boolOrder(errA == nil)
+		}
+	case model.ColumnDate:
+		x, okA := profile.ParseDate(a)
+		y, okB := profile.ParseDate(b)
+		if okA && okB {
+			return x.Compare(y)
+		}
+		if okA || okB {
+			return boolOrder(okA)
+		}
+	}
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+// boolOrder orders the value that parsed before the one that didn't
+func boolOrder(firstParsed bool) int {
+	if firstParsed {
+		return -1
+	}
+	return 1
+}
+
+// padRow extends short rows with empty cells, Excel omits trailing empty cells
+func padRow(row []string, length int) []string {
+	for len(row) < length {
+		row = append(row, "")
+	}
+	return row
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"web/src/model"
+)
+
+func TestPageHeapKeepsSortedPage(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	var all []rankedRow
+	for i := 0; i < 1000; i++ {
+		value := strconv.Itoa(random.Intn(100))
+		if i%17 == 0 {
+			value = ""
+		}
+		all = append(all, rankedRow{row: []string{strconv.Itoa(i), value}, position: i})
+	}
+
+	for _, descending := range []bool{false, true} {
+		order := rowOrder(model.ColumnInteger, 1, descending)
+		want := append([]rankedRow(nil), all...)
+		sort.SliceStable(want, func(i, j int) bool { return order(want[i], want[j]) })
+
+		for _, offset := range []int{0, 40, 980, 1200} {
+			page := newPageHeap(offset+20, order)
+			for _, row := range all {
+				page.add(row)
+			}
+
+			wantRows := [][]string{}
+			for _, ranked := range want[min(offset, len(want)):min(offset+20, len(want))] {
+				wantRows = append(wantRows, ranked.row)
+			}
+			if got := page.sorted(offset); !reflect.DeepEqual(got, wantRows) {
+				t.Errorf("descending %v, offset %d: page = %v, want %v", descending, offset, got, wantRows)
+			}
+		}
+	}
+}
+
+func TestRowOrderPutsMissingValuesLast(t *testing.T) {
+	rows := []rankedRow{
+		{row: []string{""}, position: 0},
+		{row: []string{"2024-03-01"}, position: 1},
+		{row: []string{"n/a"}, position: 2},
+		{row: []string{"2023-12-24"}, position: 3},
+	}
+	for _, descending := range []bool{false, true} {
+		page := newPageHeap(len(rows), rowOrder(model.ColumnDate, 0, descending))
+		for _, row := range rows {
+			page.add(row)
+		}
+		want := [][]string{{"2023-12-24"}, {"2024-03-01"}, {""}, {"n/a"}}
+		if descending {
+			want = [][]string{{"2024-03-01"}, {"2023-12-24"}, {""}, {"n/a"}}
+		}
+		if got := page.sorted(0); !reflect.DeepEqual(got, want) {
+			t.Errorf("descending %v: rows = %v, want %v", descending, got, want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"web/src/db"
+	"web/src/dbmodel"
+	"web/src/util"
+)
+
+// SessionDuration returns how long sessions last, configured by SESSION_DAYS, 30 days by default
+func SessionDuration() time.Duration {
+	return time.Duration(util.EnvInt("SESSION_DAYS", 30)) * 24 * time.Hour
+}
+
+// CreateSession starts a session of the user and returns its token. Only the hash of the token is stored.
+func CreateSession(userID int64) (string, error) {
+	token, tokenHash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	query := `
+		INSERT INTO user_session (token_hash, user_id, created_at, expires_at)
+		VALUES ($1, $2, $3, $4);
+	`
+
+	_, err = db.DB().Exec(query, tokenHash, userID, now, now.Add(SessionDuration()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create session: %w", err)
+	}
+	return token, nil
+}
+
+// GetSessionUser returns the user of the session with the token, ErrInvalidToken if it's unknown or expired
+func GetSessionUser(token string) (dbmodel.AppUser, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM app_user
+		WHERE user_id = (SELECT user_id FROM user_session WHERE token_hash = $1 AND expires_at > $2);
+	`
+
+	var user dbmodel.AppUser
+	err := db.DB().Get(&user, query, hashToken(token), time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		return dbmodel.AppUser{}, ErrInvalidToken
+	}
+	if err != nil {
+		return dbmodel.AppUser{}, fmt.Errorf("failed to get session: %w", err)
+	}
+	return user, nil
+}
+
+// DeleteSession ends the session with the token
+func DeleteSession(token string) error {
+	_, err := db.DB().Exec(`DELETE FROM user_session WHERE token_hash = $1;`, hashToken(token))
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+// PurgeExpiredSessions removes expired sessions and login tokens. Login tokens are kept for the rate limit
+// of login emails until their window ended.
+func PurgeExpiredSessions() {
+	now := time.Now()
+	if _, err := db.DB().Exec(`DELETE FROM user_session WHERE expires_at < $1;`, now); err != nil {
+		log.Println("Failed to remove expired sessions:", err)
+	}
+	if _, err := db.DB().Exec(`DELETE FROM login_token WHERE expires_at < $1 AND created_at < $2;`, now, now.Add(-loginTokenWindow)); err != nil {
+		log.Println("Failed to remove expired login tokens:", err)
+	}
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"web/src/db"
+	"web/src/dbmodel"
+	"web/src/util"
+)
+
+var (
+	// ErrShareLinksDisabled is returned when no SHARE_LINK_SECRET is configured to sign share links
+	ErrShareLinksDisabled = errors.New("share links are disabled")
+	// ErrInvalidShareLink is returned for share links with an invalid expiry
+	ErrInvalidShareLink = errors.New("invalid share link")
+)
+
+const shareLinkColumns = `link_id, insight_id, created_by, created_at, expires_at, revoked_at, view_count, last_viewed_at`
+
+// CreateShareLink creates a public, read-only link to the chart of an insight. A zero expiresIn creates
+// a link that's valid until it's revoked. The token of the link is signed, see ShareLinkToken.
+func CreateShareLink(insightID int64, userID int64, expiresIn time.Duration) (dbmodel.ShareLink, string, error) {
+	if expiresIn < 0 {
+		return dbmodel.ShareLink{}, "", fmt.Errorf("%w: the expiry must be in the future", ErrInvalidShareLink)
+	}
+	if shareLinkSecret() == "" {
+		return dbmodel.ShareLink{}, "", ErrShareLinksDisabled
+	}
+	// Links are only created for charts that were rendered
+	if _, err := GetInsightChart(insightID); err != nil {
+		return dbmodel.ShareLink{}, "", err
+	}
+
+	now := time.Now()
+	var expiresAt *time.Time
+	if expiresIn > 0 {
+		expiry := now.Add(expiresIn)
+		expiresAt = &expiry
+	}
+
+	query := `
+		INSERT INTO chart_share_link (insight_id, created_by, created_at, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + shareLinkColumns + `;
+	`
+
+	var link dbmodel.ShareLink
+	err := db.DB().Get(&link, query, insightID, userID, now, expiresAt)
+	if err != nil {
+		return dbmodel.ShareLink{}, "", fmt.Errorf("failed to create share link for insight %d: %w", insightID, err)
+	}
+
+	token, err := ShareLinkToken(link.LinkID)
+	return link, token, err
+}
+
+// ListShareLinks returns the share links of an insight, newest first, including revoked and expired links
+func ListShareLinks(insightID int64) ([]dbmodel.ShareLink, error) {
+	query := `
+		SELECT ` + shareLinkColumns + `
+		FROM chart_share_link
+		WHERE insight_id = $1
+		ORDER BY created_at DESC;
+	`
+
+	links := []dbmodel.ShareLink{}
+	err := db.DB().Select(&links, query, insightID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list share links of insight %d: %w", insightID, err)
+	}
+	return links, nil
+}
+
+// RevokeShareLink revokes a share link of an insight, its token stops working immediately
+func RevokeShareLink(insightID int64, linkID int64) error {
+	query := `
+		UPDATE chart_share_link
+		SET revoked_at = $3
+		WHERE link_id = $1 AND insight_id = $2 AND revoked_at IS NULL;
+	`
+
+	result, err := db.DB().Exec(query, linkID, insightID, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to revoke share link %d: %w", linkID, err)
+	}
+	if revoked, _ := result.RowsAffected(); revoked == 0 {
+		return fmt.Errorf("failed to revoke share link %d of insight %d: %w", linkID, insightID, sql.ErrNoRows)
+	}
+	return nil
+}
+
+// ViewSharedChart returns the chart a share token links to and counts the view.
+// Tokens with an invalid signature and revoked or expired links return ErrInvalidToken.
+func ViewSharedChart(token string) (dbmodel.InsightChart, error) {
+	linkID, ok := verifyShareLinkToken(token)
+	if !ok {
+		return dbmodel.InsightChart{}, ErrInvalidToken
+	}
+
+	now := time.Now()
+	query := `
+		UPDATE chart_share_link l
+		SET view_count = l.view_count + 1, last_viewed_at = $2
+		FROM insights i
+		WHERE l.link_id = $1 AND i.insight_id = l.insight_id AND NOT i.is_deleted
+			AND l.revoked_at IS NULL AND (l.expires_at IS NULL OR l.expires_at > $2)
+		RETURNING l.insight_id;
+	`
+
+	var insightID int64
+	err := db.DB().Get(&insightID, query, linkID, now)
+	if errors.Is(err, sql.ErrNoRows) {
+		return dbmodel.InsightChart{}, ErrInvalidToken
+	}
+	if err != nil {
+		return dbmodel.InsightChart{}, fmt.Errorf("failed to count view of share link %d: %w", linkID, err)
+	}
+
+	return GetInsightChart(insightID)
+}
+
+// ShareLinkToken returns the token of a share link: its id signed with an HMAC-SHA256 of SHARE_LINK_SECRET.
+// Tokens aren't stored, so they can be shown again, and changing the secret invalidates all links.
+func ShareLinkToken(linkID int64) (string, error) {
+	secret := shareLinkSecret()
+	if secret == "" {
+		return "", ErrShareLinksDisabled
+	}
+	id := strconv.FormatInt(linkID, 10)
+	return id + "." + signShareLink(secret, id), nil
+}
+
+// verifyShareLinkToken returns the link id of a token with a valid signature
+func verifyShareLinkToken(token string) (int64, bool) {
+	secret := shareLinkSecret()
+	id, signature, found := strings.Cut(token, ".")
+	if secret == "" || !found {
+		return 0, false
+	}
+	if !hmac.Equal([]byte(signature), []byte(signShareLink(secret, id))) {
+		return 0, false
+	}
+	linkID, err := strconv.ParseInt(id, 10, 64)
+	return linkID, err == nil
+}
+
+func signShareLink(secret string, id string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("chart_share_link:" + id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func shareLinkSecret() string {
+	return util.Env("SHARE_LINK_SECRET")
+}
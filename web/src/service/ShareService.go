@@ -0,0 +1,70 @@
+package service
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"web/src/db"
+	"web/src/dbmodel"
+)
+
+// ListInsightShares returns the users an insight is shared with
+func ListInsightShares(insightID int64) ([]dbmodel.Member, error) {
+	query := `
+		SELECT u.user_id, u.email, COALESCE(u.name, '') AS name, s.role, s.created_at
+		FROM insight_share s
+		JOIN app_user u ON u.user_id = s.user_id
+		WHERE s.insight_id = $1
+		ORDER BY s.created_at;
+	`
+
+	shares := []dbmodel.Member{}
+	err := db.DB().Select(&shares, query, insightID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list shares of insight %d: %w", insightID, err)
+	}
+	return shares, nil
+}
+
+// ShareInsight shares an insight with the user with the email as editor or viewer, or changes their role
+func ShareInsight(insightID int64, email string, role string) error {
+	if role != RoleEditor && role != RoleViewer {
+		return fmt.Errorf("%w: insights are shared with editors and viewers", ErrInvalidMembership)
+	}
+	user, err := getUserByEmail(strings.ToLower(strings.TrimSpace(email)))
+	if err != nil {
+		return err
+	}
+	ownerID, err := GetInsightUserID(insightID)
+	if err != nil {
+		return err
+	}
+	if user.UserID == ownerID {
+		return fmt.Errorf("%w: the owner of the insight can't be added", ErrInvalidMembership)
+	}
+
+	query := `
+		INSERT INTO insight_share (insight_id, user_id, role, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (insight_id, user_id) DO UPDATE SET role = EXCLUDED.role;
+	`
+
+	_, err = db.DB().Exec(query, insightID, user.UserID, role, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to share insight %d: %w", insightID, err)
+	}
+	return nil
+}
+
+// UnshareInsight stops sharing an insight with a user
+func UnshareInsight(insightID int64, userID int64) error {
+	result, err := db.DB().Exec(`DELETE FROM insight_share WHERE insight_id = $1 AND user_id = $2;`, insightID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to unshare insight %d: %w", insightID, err)
+	}
+	if removed, _ := result.RowsAffected(); removed == 0 {
+		return fmt.Errorf("failed to unshare insight %d with user %d: %w", insightID, userID, sql.ErrNoRows)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"web/src/ingest"
+	"web/src/model"
+)
+
+// ErrInvalidSheetSelection is returned for sheet selections of files that aren't workbooks or name unknown sheets
+var ErrInvalidSheetSelection = errors.New("invalid sheet selection")
+
+// GetSheets returns the sheets of the workbook of an insight together with the names of the selected sheets
+func GetSheets(insightID int64) ([]model.SheetInfo, []string, error) {
+	data, err := GetInsightData(insightID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sheets, err := storedSheets(data.Sheets)
+	if err != nil {
+		return nil, nil, err
+	}
+	format, err := storedFormat(data.FileExtension, data.Dialect)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	selected := format.Sheets
+	if selected == nil {
+		selected = []string{}
+	}
+	return sheets, selected, nil
+}
+
+// SelectSheets selects the sheets of the workbook of an insight the analysis is based on. The first sheet
+// becomes the data of the insight and is sampled and profiled again. Changing the selection discards
+// the analysis options and everything generated from them.
+func SelectSheets(ctx context.Context, insightID int64, names []string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("%w: select at least one sheet", ErrInvalidSheetSelection)
+	}
+
+	data, err := GetInsightData(insightID)
+	if err != nil {
+		return err
+	}
+	format, err := storedFormat(data.FileExtension, data.Dialect)
+	if err != nil {
+		return err
+	}
+	if format.Name != ingest.FormatExcel {
+		return fmt.Errorf("%w: only Excel workbooks have sheets", ErrInvalidSheetSelection)
+	}
+
+	// Workbooks stored before sheets were enumerated are checked after reading them
+	sheets, err := storedSheets(data.Sheets)
+	if err != nil {
+		return err
+	}
+	if len(sheets) > 0 {
+		if err := checkSheets(sheets, names); err != nil {
+			return err
+		}
+	}
+
+	format.Sheets = names
+	upload, err := ingest.ResampleStored(ctx, data.S3key, data.FileExtension, format)
+	if err != nil {
+		return err
+	}
+	if err := checkSheets(upload.Sheets, names); err != nil {
+		return err
+	}
+
+	return SaveUploadedData(insightID, upload)
+}
+
+// storedFormat decodes the stored format of a file. Files stored before formats were stored have the format of their extension.
+func storedFormat(ext string, dialect json.RawMessage) (model.FileFormat, error) {
+	if dialect == nil {
+		format, err := ingest.DetectFormat(ext, nil)
+		return format.FileFormat, err
+	}
+
+	var format model.FileFormat
+	if err := json.Unmarshal(dialect, &format); err != nil {
+		return model.FileFormat{}, fmt.Errorf("failed to decode dialect: %w", err)
+	}
+	return format, nil
+}
+
+func storedSheets(sheetsJSON json.RawMessage) ([]model.SheetInfo, error) {
+	sheets := []model.SheetInfo{}
+	if sheetsJSON != nil {
+		if err := json.Unmarshal(sheetsJSON, &sheets); err != nil {
+			return nil, fmt.Errorf("failed to decode sheets: %w", err)
+		}
+	}
+	return sheets, nil
+}
+
+// checkSheets checks that all selected sheets exist in the workbook
+func checkSheets(sheets []model.SheetInfo, names []string) error {
+	for _, name := range names {
+		found := false
+		for _, sheet := range sheets {
+			found = found || sheet.Name == name
+		}
+		if !found {
+			return fmt.Errorf("%w: the workbook has no sheet %s", ErrInvalidSheetSelection, name)
+		}
+	}
+	return nil
+}
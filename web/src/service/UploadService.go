@@ -0,0 +1,305 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"web/src/db"
+	"web/src/dbmodel"
+	"web/src/ingest"
+	"web/src/util"
+)
+
+const (
+	UploadOpen       = "open"
+	UploadCompleting = "completing"
+	UploadCompleted  = "completed"
+	UploadAborted    = "aborted"
+	UploadFailed     = "failed"
+)
+
+// MinUploadPartSize is the smallest size S3 accepts for all but the last part of a multipart upload
+const MinUploadPartSize = 5 << 20
+
+// maxUploadParts is the largest part number S3 accepts
+const maxUploadParts = 10000
+
+// ErrInvalidUpload is returned for parts and completions that don't fit the state of an upload session
+var ErrInvalidUpload = errors.New("invalid upload")
+
+// MaxUploadPartSize returns the size limit of a single part, configured by UPLOAD_PART_MAX_MB, 64MB by default
+func MaxUploadPartSize() int64 {
+	return int64(util.EnvInt("UPLOAD_PART_MAX_MB", 64)) << 20
+}
+
+// CreateUploadSession starts a chunked upload of a file, its parts are stored in S3 as they arrive
+func CreateUploadSession(userID int64, fileName string) (dbmodel.UploadSession, error) {
+	ext, err := ingest.FileExtension(fileName)
+	if err != nil {
+		return dbmodel.UploadSession{}, err
+	}
+	key, err := ingest.NewKey(ext)
+	if err != nil {
+		return dbmodel.UploadSession{}, err
+	}
+	s3UploadID, err := util.CreateMultipartUpload(key)
+	if err != nil {
+		return dbmodel.UploadSession{}, fmt.Errorf("%w: %v", ingest.ErrStorageFailed, err)
+	}
+
+	query := `
+		INSERT INTO upload_session (user_id, file_name, s3key, s3_upload_id, state, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6)
+		RETURNING upload_id, user_id, file_name, s3key, s3_upload_id, state, insight_id, created_at, updated_at;
+	`
+
+	var session dbmodel.UploadSession
+	err = db.DB().Get(&session, query, userID, fileName, key, s3UploadID, UploadOpen, time.Now())
+	if err != nil {
+		abortMultipart(key, s3UploadID)
+		return dbmodel.UploadSession{}, fmt.Errorf("failed to create upload session: %w", err)
+	}
+	return session, nil
+}
+
+// GetUploadSession returns an upload session of the user together with the parts received so far
+func GetUploadSession(userID int64, uploadID int64) (dbmodel.UploadSession, []dbmodel.UploadPart, error) {
+	query := `
+		SELECT upload_id, user_id, file_name, s3key, s3_upload_id, state, insight_id, created_at, updated_at
+		FROM upload_session
+		WHERE upload_id = $1 AND user_id = $2;
+	`
+
+	var session dbmodel.UploadSession
+	err := db.DB().Get(&session, query, uploadID, userID)
+	if err != nil {
+		return dbmodel.UploadSession{}, nil, fmt.Errorf("failed to get upload session %d: %w", uploadID, err)
+	}
+
+	parts, err := listUploadParts(uploadID)
+	if err != nil {
+		return dbmodel.UploadSession{}, nil, err
+	}
+	return session, parts, nil
+}
+
+// SaveUploadPart stores a part of a chunked upload. Parts can be sent in any order and sent again
+// to resume a failed upload, a part sent again replaces the earlier one.
+func SaveUploadPart(userID int64, uploadID int64, partNumber int64, data []byte) (dbmodel.UploadPart, error) {
+	if partNumber < 1 || partNumber > maxUploadParts {
+		return dbmodel.UploadPart{}, fmt.Errorf("%w: part number must be between 1 and %d", ErrInvalidUpload, maxUploadParts)
+	}
+	session, parts, err := GetUploadSession(userID, uploadID)
+	if err != nil {
+		return dbmodel.UploadPart{}, err
+	}
+	if session.State != UploadOpen {
+		return dbmodel.UploadPart{}, fmt.Errorf("%w: upload is %s", ErrInvalidUpload, session.State)
+	}
+
+	total := int64(len(data))
+	for _, part := range parts {
+		if part.PartNumber != partNumber {
+			total += part.Size
+		}
+	}
+	if total > ingest.MaxUploadSize() {
+		return dbmodel.UploadPart{}, ingest.ErrFileTooLarge
+	}
+
+	etag, err := util.UploadPart(session.S3key, session.S3UploadID, partNumber, data)
+	if err != nil {
+		return dbmodel.UploadPart{}, fmt.Errorf("%w: %v", ingest.ErrStorageFailed, err)
+	}
+
+	query := `
+		INSERT INTO upload_part (upload_id, part_number, etag, size, uploaded_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (upload_id, part_number)
+		DO UPDATE SET etag = EXCLUDED.etag, size = EXCLUDED.size, uploaded_at = EXCLUDED.uploaded_at
+		RETURNING upload_id, part_number, etag, size, uploaded_at;
+	`
+
+	var part dbmodel.UploadPart
+	err = db.DB().Get(&part, query, uploadID, partNumber, etag, len(data), time.Now())
+	if err != nil {
+		return dbmodel.UploadPart{}, fmt.Errorf("failed to save part %d of upload %d: %w", partNumber, uploadID, err)
+	}
+	touchUploadSession(uploadID)
+	return part, nil
+}
+
+// CompleteUploadSession assembles the parts of a chunked upload and stores the file as a new insight.
+// The parts must be numbered from 1 without gaps. The session is claimed first, so concurrent requests
+// can't complete it twice. Once the parts are assembled any failure removes the file and fails the session.
+func CompleteUploadSession(ctx context.Context, userID int64, uploadID int64) (int64, error) {
+	session, err := claimUploadSession(userID, uploadID, UploadCompleting)
+	if err != nil {
+		return 0, err
+	}
+
+	etags, err := uploadETags(uploadID)
+	if err != nil {
+		// Missing parts can still be sent
+		if err := setUploadState(uploadID, UploadOpen, nil); err != nil {
+			log.Println(err)
+		}
+		return 0, err
+	}
+
+	err = util.CompleteMultipartUpload(session.S3key, session.S3UploadID, etags)
+	if err != nil {
+		abortMultipart(session.S3key, session.S3UploadID)
+		failUpload(session)
+		return 0, fmt.Errorf("%w: %v", ingest.ErrStorageFailed, err)
+	}
+
+	insightID, err := saveCompletedUpload(ctx, userID, session)
+	if err != nil {
+		failUpload(session)
+		return 0, err
+	}
+	err = setUploadState(uploadID, UploadCompleted, &insightID)
+	if err != nil {
+		return 0, err
+	}
+	return insightID, nil
+}
+
+// uploadETags returns the ETags of the parts of an upload by part number after checking that they can be assembled
+func uploadETags(uploadID int64) (map[int64]string, error) {
+	parts, err := listUploadParts(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("%w: no parts were uploaded", ErrInvalidUpload)
+	}
+
+	etags := make(map[int64]string, len(parts))
+	for i, part := range parts {
+		if part.PartNumber != int64(i+1) {
+			return nil, fmt.Errorf("%w: part %d is missing", ErrInvalidUpload, i+1)
+		}
+		if i < len(parts)-1 && part.Size < MinUploadPartSize {
+			return nil, fmt.Errorf("%w: part %d is smaller than %dMB", ErrInvalidUpload, part.PartNumber, MinUploadPartSize>>20)
+		}
+		etags[part.PartNumber] = part.ETag
+	}
+	return etags, nil
+}
+
+// saveCompletedUpload samples the assembled file of an upload and stores it as a new insight
+func saveCompletedUpload(ctx context.Context, userID int64, session dbmodel.UploadSession) (int64, error) {
+	upload, err := ingest.SampleStored(ctx, session.S3key, session.FileName)
+	if err != nil {
+		return 0, err
+	}
+
+	insightID, err := CreateInsight(userID, nil)
+	if err != nil {
+		return 0, err
+	}
+	err = SaveUploadedData(insightID, upload)
+	if err != nil {
+		discardInsight(insightID)
+		return 0, err
+	}
+	return insightID, nil
+}
+
+// failUpload removes the assembled file of an upload and marks the session as failed
+func failUpload(session dbmodel.UploadSession) {
+	if err := util.DeleteFromS3(session.S3key); err != nil {
+		log.Println("Failed to remove uploaded file from S3:", err)
+	}
+	if err := setUploadState(session.UploadID, UploadFailed, nil); err != nil {
+		log.Println(err)
+	}
+}
+
+// AbortUploadSession discards an open upload session and the parts stored in S3
+func AbortUploadSession(userID int64, uploadID int64) error {
+	session, err := claimUploadSession(userID, uploadID, UploadAborted)
+	if err != nil {
+		return err
+	}
+
+	err = util.AbortMultipartUpload(session.S3key, session.S3UploadID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ingest.ErrStorageFailed, err)
+	}
+	return nil
+}
+
+// claimUploadSession moves an open upload session of the user to the given state. Only one request
+// can claim a session, it fails with ErrInvalidUpload if the session isn't open anymore.
+func claimUploadSession(userID int64, uploadID int64, state string) (dbmodel.UploadSession, error) {
+	query := `
+		UPDATE upload_session
+		SET state = $1, updated_at = $2
+		WHERE upload_id = $3 AND user_id = $4 AND state = $5
+		RETURNING upload_id, user_id, file_name, s3key, s3_upload_id, state, insight_id, created_at, updated_at;
+	`
+
+	var session dbmodel.UploadSession
+	err := db.DB().Get(&session, query, state, time.Now(), uploadID, userID, UploadOpen)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Tell a session that isn't open apart from a missing one
+		session, _, err := GetUploadSession(userID, uploadID)
+		if err != nil {
+			return dbmodel.UploadSession{}, err
+		}
+		return dbmodel.UploadSession{}, fmt.Errorf("%w: upload is %s", ErrInvalidUpload, session.State)
+	}
+	if err != nil {
+		return dbmodel.UploadSession{}, fmt.Errorf("failed to claim upload session %d: %w", uploadID, err)
+	}
+	return session, nil
+}
+
+func listUploadParts(uploadID int64) ([]dbmodel.UploadPart, error) {
+	query := `
+		SELECT upload_id, part_number, etag, size, uploaded_at
+		FROM upload_part
+		WHERE upload_id = $1
+		ORDER BY part_number;
+	`
+
+	parts := []dbmodel.UploadPart{}
+	err := db.DB().Select(&parts, query, uploadID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list parts of upload %d: %w", uploadID, err)
+	}
+	return parts, nil
+}
+
+func setUploadState(uploadID int64, state string, insightID *int64) error {
+	query := `
+		UPDATE upload_session
+		SET state = $1, insight_id = $2, updated_at = $3
+		WHERE upload_id = $4;
+	`
+
+	_, err := db.DB().Exec(query, state, insightID, time.Now(), uploadID)
+	if err != nil {
+		return fmt.Errorf("failed to set state of upload %d: %w", uploadID, err)
+	}
+	return nil
+}
+
+func touchUploadSession(uploadID int64) {
+	_, err := db.DB().Exec(`UPDATE upload_session SET updated_at = $1 WHERE upload_id = $2;`, time.Now(), uploadID)
+	if err != nil {
+		log.Println("Failed to update upload session:", err)
+	}
+}
+
+func abortMultipart(key string, s3UploadID string) {
+	if err := util.AbortMultipartUpload(key, s3UploadID); err != nil {
+		log.Println("Failed to abort multipart upload:", err)
+	}
+}
@@ -0,0 +1,288 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"web/src/db"
+	"web/src/llm"
+	"web/src/util"
+)
+
+var (
+	// ErrBudgetExceeded is returned when a user used up the monthly token budget
+	ErrBudgetExceeded = errors.New("monthly token budget exceeded")
+	// ErrInvalidBudget is returned for negative budgets
+	ErrInvalidBudget = errors.New("invalid budget")
+)
+
+// modelPrice is the price in USD per million tokens
+type modelPrice struct {
+	prompt     float64
+	completion float64
+}
+
+// modelPrices are matched by prefix, so dated model versions use the price of their family.
+// Models without a price, like local ones, are free.
+var modelPrices = map[string]modelPrice{
+	"gpt-4o-mini":       {0.15, 0.60},
+	"gpt-4o":            {2.50, 10.00},
+	"claude-3-5-sonnet": {3.00, 15.00},
+	"claude-3-5-haiku":  {0.80, 4.00},
+}
+
+// UsageSummary is the token usage and cost of a group of LLM calls
+type UsageSummary struct {
+	Calls            int     `json:"calls" db:"calls"`
+	PromptTokens     int64   `json:"prompt_tokens" db:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens" db:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd" db:"cost_usd"`
+}
+
+type OperationUsage struct {
+	Operation string `json:"operation" db:"operation"`
+	UsageSummary
+}
+
+type InsightUsage struct {
+	InsightID *int64 `json:"insight_id" db:"insight_id"`
+	UsageSummary
+}
+
+// UserUsage reports the usage of a user in a month
+type UserUsage struct {
+	UserID      int64            `json:"user_id"`
+	Month       string           `json:"month"`
+	Budget      int64            `json:"monthly_token_budget"`
+	Total       UsageSummary     `json:"total"`
+	ByOperation []OperationUsage `json:"by_operation"`
+	ByInsight   []InsightUsage   `json:"by_insight"`
+}
+
+// UserBudget is the monthly token budget of a user together with the tokens used in the current month.
+// MonthlyTokenBudget is the budget set for the user, null if the user gets MONTHLY_TOKEN_BUDGET.
+// Budget is the budget in effect, zero means unlimited.
+type UserBudget struct {
+	UserID             int64  `json:"user_id" db:"user_id"`
+	MonthlyTokenBudget *int64 `json:"monthly_token_budget" db:"monthly_token_budget"`
+	Budget             int64  `json:"budget"`
+	UsedTokens         int64  `json:"used_tokens"`
+}
+
+// RecordUsage stores the usage of an LLM call, it's registered with llm.SetUsageRecorder
+func RecordUsage(record llm.UsageRecord) {
+	query := `
+		INSERT INTO llm_usage (user_id, insight_id, operation, provider, model, prompt_tokens, completion_tokens,
+			cost_usd, latency_ms, retries, cached, succeeded, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13);
+	`
+
+	_, err := db.DB().Exec(query,
+		nullableID(record.Attribution.UserID),
+		nullableID(record.Attribution.InsightID),
+		record.Operation,
+		record.Provider,
+		record.Model,
+		record.PromptTokens,
+		record.CompletionTokens,
+		cost(record.Model, record.PromptTokens, record.CompletionTokens),
+		record.Latency.Milliseconds(),
+		record.Retries,
+		record.Cached,
+		record.Succeeded,
+		time.Now())
+	if err != nil {
+		log.Println("Failed to record LLM usage:", err)
+	}
+}
+
+// GetUserUsage reports the usage of a user in the month starting at monthStart
+func GetUserUsage(userID int64, monthStart time.Time) (UserUsage, error) {
+	monthEnd := monthStart.AddDate(0, 1, 0)
+	usage := UserUsage{
+		UserID:      userID,
+		Month:       monthStart.Format("2006-01"),
+		ByOperation: []OperationUsage{},
+		ByInsight:   []InsightUsage{},
+	}
+
+	budget, err := monthlyTokenBudget(userID)
+	if err != nil {
+		return UserUsage{}, err
+	}
+	usage.Budget = budget
+
+	query := `
+		SELECT ` + usageAggregates + `
+		FROM llm_usage
+		WHERE user_id = $1 AND created_at >= $2 AND created_at < $3;
+	`
+	if err := db.DB().Get(&usage.Total, query, userID, monthStart, monthEnd); err != nil {
+		return UserUsage{}, fmt.Errorf("failed to get usage of user %d: %w", userID, err)
+	}
+
+	query = `
+		SELECT operation, ` + usageAggregates + `
+		FROM llm_usage
+		WHERE user_id = $1 AND created_at >= $2 AND created_at < $3
+		GROUP BY operation
+		ORDER BY operation;
+	`
+	if err := db.DB().Select(&usage.ByOperation, query, userID, monthStart, monthEnd); err != nil {
+		return UserUsage{}, fmt.Errorf("failed to get usage by operation of user %d: %w", userID, err)
+	}
+
+	query = `
+		SELECT insight_id, ` + usageAggregates + `
+		FROM llm_usage
+		WHERE user_id = $1 AND created_at >= $2 AND created_at < $3
+		GROUP BY insight_id
+		ORDER BY insight_id;
+	`
+	if err := db.DB().Select(&usage.ByInsight, query, userID, monthStart, monthEnd); err != nil {
+		return UserUsage{}, fmt.Errorf("failed to get usage by insight of user %d: %w", userID, err)
+	}
+
+	return usage, nil
+}
+
+// GetInsightUsage reports the usage of an insight by operation
+func GetInsightUsage(insightID int64) ([]OperationUsage, error) {
+	query := `
+		SELECT operation, ` + usageAggregates + `
+		FROM llm_usage
+		WHERE insight_id = $1
+		GROUP BY operation
+		ORDER BY operation;
+	`
+
+	usage := []OperationUsage{}
+	if err := db.DB().Select(&usage, query, insightID); err != nil {
+		return nil, fmt.Errorf("failed to get usage of insight %d: %w", insightID, err)
+	}
+	return usage, nil
+}
+
+// CheckBudget returns ErrBudgetExceeded if the user used up the monthly token budget
+func CheckBudget(userID int64) error {
+	budget, err := monthlyTokenBudget(userID)
+	if err != nil {
+		return err
+	}
+	if budget <= 0 {
+		return nil
+	}
+
+	used, err := monthlyTokens(userID)
+	if err != nil {
+		return err
+	}
+	if used >= budget {
+		return fmt.Errorf("%w: %d of %d tokens used", ErrBudgetExceeded, used, budget)
+	}
+	return nil
+}
+
+// GetUserBudget returns the monthly token budget of a user with the tokens used in the current month
+func GetUserBudget(userID int64) (UserBudget, error) {
+	var budget UserBudget
+	err := db.DB().Get(&budget, `SELECT user_id, monthly_token_budget FROM app_user WHERE user_id = $1;`, userID)
+	if err != nil {
+		return UserBudget{}, fmt.Errorf("failed to get token budget of user %d: %w", userID, err)
+	}
+	return withUsedTokens(budget)
+}
+
+// SetUserBudget sets the monthly token budget of a user, zero is unlimited and nil makes the user get MONTHLY_TOKEN_BUDGET
+func SetUserBudget(userID int64, monthlyTokenBudget *int64) (UserBudget, error) {
+	if monthlyTokenBudget != nil && *monthlyTokenBudget < 0 {
+		return UserBudget{}, fmt.Errorf("%w: budget must not be negative", ErrInvalidBudget)
+	}
+
+	query := `
+		UPDATE app_user SET monthly_token_budget = $2
+		WHERE user_id = $1
+		RETURNING user_id, monthly_token_budget;
+	`
+
+	var budget UserBudget
+	if err := db.DB().Get(&budget, query, userID, monthlyTokenBudget); err != nil {
+		return UserBudget{}, fmt.Errorf("failed to set token budget of user %d: %w", userID, err)
+	}
+	return withUsedTokens(budget)
+}
+
+// withUsedTokens completes a budget with the budget in effect and the tokens used in the current month
+func withUsedTokens(budget UserBudget) (UserBudget, error) {
+	budget.Budget = int64(util.EnvInt("MONTHLY_TOKEN_BUDGET", 0))
+	if budget.MonthlyTokenBudget != nil {
+		budget.Budget = *budget.MonthlyTokenBudget
+	}
+
+	used, err := monthlyTokens(budget.UserID)
+	if err != nil {
+		return UserBudget{}, err
+	}
+	budget.UsedTokens = used
+	return budget, nil
+}
+
+// monthlyTokens returns the tokens the user used in the current month
+func monthlyTokens(userID int64) (int64, error) {
+	query := `
+		SELECT COALESCE(SUM(prompt_tokens + completion_tokens), 0)
+		FROM llm_usage
+		WHERE user_id = $1 AND created_at >= $2;
+	`
+
+	var used int64
+	if err := db.DB().Get(&used, query, userID, MonthStart(time.Now())); err != nil {
+		return 0, fmt.Errorf("failed to get monthly tokens of user %d: %w", userID, err)
+	}
+	return used, nil
+}
+
+// MonthStart returns the beginning of the month of t
+func MonthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+const usageAggregates = `
+	COUNT(*) AS calls,
+	COALESCE(SUM(prompt_tokens), 0) AS prompt_tokens,
+	COALESCE(SUM(completion_tokens), 0) AS completion_tokens,
+	COALESCE(SUM(cost_usd), 0) AS cost_usd`
+
+// monthlyTokenBudget returns the budget of the user, or MONTHLY_TOKEN_BUDGET if the user has none.
+// Zero means unlimited.
+func monthlyTokenBudget(userID int64) (int64, error) {
+	var budget *int64
+	err := db.DB().Get(&budget, `SELECT monthly_token_budget FROM app_user WHERE user_id = $1;`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get token budget of user %d: %w", userID, err)
+	}
+	if budget != nil {
+		return *budget, nil
+	}
+	return int64(util.EnvInt("MONTHLY_TOKEN_BUDGET", 0)), nil
+}
+
+func cost(model string, promptTokens int, completionTokens int) float64 {
+	var price modelPrice
+	matched := ""
+	for prefix, p := range modelPrices {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(matched) {
+			price, matched = p, prefix
+		}
+	}
+	return (float64(promptTokens)*price.prompt + float64(completionTokens)*price.completion) / 1_000_000
+}
+
+func nullableID(id int64) *int64 {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"web/src/db"
+	"web/src/dbmodel"
+)
+
+// Roles of users in workspaces and on insights. Viewers read insights, editors also upload data and
+// generate analyses, owners also manage members and sharing.
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+var roleRanks = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleOwner: 3}
+
+var (
+	// ErrForbidden is returned when the role of a user doesn't allow an action
+	ErrForbidden = errors.New("forbidden")
+	// ErrInvalidMembership is returned for unknown roles and for changes that would leave a workspace without owner
+	ErrInvalidMembership = errors.New("invalid membership")
+)
+
+// HasRole reports whether role grants at least the rights of the required role
+func HasRole(role string, required string) bool {
+	return roleRanks[role] > 0 && roleRanks[role] >= roleRanks[required]
+}
+
+// CreateWorkspace creates a workspace with the user as its owner
+func CreateWorkspace(userID int64, name string) (dbmodel.Workspace, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return dbmodel.Workspace{}, fmt.Errorf("%w: name is required", ErrInvalidMembership)
+	}
+
+	tx, err := db.DB().Beginx()
+	if err != nil {
+		return dbmodel.Workspace{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	createdAt := time.Now()
+	query := `
+		INSERT INTO workspace (name, created_by, created_at)
+		VALUES ($1, $2, $3)
+		RETURNING workspace_id, name, created_by, created_at;
+	`
+
+	var workspace dbmodel.Workspace
+	err = tx.Get(&workspace, query, name, userID, createdAt)
+	if err != nil {
+		return dbmodel.Workspace{}, fmt.Errorf("failed to create workspace: %w", err)
+	}
+
+	_, err = tx.Exec(`INSERT INTO workspace_member (workspace_id, user_id, role, created_at) VALUES ($1, $2, $3, $4);`,
+		workspace.WorkspaceID, userID, RoleOwner, createdAt)
+	if err != nil {
+		return dbmodel.Workspace{}, fmt.Errorf("failed to add owner to workspace: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return dbmodel.Workspace{}, fmt.Errorf("failed to commit workspace: %w", err)
+	}
+	workspace.Role = RoleOwner
+	return workspace, nil
+}
+
+// ListWorkspaces returns the workspaces the user is a member of together with the user's role
+func ListWorkspaces(userID int64) ([]dbmodel.Workspace, error) {
+	query := `
+		SELECT w.workspace_id, w.name, w.created_by, m.role, w.created_at
+		FROM workspace w
+		JOIN workspace_member m ON m.workspace_id = w.workspace_id
+		WHERE m.user_id = $1
+		ORDER BY w.name;
+	`
+
+	workspaces := []dbmodel.Workspace{}
+	err := db.DB().Select(&workspaces, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workspaces: %w", err)
+	}
+	return workspaces, nil
+}
+
+// RequireWorkspaceRole returns the workspace if the user has at least the required role in it.
+// Workspaces the user isn't a member of aren't found.
+func RequireWorkspaceRole(userID int64, workspaceID int64, required string) (dbmodel.Workspace, error) {
+	query := `
+		SELECT w.workspace_id, w.name, w.created_by, m.role, w.created_at
+		FROM workspace w
+		JOIN workspace_member m ON m.workspace_id = w.workspace_id
+		WHERE w.workspace_id = $1 AND m.user_id = $2;
+	`
+
+	var workspace dbmodel.Workspace
+	err := db.DB().Get(&workspace, query, workspaceID, userID)
+	if err != nil {
+		return dbmodel.Workspace{}, fmt.Errorf("failed to get workspace %d: %w", workspaceID, err)
+	}
+	if !HasRole(workspace.Role, required) {
+		return dbmodel.Workspace{}, fmt.Errorf("%w: %s role required in workspace %d", ErrForbidden, required, workspaceID)
+	}
+	return workspace, nil
+}
+
+// ListWorkspaceMembers returns the members of a workspace the user is a member of
+func ListWorkspaceMembers(userID int64, workspaceID int64) ([]dbmodel.Member, error) {
+	if _, err := RequireWorkspaceRole(userID, workspaceID, RoleViewer); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT u.user_id, u.email, COALESCE(u.name, '') AS name, m.role, m.created_at
+		FROM workspace_member m
+		JOIN app_user u ON u.user_id = m.user_id
+		WHERE m.workspace_id = $1
+		ORDER BY m.created_at;
+	`
+
+	members := []dbmodel.Member{}
+	err := db.DB().Select(&members, query, workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list members of workspace %d: %w", workspaceID, err)
+	}
+	return members, nil
+}
+
+// SetWorkspaceMember adds the user with the email to a workspace or changes their role, which requires the owner role
+func SetWorkspaceMember(userID int64, workspaceID int64, email string, role string) error {
+	if roleRanks[role] == 0 {
+		return fmt.Errorf("%w: unknown role %s", ErrInvalidMembership, role)
+	}
+	if _, err := RequireWorkspaceRole(userID, workspaceID, RoleOwner); err != nil {
+		return err
+	}
+	member, err := getUserByEmail(strings.ToLower(strings.TrimSpace(email)))
+	if err != nil {
+		return err
+	}
+	if role != RoleOwner {
+		if err := checkRemainingOwner(workspaceID, member.UserID); err != nil {
+			return err
+		}
+	}
+
+	query := `
+		INSERT INTO workspace_member (workspace_id, user_id, role, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (workspace_id, user_id) DO UPDATE SET role = EXCLUDED.role;
+	`
+
+	_, err = db.DB().Exec(query, workspaceID, member.UserID, role, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to set member of workspace %d: %w", workspaceID, err)
+	}
+	return nil
+}
+
+// RemoveWorkspaceMember removes a member from a workspace. Owners remove any member, members remove themselves.
+func RemoveWorkspaceMember(userID int64, workspaceID int64, memberID int64) error {
+	required := RoleOwner
+	if memberID == userID {
+		required = RoleViewer
+	}
+	if _, err := RequireWorkspaceRole(userID, workspaceID, required); err != nil {
+		return err
+	}
+	if err := checkRemainingOwner(workspaceID, memberID); err != nil {
+		return err
+	}
+
+	result, err := db.DB().Exec(`DELETE FROM workspace_member WHERE workspace_id = $1 AND user_id = $2;`, workspaceID, memberID)
+	if err != nil {
+		return fmt.Errorf("failed to remove member of workspace %d: %w", workspaceID, err)
+	}
+	if removed, _ := result.RowsAffected(); removed == 0 {
+		return fmt.Errorf("failed to remove member %d of workspace %d: %w", memberID, workspaceID, sql.ErrNoRows)
+	}
+	return nil
+}
+
+// checkRemainingOwner fails if the member is the last owner of the workspace
+func checkRemainingOwner(workspaceID int64, memberID int64) error {
+	query := `
+		SELECT EXISTS (SELECT 1 FROM workspace_member WHERE workspace_id = $1 AND user_id = $2 AND role = 'owner')
+			AND NOT EXISTS (SELECT 1 FROM workspace_member WHERE workspace_id = $1 AND user_id <> $2 AND role = 'owner');
+	`
+
+	var lastOwner bool
+	if err := db.DB().Get(&lastOwner, query, workspaceID, memberID); err != nil {
+		return fmt.Errorf("failed to check owners of workspace %d: %w", workspaceID, err)
+	}
+	if lastOwner {
+		return fmt.Errorf("%w: a workspace needs at least one owner", ErrInvalidMembership)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/joho/godotenv"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -17,3 +18,17 @@ func LoadEnvVars() {
 func Env(key string) string {
 	return strings.TrimSpace(os.Getenv(key))
 }
+
+// EnvInt returns the integer value of an environment variable, or def if it's unset or invalid
+func EnvInt(key string, def int) int {
+	value := Env(key)
+	if value == "" {
+		return def
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid integer %q for %s, using %d", value, key, def)
+		return def
+	}
+	return i
+}
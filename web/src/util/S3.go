@@ -6,20 +6,18 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"io"
 	"log"
+	"sort"
 )
 
 func UploadToS3(key string, data []byte) (string, error) {
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(Env("AWS_REGION"))},
-	)
+	s3Client, err := newS3Client()
 	if err != nil {
-		log.Println("Error creating s3 session", err)
 		return "", err
 	}
 
-	s3Client := s3.New(sess)
-
 	bucketName := Env("AWS_BUCKET")
 	_, err = s3Client.PutObject(&s3.PutObjectInput{
 		Bucket: aws.String(bucketName),
@@ -33,3 +31,157 @@ func UploadToS3(key string, data []byte) (string, error) {
 	filePath := fmt.Sprintf("s3://%s/%s", bucketName, key)
 	return filePath, nil
 }
+
+// StreamToS3 uploads everything read from body under key without knowing its size in advance.
+// Large bodies are sent as a multipart upload, only a few parts are buffered at a time.
+func StreamToS3(key string, body io.Reader) (string, error) {
+	s3Client, err := newS3Client()
+	if err != nil {
+		return "", err
+	}
+
+	bucketName := Env("AWS_BUCKET")
+	uploader := s3manager.NewUploaderWithClient(s3Client, func(u *s3manager.Uploader) {
+		u.PartSize = int64(EnvInt("S3_PART_SIZE_MB", 16)) << 20
+		u.Concurrency = 2
+	})
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(key),
+		Body:   body,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload file to S3: %w", err)
+	}
+
+	filePath := fmt.Sprintf("s3://%s/%s", bucketName, key)
+	return filePath, nil
+}
+
+// DeleteFromS3 removes the object stored under key
+func DeleteFromS3(key string) error {
+	s3Client, err := newS3Client()
+	if err != nil {
+		return err
+	}
+
+	_, err = s3Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(Env("AWS_BUCKET")),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete file from S3: %w", err)
+	}
+	return nil
+}
+
+// CreateMultipartUpload starts a multipart upload under key and returns its upload id
+func CreateMultipartUpload(key string) (string, error) {
+	s3Client, err := newS3Client()
+	if err != nil {
+		return "", err
+	}
+
+	output, err := s3Client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(Env("AWS_BUCKET")),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+	return aws.StringValue(output.UploadId), nil
+}
+
+// UploadPart uploads a part of a multipart upload and returns its ETag
+func UploadPart(key string, uploadID string, partNumber int64, data []byte) (string, error) {
+	s3Client, err := newS3Client()
+	if err != nil {
+		return "", err
+	}
+
+	output, err := s3Client.UploadPart(&s3.UploadPartInput{
+		Bucket:     aws.String(Env("AWS_BUCKET")),
+		Key:        aws.String(key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int64(partNumber),
+		Body:       bytes.NewReader(data),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload part %d: %w", partNumber, err)
+	}
+	return aws.StringValue(output.ETag), nil
+}
+
+// CompleteMultipartUpload assembles the uploaded parts, given as part numbers mapped to ETags, into the object
+func CompleteMultipartUpload(key string, uploadID string, parts map[int64]string) error {
+	s3Client, err := newS3Client()
+	if err != nil {
+		return err
+	}
+
+	completed := make([]*s3.CompletedPart, 0, len(parts))
+	for partNumber, etag := range parts {
+		completed = append(completed, &s3.CompletedPart{PartNumber: aws.Int64(partNumber), ETag: aws.String(etag)})
+	}
+	sort.Slice(completed, func(i, j int) bool {
+		return *completed[i].PartNumber < *completed[j].PartNumber
+	})
+
+	_, err = s3Client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(Env("AWS_BUCKET")),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	return nil
+}
+
+// AbortMultipartUpload discards a multipart upload and its uploaded parts
+func AbortMultipartUpload(key string, uploadID string) error {
+	s3Client, err := newS3Client()
+	if err != nil {
+		return err
+	}
+
+	_, err = s3Client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(Env("AWS_BUCKET")),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to abort multipart upload: %w", err)
+	}
+	return nil
+}
+
+// OpenFromS3 returns a reader streaming the object stored under key, the caller has to close it
+func OpenFromS3(key string) (io.ReadCloser, error) {
+	s3Client, err := newS3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := s3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(Env("AWS_BUCKET")),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file from S3: %w", err)
+	}
+	return output.Body, nil
+}
+
+func newS3Client() (*s3.S3, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(Env("AWS_REGION"))},
+	)
+	if err != nil {
+		log.Println("Error creating s3 session", err)
+		return nil, err
+	}
+
+	return s3.New(sess), nil
+}